@@ -0,0 +1,262 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const banlistFile = "banlist_%s.dat"
+
+const banThreshold = 100              //不良行为分值达到该值后封禁节点
+const defaultBanTime = 24 * time.Hour //默认封禁时长
+const scoreExpiry = 24 * time.Hour    //节点在该时长内没有新的不良行为，分值清零
+
+// 各种不良行为的分值
+const (
+	scoreMalformedMessage  = 20  //无法解码或格式错误的消息
+	scoreInvalidHeader     = 100 //工作量证明或高度非法的区块头
+	scoreUnconnectedHeader = 10  //父区块未知的区块头，可能是正常的分叉竞争
+	scoreInvalidBlock      = 100 //与区块头不一致的区块
+	scoreInvalidTx         = 10  //非法交易，对方可能只是比我们先看到冲突交易
+	scoreUnsolicited       = 5   //未请求的数据
+)
+
+// BanEntry 一条封禁记录
+type BanEntry struct {
+	Until  int64  //封禁截止时间（Unix时间戳）
+	Reason string //封禁原因
+}
+
+// Bans 封禁列表，保存在文件中，节点重启后仍然有效
+// key为节点地址，可以是host:port形式的节点地址，也可以只是IP（封禁该IP的所有连接）
+type Bans struct {
+	Entries map[string]BanEntry
+}
+
+// NewBans 从文件读取封禁列表，文件不存在时返回空列表
+func NewBans(nodeID string) *Bans {
+	bans := Bans{make(map[string]BanEntry)}
+	bans.LoadFromFile(nodeID)
+
+	return &bans
+}
+
+// LoadFromFile 从文件读取封禁列表
+func (bs *Bans) LoadFromFile(nodeID string) error {
+	banlistFile := fmt.Sprintf(banlistFile, nodeID)
+	if _, err := os.Stat(banlistFile); os.IsNotExist(err) {
+		return err
+	}
+
+	fileContent, err := ioutil.ReadFile(banlistFile)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var bans Bans
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	err = decoder.Decode(&bans)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bs.Entries = bans.Entries
+	if bs.Entries == nil {
+		bs.Entries = make(map[string]BanEntry)
+	}
+
+	return nil
+}
+
+// SaveToFile 保存封禁列表到文件
+func (bs Bans) SaveToFile(nodeID string) {
+	var content bytes.Buffer
+
+	banlistFile := fmt.Sprintf(banlistFile, nodeID)
+
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(bs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = ioutil.WriteFile(banlistFile, content.Bytes(), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// Ban 封禁一个节点，duration为封禁时长
+func (bs *Bans) Ban(address string, duration time.Duration, reason string) {
+	bs.Entries[address] = BanEntry{time.Now().Add(duration).Unix(), reason}
+}
+
+// Unban 解除对一个节点的封禁
+func (bs *Bans) Unban(address string) bool {
+	if _, ok := bs.Entries[address]; !ok {
+		return false
+	}
+	delete(bs.Entries, address)
+
+	return true
+}
+
+// IsBanned 检查节点是否处于封禁期内
+func (bs Bans) IsBanned(address string) bool {
+	entry, ok := bs.Entries[address]
+
+	return ok && entry.Until > time.Now().Unix()
+}
+
+// SweepExpired 删除已经过期的封禁记录，返回是否有记录被删除
+func (bs *Bans) SweepExpired() bool {
+	now := time.Now().Unix()
+	swept := false
+
+	for address, entry := range bs.Entries {
+		if entry.Until <= now {
+			delete(bs.Entries, address)
+			swept = true
+		}
+	}
+
+	return swept
+}
+
+// misbehavior 一个节点累计的不良行为分值
+type misbehavior struct {
+	score int
+	last  time.Time //最近一次不良行为的时间
+}
+
+// banManager 记录每个节点的不良行为分值，分值达到banThreshold时封禁该节点
+// 封禁列表文件是唯一的数据来源，每次修改都是“读取-修改-保存”，这样setban命令对运行中的节点同样有效
+type banManager struct {
+	mtx     sync.Mutex
+	nodeID  string
+	scores  map[string]*misbehavior //peerKey->不良行为分值，只保存在内存中，超过scoreExpiry没有更新的由sweep删除
+	bans    *Bans
+	modTime time.Time //上次读取时封禁列表文件的修改时间
+}
+
+var banman *banManager
+
+// newBanManager 创建banManager并读取封禁列表
+func newBanManager(nodeID string) *banManager {
+	bm := &banManager{nodeID: nodeID, scores: make(map[string]*misbehavior)}
+	bm.reload()
+
+	return bm
+}
+
+// reload 如果封禁列表文件在上次读取后被修改过（如setban命令），重新读取，调用者需持有锁
+func (bm *banManager) reload() {
+	info, err := os.Stat(fmt.Sprintf(banlistFile, bm.nodeID))
+	if err != nil {
+		if bm.bans == nil {
+			bm.bans = NewBans(bm.nodeID)
+		}
+		return
+	}
+
+	if bm.bans == nil || info.ModTime().After(bm.modTime) {
+		bm.bans = NewBans(bm.nodeID)
+		bm.modTime = info.ModTime()
+	}
+}
+
+// save 保存封禁列表并记录文件的修改时间，调用者需持有锁
+func (bm *banManager) save() {
+	bm.bans.SaveToFile(bm.nodeID)
+
+	info, err := os.Stat(fmt.Sprintf(banlistFile, bm.nodeID))
+	if err == nil {
+		bm.modTime = info.ModTime()
+	}
+}
+
+// isBanned 检查节点是否被封禁，address可以是节点地址或IP
+func (bm *banManager) isBanned(address string) bool {
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+
+	bm.reload()
+	if bm.bans.IsBanned(address) {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	return err == nil && bm.bans.IsBanned(host)
+}
+
+// misbehaving 增加节点的不良行为分值，达到阈值时封禁节点，并将该地址上的已知节点移除
+// address为peerKey返回的键，即节点的IP或本机节点的地址，不是连接的临时端口
+func (bm *banManager) misbehaving(address string, score int, reason string) {
+	now := time.Now()
+
+	bm.mtx.Lock()
+	m, ok := bm.scores[address]
+	if !ok || now.Sub(m.last) >= scoreExpiry {
+		m = &misbehavior{}
+		bm.scores[address] = m
+	}
+	m.score += score
+	m.last = now
+	total := m.score
+	fmt.Printf("节点 %s 不良行为: %s (+%d, 共%d)\n", address, reason, score, total)
+
+	banned := total >= banThreshold
+	if banned {
+		bm.reload()
+		bm.bans.Ban(address, defaultBanTime, reason)
+		bm.save()
+		delete(bm.scores, address)
+	}
+	bm.mtx.Unlock()
+
+	if banned {
+		fmt.Printf("封禁节点 %s，时长 %s\n", address, defaultBanTime)
+		for _, node := range getKnownNodes() { //removeNode会修改列表，遍历副本
+			host, _, err := net.SplitHostPort(node)
+			if node == address || (err == nil && host == address) {
+				removeNode(node)
+				syncer.removePeer(node)
+			}
+		}
+	}
+}
+
+// sweep 清除过期的封禁记录和过期的不良行为分值
+func (bm *banManager) sweep() {
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+
+	now := time.Now()
+	for address, m := range bm.scores {
+		if now.Sub(m.last) >= scoreExpiry {
+			delete(bm.scores, address)
+		}
+	}
+
+	bm.reload()
+	if bm.bans.SweepExpired() {
+		bm.save()
+	}
+}
+
+// run 定时清除过期的封禁记录和不良行为分值
+func (bm *banManager) run() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		bm.sweep()
+	}
+}
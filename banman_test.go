@@ -0,0 +1,155 @@
+package blockchain7
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestBanManager 创建使用临时封禁列表的banManager作为全局的banman，knownNodes为已知节点，测试结束时恢复
+func newTestBanManager(t *testing.T, nodes ...string) {
+	oldBanman, oldKnownNodes := banman, knownNodes
+	banman = newBanManager("test")
+	knownNodes = nodes
+	t.Cleanup(func() {
+		banman, knownNodes = oldBanman, oldKnownNodes
+	})
+}
+
+// serveTestMessage 通过环回地址上新的TCP连接把消息交给handleConnection处理，与其他节点发来消息相同
+func serveTestMessage(t *testing.T, bc *Blockchain, request []byte) {
+	ln, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := net.Dial(protocol, ln.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write(request)
+		conn.Close()
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handleConnection(conn, bc)
+}
+
+// remoteConn 远程地址固定的连接，用来构造非环回地址的连接
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestPeerKey(t *testing.T) {
+	local := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 54321}
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 54321}
+	version := func(from string) []byte {
+		return append(commandToBytes("version"), gobEncode(verzion{nodeVersion, 1, from})...)
+	}
+
+	cases := []struct {
+		name     string
+		remote   net.Addr
+		command  string
+		request  []byte
+		expected string
+	}{
+		{"remote connection uses the IP", remote, "version", version("10.0.0.1:3000"), "10.0.0.1"},
+		{"remote sender address is ignored", remote, "version", version("localhost:3001"), "10.0.0.1"},
+		{"local node uses its address", local, "version", version("localhost:3001"), "localhost:3001"},
+		{"local node with loopback IP", local, "version", version("127.0.0.1:3001"), "127.0.0.1:3001"},
+		{"local connection claiming a remote address", local, "version", version("10.0.0.1:3000"), "127.0.0.1"},
+		{"local connection without sender", local, "addr", append(commandToBytes("addr"), gobEncode(addr{})...), "127.0.0.1"},
+		{"local connection with malformed message", local, "version", append(commandToBytes("version"), 1, 2, 3), "127.0.0.1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, peerKey(remoteConn{nil, c.remote}, c.command, c.request))
+		})
+	}
+}
+
+func TestRepeatedMisbehaviourBansAndRemovesPeer(t *testing.T) {
+	bc, _ := newTestChain(t)
+	newTestBanManager(t, "localhost:3000", "localhost:3001", "localhost:3002")
+	syncer.updatePeer("localhost:3001", 5)
+
+	//无法解码的交易，每条消息都使用新的连接和新的端口，分值仍然累计到同一个节点上
+	malformed := func(from string) []byte {
+		return append(commandToBytes("tx"), gobEncode(tx{from, []byte("not a transaction")})...)
+	}
+	for i := 1; i < banThreshold/scoreMalformedMessage; i++ {
+		serveTestMessage(t, bc, malformed("localhost:3001"))
+		assert.Equal(t, i*scoreMalformedMessage, banman.scores["localhost:3001"].score)
+	}
+	assert.False(t, banman.isBanned("localhost:3001"))
+
+	serveTestMessage(t, bc, malformed("localhost:3001"))
+	assert.True(t, banman.isBanned("localhost:3001"))
+	assert.NotContains(t, banman.scores, "localhost:3001")
+	assert.Equal(t, []string{"localhost:3000", "localhost:3002"}, knownNodes, "banned peer is removed")
+	_, ok := syncer.peerHeights["localhost:3001"]
+	assert.False(t, ok)
+
+	//其他本地节点不受影响，封禁记录保存在文件中
+	assert.False(t, banman.isBanned("localhost:3002"))
+	assert.False(t, banman.isBanned("127.0.0.1"))
+	assert.True(t, NewBans("test").IsBanned("localhost:3001"))
+
+	//被封禁节点的消息直接丢弃，不再计分
+	serveTestMessage(t, bc, malformed("localhost:3001"))
+	assert.NotContains(t, banman.scores, "localhost:3001")
+}
+
+func TestConcurrentBansUpdateKnownNodes(t *testing.T) {
+	newTestChain(t)
+	newTestBanManager(t, "localhost:3000")
+
+	//多个连接协程同时加入和封禁节点，每个被封禁的节点都从已知节点列表中删除
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			addKnownNodes(host + ":3000")
+			banman.misbehaving(host, banThreshold, "test")
+		}(fmt.Sprintf("10.0.0.%d", i))
+	}
+	wg.Wait()
+	assert.Equal(t, []string{"localhost:3000"}, getKnownNodes())
+}
+
+func TestMisbehaviourScoreExpires(t *testing.T) {
+	newTestChain(t)
+	newTestBanManager(t)
+
+	banman.misbehaving("10.0.0.1", banThreshold-1, "test")
+	banman.misbehaving("10.0.0.2", 1, "test")
+	banman.scores["10.0.0.1"].last = time.Now().Add(-scoreExpiry)
+
+	//过期的分值重新开始累计
+	banman.misbehaving("10.0.0.1", 1, "test")
+	assert.False(t, banman.isBanned("10.0.0.1"))
+	assert.Equal(t, 1, banman.scores["10.0.0.1"].score)
+
+	//sweep删除过期的分值，分值表不会无限增长
+	banman.scores["10.0.0.1"].last = time.Now().Add(-scoreExpiry)
+	banman.sweep()
+	assert.NotContains(t, banman.scores, "10.0.0.1")
+	assert.Contains(t, banman.scores, "10.0.0.2")
+}
@@ -7,7 +7,9 @@ import (
 
 var b58Alphabet = []byte("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
 
-// Base58Encode 将字节数组编码为Base58（人可以认识）
+// Base58Encode 将字节数组编码为Base58（人可以认识），每个前导的0字节编码为一个'1'，与比特币相同
+//旧版本不论输入的第一个字节是什么都只在前面加一个'1'，解码时总是把第一个字符当作0字节，
+//版本字节不为0的P2SH地址和多重签名地址解码后会多出一个0字节，旧写法的普通地址见decodeAddress
 func Base58Encode(input []byte) []byte {
 	var result []byte
 
@@ -23,7 +25,7 @@ func Base58Encode(input []byte) []byte {
 	}
 
 	ReverseBytes(result)
-	for b := range input {
+	for _, b := range input { //每个前导的0字节编码为一个'1'
 		if b == 0x00 {
 			result = append([]byte{b58Alphabet[0]}, result...)
 		} else {
@@ -39,9 +41,11 @@ func Base58Decode(input []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
-		if b == 0x00 {
+	for _, b := range input { //每个前导的'1'解码为一个0字节
+		if b == b58Alphabet[0] {
 			zeroBytes++
+		} else {
+			break
 		}
 	}
 
@@ -0,0 +1,35 @@
+package blockchain7
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBase58(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   []byte
+		encoded string
+	}{
+		{"empty", nil, ""},
+		{"no leading zero", []byte{0x01}, "2"},
+		{"first byte is not zero", []byte{0x05, 0x00}, "P5"},
+		{"one leading zero", []byte{0x00, 0x01}, "12"},
+		{"only zeros", []byte{0x00, 0x00, 0x00}, "111"},
+		{"several leading zeros", []byte{0x00, 0x00, 0x3a}, "1121"},
+		{"text", []byte("hello"), "Cn8eVZg"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.encoded, string(Base58Encode(c.input)))
+			decoded := Base58Decode([]byte(c.encoded))
+			if len(c.input) == 0 {
+				assert.Empty(t, decoded)
+			} else {
+				assert.Equal(t, c.input, decoded)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package blockchain7
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"log"
 	"time"
@@ -16,24 +17,50 @@ type Block struct {
 	Nonce         int
 	Hash          []byte
 	Height        int
+	Signer        []byte //出块者的公钥，工作量证明的区块为空
+	Signature     []byte //出块者对区块哈希的签名，工作量证明的区块为空
+}
+
+//BlockHeader 区块头，只包含验证工作量证明所需的数据，不包含交易
+//headers-first同步时，先下载并验证区块头，再并行下载区块本身
+type BlockHeader struct {
+	Timestamp     int64
+	PrevBlockHash []byte
+	MerkleRoot    []byte //交易的Merkle tree根节点，用于校验随后下载的区块
+	Nonce         int
+	Hash          []byte
+	Height        int
+	Signer        []byte
+	Signature     []byte
 }
 
 //NewBlock 创建普通区块
 //一个block里面可以包含多个交易
 func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), transactions, prevBlockHash, 0, []byte{}, height}
-
-	//挖矿实质上是算出符合要求的哈希
-	pow := NewProofOfWork(block) //注意传递block指针作为参数
-	nonce, hash := pow.Run()
+	block := &Block{time.Now().Unix(), transactions, prevBlockHash, 0, []byte{}, height, nil, nil}
 
-	//设置block的计数器和哈希
-	block.Nonce = nonce
-	block.Hash = hash[:]
+	//按共识规则封装区块，工作量证明即挖矿，实质上是算出符合要求的哈希
+	err := consensus.Seal(context.Background(), block, 1) //注意传递block指针作为参数
+	if err != nil {
+		log.Panic(err)
+	}
 
 	return block
 }
 
+//MineNewBlock 创建普通区块，工作量证明用workers个协程挖矿
+//ctx被取消时放弃挖矿，返回ctx.Err()
+func MineNewBlock(ctx context.Context, transactions []*Transaction, prevBlockHash []byte, height, workers int) (*Block, error) {
+	block := &Block{time.Now().Unix(), transactions, prevBlockHash, 0, []byte{}, height, nil, nil}
+
+	err := consensus.Seal(ctx, block, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
 // HashTransactions 计算交易组合的哈希值，最后得到的是Merkle tree的根节点
 //获得每笔交易的哈希，将它们关联起来，然后获得一个连接后的组合哈希
 //此方法只会被PoW使用
@@ -48,6 +75,11 @@ func (b *Block) HashTransactions() []byte {
 	return mTree.RootNode.Data //返回Merkle tree的根节点
 }
 
+//Header 返回区块的区块头
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{b.Timestamp, b.PrevBlockHash, b.HashTransactions(), b.Nonce, b.Hash, b.Height, b.Signer, b.Signature}
+}
+
 //NewGenesisBlock 创建创始区块，包含创始交易。注意，创建创始区块也需要挖矿。
 func NewGenesisBlock(coninbase *Transaction) *Block {
 	return NewBlock([]*Transaction{coninbase}, []byte{}, 0)
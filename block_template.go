@@ -0,0 +1,264 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// 提交的区块被拒绝的原因
+var (
+	ErrBlockInvalidSeal = errors.New("区块不符合共识规则")
+	ErrBlockInvalidTx   = errors.New("区块中有非法交易")
+)
+
+// BlockTemplate 区块模板：外部挖矿程序据此构建区块并计算工作量证明
+// 区块的交易为Transactions加上矿工的coinbase交易（排在最后），区块头的哈希由HeaderHash计算，
+// 哈希转为大整数后小于Target即为有效区块
+type BlockTemplate struct {
+	PrevBlockHash []byte
+	Height        int
+	Timestamp     int64          //模板创建时间，挖矿程序可以更新
+	Target        []byte         //目标值（大端字节序的大整数）
+	TargetBits    int            //难度系数：哈希前TargetBits位为0
+	CoinbaseValue int            //coinbase交易可以领取的金额：挖矿奖励加上手续费
+	Fees          int            //Transactions的手续费总额
+	Transactions  []*Transaction //从交易池中选出的交易，父交易在子交易之前，不含coinbase交易
+}
+
+// NewBlockTemplate 以当前的tip为父区块，从交易池中选出交易创建区块模板，pool为nil时创建空区块的模板
+func NewBlockTemplate(bc *Blockchain, pool *Mempool) *BlockTemplate {
+	last := bc.GetLastBlock()
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-targetBits))
+
+	tmpl := &BlockTemplate{
+		PrevBlockHash: last.Hash,
+		Height:        last.Height + 1,
+		Timestamp:     time.Now().Unix(),
+		Target:        target.Bytes(),
+		TargetBits:    targetBits,
+	}
+	if pool == nil {
+		tmpl.CoinbaseValue = subsidy
+		return tmpl
+	}
+
+	selected := make(map[string]*Transaction) //已选中的交易，后面的子交易可以花费它们的输出
+	for _, tx := range pool.Select(maxBlockSize) {
+		if !bc.verifyTransaction(tx, selected) {
+			fmt.Printf("区块模板不包含交易 %x: 签名无效或引用的输出不存在\n", tx.ID)
+			continue
+		}
+		tmpl.Transactions = append(tmpl.Transactions, tx)
+		tmpl.Fees += pool.Fee(tx.ID)
+		selected[hex.EncodeToString(tx.ID)] = tx
+	}
+	tmpl.CoinbaseValue = subsidy + tmpl.Fees
+
+	return tmpl
+}
+
+// Coinbase 创建支付给address的coinbase交易
+// coinbase数据中包含区块高度，保证连续挖出的空区块的coinbase交易ID各不相同；extraNonce也写入coinbase数据，
+// 不同的extraNonce得到不同的Merkle根，多个挖矿程序使用同一个模板时互不重复
+// 交易的时间戳取模板的时间戳，相同的参数总是得到相同的交易
+func (t *BlockTemplate) Coinbase(address string, extraNonce []byte) *Transaction {
+	data := fmt.Sprintf("高度%d，奖励给%s", t.Height, address)
+	if len(extraNonce) > 0 {
+		data = fmt.Sprintf("%s，%x", data, extraNonce)
+	}
+
+	cbTx := NewCoinbaseTX(address, data, t.Fees)
+	cbTx.Timestamp = t.Timestamp
+	cbTx.ID = cbTx.Hash()
+
+	return cbTx
+}
+
+// transactions 区块的全部交易：模板中的交易加上排在最后的coinbase交易
+func (t *BlockTemplate) transactions(coinbase *Transaction) []*Transaction {
+	txs := make([]*Transaction, 0, len(t.Transactions)+1)
+	txs = append(txs, t.Transactions...)
+
+	return append(txs, coinbase)
+}
+
+// MerkleRoot 使用coinbase交易时区块的Merkle根
+func (t *BlockTemplate) MerkleRoot(coinbase *Transaction) []byte {
+	block := Block{Transactions: t.transactions(coinbase)}
+
+	return block.HashTransactions()
+}
+
+// CheckHash 检查区块头的哈希是否满足模板的难度要求
+func (t *BlockTemplate) CheckHash(hash []byte) bool {
+	var hashInt, target big.Int
+	hashInt.SetBytes(hash)
+	target.SetBytes(t.Target)
+
+	return hashInt.Cmp(&target) == -1
+}
+
+// Block 用coinbase交易、时间戳和找到的nonce组装区块
+func (t *BlockTemplate) Block(coinbase *Transaction, timestamp int64, nonce int) *Block {
+	block := &Block{timestamp, t.transactions(coinbase), t.PrevBlockHash, nonce, nil, t.Height, nil, nil}
+	block.Hash = HeaderHash(t.PrevBlockHash, block.HashTransactions(), timestamp, nonce)
+
+	return block
+}
+
+// submitBlock 验证外部挖矿程序提交的区块，有效时连接到本地区块链并通知其他节点
+// 父区块不是当前tip的区块已经过时，返回errStaleBlock
+func submitBlock(bc *Blockchain, block *Block) error {
+	header := block.Header()
+	if !consensus.VerifyHeader(&header) {
+		return ErrBlockInvalidSeal
+	}
+
+	last := bc.GetLastBlock()
+	if !bytes.Equal(block.PrevBlockHash, last.Hash) {
+		return errStaleBlock
+	}
+	if block.Height != last.Height+1 {
+		return fmt.Errorf("%w: 区块高度应为 %d", ErrBlockInvalidTx, last.Height+1)
+	}
+
+	err := syncer.connectMinedBlock(block) //连接之前用checkBlock验证
+	if err != nil {
+		return err
+	}
+	fmt.Printf("接受提交的区块 %x，高度 %d\n", block.Hash, block.Height)
+	relayBlock(block, "")
+
+	return nil
+}
+
+// checkBlock 区块连接到tip之前的完整验证：区块中的交易（见validateBlockTransactions），以及需要区块链状态的共识规则，
+// 例如权益证明的出块资格。区块的父区块必须是当前的tip，UTXO集是父区块之后的状态
+func checkBlock(bc *Blockchain, block *Block) error {
+	err := validateBlockTransactions(bc, block)
+	if err != nil {
+		return err
+	}
+	err = verifyBlock(block)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockInvalidSeal, err)
+	}
+
+	return nil
+}
+
+// validateBlockTransactions 验证区块中的交易：交易ID必须与交易的哈希相符；只能有一个coinbase交易，
+// 输出金额必须为正数，且领取的金额不超过挖矿奖励加手续费；其他交易的输入必须引用UTXO集中或区块中排在前面的交易的未花费输出，不能重复花费，签名必须有效
+func validateBlockTransactions(bc *Blockchain, block *Block) error {
+	UTXOSet := UTXOSet{bc}
+	pending := make(map[string]*Transaction) //区块中排在前面的交易
+	spent := make(map[string]bool)           //区块中已经花费的输出（outpoint）
+	fees := 0
+	var coinbase *Transaction
+
+	//输入引用的交易所在区块的高度，引用区块中排在前面的交易时为本区块的高度
+	heightOf := func(txID []byte) (int, bool) {
+		if pending[hex.EncodeToString(txID)] != nil {
+			return block.Height, true
+		}
+		return UTXOSet.TxHeight(txID)
+	}
+
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return fmt.Errorf("%w: 交易 %x 的ID与交易的哈希不符", ErrBlockInvalidTx, tx.ID)
+		}
+		if tx.IsCoinbase() {
+			if coinbase != nil {
+				return fmt.Errorf("%w: 有多个coinbase交易", ErrBlockInvalidTx)
+			}
+			coinbase = tx
+			continue
+		}
+		if err := checkLocks(tx, block.Height, block.Timestamp, heightOf); err != nil {
+			return fmt.Errorf("%w: 交易 %x: %s", ErrBlockInvalidTx, tx.ID, err)
+		}
+
+		inValue := 0
+		for _, vin := range tx.Vin {
+			op := outpoint(vin.Txid, vin.Vout)
+			if spent[op] {
+				return fmt.Errorf("%w: %s 被重复花费", ErrBlockInvalidTx, op)
+			}
+			spent[op] = true
+
+			var out TxOutput
+			ok := false
+			if parent := pending[hex.EncodeToString(vin.Txid)]; parent != nil {
+				if vin.Vout >= 0 && vin.Vout < len(parent.Vout) && !parent.Vout[vin.Vout].IsUnspendable() {
+					out, ok = parent.Vout[vin.Vout], true
+				}
+			} else {
+				out, ok = UTXOSet.FindOutput(vin.Txid, vin.Vout)
+			}
+			if !ok {
+				return fmt.Errorf("%w: 交易 %x 引用的输出 %s 不存在或已经花费", ErrBlockInvalidTx, tx.ID, op)
+			}
+			inValue += out.Value
+		}
+
+		outValue, err := checkOutputs(tx)
+		if err != nil {
+			return fmt.Errorf("%w: 交易 %x: %s", ErrBlockInvalidTx, tx.ID, err)
+		}
+		if inValue < outValue {
+			return fmt.Errorf("%w: 交易 %x 的输入总额小于输出总额", ErrBlockInvalidTx, tx.ID)
+		}
+		if !bc.verifyTransaction(tx, pending) {
+			return fmt.Errorf("%w: 交易 %x 的签名无效", ErrBlockInvalidTx, tx.ID)
+		}
+
+		fees += inValue - outValue
+		pending[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	if coinbase == nil {
+		return fmt.Errorf("%w: 没有coinbase交易", ErrBlockInvalidTx)
+	}
+	cbValue, err := checkOutputs(coinbase) //负数金额的输出会抵消其他输出，使领取的总额看起来没有超出
+	if err != nil {
+		return fmt.Errorf("%w: coinbase交易: %s", ErrBlockInvalidTx, err)
+	}
+	if cbValue > subsidy+fees {
+		return fmt.Errorf("%w: coinbase交易领取了 %d，最多 %d", ErrBlockInvalidTx, cbValue, subsidy+fees)
+	}
+
+	return nil
+}
+
+// GetBlockTemplate 向节点node请求区块模板，供外部挖矿程序使用
+func GetBlockTemplate(node string) (*BlockTemplate, error) {
+	var reply template
+
+	err := callNode(node, "gettemplate", gettemplate{nodeAddress}, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply.Template, nil
+}
+
+// SubmitBlock 向节点node提交挖出的区块，区块被拒绝时返回原因
+func SubmitBlock(node string, block *Block) error {
+	var reply submitresult
+
+	err := callNode(node, "submitblock", submitblock{nodeAddress, block.Serialize()}, &reply)
+	if err != nil {
+		return err
+	}
+	if reply.Error != "" {
+		return errors.New(reply.Error)
+	}
+
+	return nil
+}
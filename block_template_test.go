@@ -0,0 +1,177 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBlockTemplate(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+	address := string(wallet.GetAddress())
+
+	//交易池中的A付1个币手续费，B花费A的输出，付2个币
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 9)
+	assert.NoError(t, mempool.Add(a))
+	pending := map[string]*Transaction{hex.EncodeToString(a.ID): a}
+	b := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 7)
+	assert.NoError(t, mempool.Add(b))
+
+	tmpl := NewBlockTemplate(bc, mempool)
+	assert.Equal(t, bc.Tip, tmpl.PrevBlockHash)
+	assert.Equal(t, 1, tmpl.Height)
+	assert.Equal(t, []*Transaction{a, b}, tmpl.Transactions, "parents first")
+	assert.Equal(t, 3, tmpl.Fees)
+	assert.Equal(t, subsidy+3, tmpl.CoinbaseValue)
+	target := new(big.Int).Lsh(big.NewInt(1), 256-targetBits)
+	assert.Equal(t, target.Bytes(), tmpl.Target)
+
+	//coinbase交易领取奖励和手续费，相同的参数得到相同的交易，不同的extraNonce得到不同的Merkle根
+	coinbase := tmpl.Coinbase(address, []byte{1})
+	assert.True(t, coinbase.IsCoinbase())
+	assert.Equal(t, coinbase.ID, coinbase.Hash())
+	assert.Equal(t, tmpl.CoinbaseValue, coinbase.Vout[0].Value)
+	assert.Equal(t, coinbase, tmpl.Coinbase(address, []byte{1}))
+	assert.NotEqual(t, tmpl.MerkleRoot(coinbase), tmpl.MerkleRoot(tmpl.Coinbase(address, []byte{2})))
+
+	//组装的区块使用外部挖矿程序计算区块头哈希的方式，交易合法
+	block := tmpl.Block(coinbase, tmpl.Timestamp, 42)
+	assert.Equal(t, []*Transaction{a, b, coinbase}, block.Transactions)
+	assert.Equal(t, tmpl.MerkleRoot(coinbase), block.HashTransactions())
+	assert.Equal(t, HeaderHash(tmpl.PrevBlockHash, tmpl.MerkleRoot(coinbase), tmpl.Timestamp, 42), block.Hash)
+	assert.Equal(t, 42, block.Nonce)
+	assert.Equal(t, 1, block.Height)
+	assert.NoError(t, validateBlockTransactions(bc, block))
+
+	//没有交易池时创建空区块的模板
+	empty := NewBlockTemplate(bc, nil)
+	assert.Empty(t, empty.Transactions)
+	assert.Equal(t, subsidy, empty.CoinbaseValue)
+}
+
+func TestBlockTemplateCheckHash(t *testing.T) {
+	bc, _ := newTestChain(t)
+	tmpl := NewBlockTemplate(bc, nil)
+	target := new(big.Int).SetBytes(tmpl.Target)
+	hash := func(n *big.Int) []byte {
+		return n.FillBytes(make([]byte, 32))
+	}
+
+	assert.True(t, tmpl.CheckHash(make([]byte, 32)))
+	assert.True(t, tmpl.CheckHash(hash(new(big.Int).Sub(target, big.NewInt(1)))))
+	assert.False(t, tmpl.CheckHash(hash(target)), "hash must be below the target")
+	assert.False(t, tmpl.CheckHash(bytes.Repeat([]byte{0xff}, 32)))
+}
+
+func TestSubmitBlock(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	newTestBanManager(t, nodeAddress) //没有其他节点，接受的区块不转发
+	genesis := bc.GetLastBlock()
+	address := string(wallet.GetAddress())
+
+	//封装正确但coinbase领取过多的区块
+	greedy := NewCoinbaseTX(address, "greedy", 1)
+	invalid := NewBlock([]*Transaction{greedy}, genesis.Hash, 1)
+	assert.ErrorIs(t, submitBlock(bc, invalid), ErrBlockInvalidTx)
+
+	unsealed := newTestBlock(t, &genesis, wallet, "unsealed")
+	unsealed.Hash = []byte("forged")
+	assert.Equal(t, ErrBlockInvalidSeal, submitBlock(bc, unsealed))
+
+	block := newTestBlock(t, &genesis, wallet, "valid")
+	assert.NoError(t, submitBlock(bc, block))
+	assert.Equal(t, block.Hash, bc.Tip)
+
+	//同一父区块的另一个区块已经过时
+	stale := newTestBlock(t, &genesis, wallet, "stale")
+	assert.Equal(t, errStaleBlock, submitBlock(bc, stale))
+	assert.Equal(t, block.Hash, bc.Tip)
+	assert.Equal(t, 1, bc.GetLastBlock().Height)
+}
+
+func TestValidateBlockTransactions(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+	address := string(wallet.GetAddress())
+
+	//A付1个币手续费，B花费区块中排在前面的A的输出，再付1个币
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 5, 4)
+	pending := map[string]*Transaction{hex.EncodeToString(a.ID): a}
+	b := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 4)
+	coinbase := func(values ...int) *Transaction {
+		tx := NewCoinbaseTX(address, "", 0)
+		tx.Vout = nil
+		for _, value := range values {
+			tx.Vout = append(tx.Vout, *NewTxOutput(value, address))
+		}
+		tx.ID = tx.Hash()
+		return tx
+	}
+
+	cases := []struct {
+		name string
+		txs  func() []*Transaction
+		err  bool
+	}{
+		{"valid", func() []*Transaction {
+			return []*Transaction{a, b, coinbase(subsidy + 2)}
+		}, false},
+		{"coinbase with several outputs", func() []*Transaction {
+			return []*Transaction{a, coinbase(subsidy, 1)}
+		}, false},
+		{"coinbase claims more than subsidy and fees", func() []*Transaction {
+			return []*Transaction{a, coinbase(subsidy + 2)}
+		}, true},
+		{"negative coinbase output offsets another", func() []*Transaction {
+			return []*Transaction{coinbase(subsidy+5, -5)}
+		}, true},
+		{"zero coinbase output", func() []*Transaction {
+			return []*Transaction{coinbase(subsidy, 0)}
+		}, true},
+		{"no coinbase", func() []*Transaction {
+			return []*Transaction{a}
+		}, true},
+		{"two coinbases", func() []*Transaction {
+			return []*Transaction{coinbase(subsidy), NewCoinbaseTX(address, "other", 0)}
+		}, true},
+		{"coinbase id does not match", func() []*Transaction {
+			cb := coinbase(subsidy)
+			cb.ID = a.ID
+			return []*Transaction{cb}
+		}, true},
+		{"transaction id does not match", func() []*Transaction {
+			forged := *b
+			forged.ID = a.ID
+			return []*Transaction{a, &forged, coinbase(subsidy)}
+		}, true},
+		{"child before parent", func() []*Transaction {
+			return []*Transaction{b, a, coinbase(subsidy)}
+		}, true},
+		{"output spent twice", func() []*Transaction {
+			c := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 8)
+			return []*Transaction{a, c, coinbase(subsidy)}
+		}, true},
+		{"invalid signature", func() []*Transaction {
+			forged := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 9)
+			forged.Vin[0].ScriptSig = newTestTx(bc, NewWallet(), nil, forged.Vin, 9).Vin[0].ScriptSig
+			return []*Transaction{forged, coinbase(subsidy)}
+		}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			block := &Block{Timestamp: time.Now().Unix(), Transactions: c.txs(), Height: 1}
+			err := validateBlockTransactions(bc, block)
+			if c.err {
+				assert.ErrorIs(t, err, ErrBlockInvalidTx)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package blockchain7
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -16,6 +18,9 @@ import (
 //dbFile 区块链数据库文件名称
 const dbFile = "blockchain_%s.db" //每个节点都有自己的数据库名称
 const blocksBucket = "blocks"     //存储的内容的键
+const mainChainBucket = "heights" //主链的高度索引：高度->区块哈希
+const maxLocatorSize = 101        //只在对方区块定位器的前这么多个哈希中查找分叉点
+const dbFormatVersion = 1         //数据库格式的版本，区块和交易的编码不兼容地改变时增加，见checkDbFormat
 const genesisCoinbaseData = "The Times 14/Oct/2020 拯救世界，从今天开始。"
 
 //Blockchain 区块链结构
@@ -28,32 +33,24 @@ type Blockchain struct {
 
 //MineBlock 挖出普通区块并将新区块加入到区块链中
 //此方法通过区块链的指针调用，将修改区块链bc的内容
-func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
-	var lastHash []byte //区块链最后一个区块的哈希
-	var lastHeight int  //区块链最后一个区块的高度
-	//在将交易放入块之前进行签名验证
+//共识引擎不能封装区块时（如还没有轮到本节点出块）返回错误，区块链不变
+func (bc *Blockchain) MineBlock(transactions []*Transaction) (*Block, error) {
+	//在将交易放入块之前进行签名验证，交易可以花费同一区块中排在它前面的交易的输出
+	pending := make(map[string]*Transaction)
 	for _, tx := range transactions {
-		if bc.VerifyTransaction(tx) != true {
+		if bc.verifyTransaction(tx, pending) != true {
 			log.Panic("ERROR: 非法交易")
 		}
+		pending[hex.EncodeToString(tx.ID)] = tx
 	}
 
-	err := bc.Db.View(func(tx *bolt.Tx) error { //只读打开，读取最后一个区块的哈希，作为新区块的prevHash
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("1")) //最后一个区块的哈希的键是字符串"1"
-
-		blockData := b.Get(lastHash)
-		block := DeserializeBlock(blockData)
-		lastHeight = block.Height
-		return nil
-	})
+	lastBlock := bc.GetLastBlock() //区块链最后一个区块
 
+	newBlock, err := MineNewBlock(context.Background(), transactions, lastBlock.Hash, lastBlock.Height+1, 1) //区块的高度+1，挖出区块
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1) //区块的高度+1，挖出区块
-
 	err = bc.Db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		err := b.Put(newBlock.Hash, newBlock.Serialize()) //将新区块序列化后插入到数据库表中
@@ -65,19 +62,41 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
 		if err != nil {
 			log.Panic(err)
 		}
+		indexMainChain(tx, newBlock.Hash)
 
 		bc.Tip = newBlock.Hash //修改区块链实例的tip值
 
 		return nil
 	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return newBlock, nil
+}
+
+// GetLastBlock 返回最后一个区块，新区块以它为父区块
+func (bc *Blockchain) GetLastBlock() Block {
+	var lastBlock Block
+
+	err := bc.Db.View(func(tx *bolt.Tx) error { //只读打开，读取最后一个区块，作为新区块的父区块
+		b := tx.Bucket([]byte(blocksBucket))
+		blockData := b.Get(b.Get([]byte("1"))) //最后一个区块的哈希的键是字符串"1"
+		lastBlock = *DeserializeBlock(blockData)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
 
-	return newBlock
+	return lastBlock
 }
 
 //CreatBlockchain 创建一个全新的区块链数据库
 //address用户发起创始交易，并挖矿，奖励也发给用户address
 //注意，创建后，数据库是open状态，需要使用者负责close数据库
-func CreatBlockchain(address string, nodeID string) *Blockchain {
+//params为链参数，决定区块链使用的共识算法，保存在数据库中
+func CreatBlockchain(address string, nodeID string, params ChainParams) *Blockchain {
 	dbFile := fmt.Sprintf(dbFile, nodeID)
 	if dbExist(dbFile) {
 		fmt.Println("区块链已经存在")
@@ -90,9 +109,14 @@ func CreatBlockchain(address string, nodeID string) *Blockchain {
 		log.Panic(err)
 	}
 
+	BC := Blockchain{nil, db} //构建区块链实例，创建创始区块后再设置tip
+	consensus = NewConsensusEngine(params, &BC)
 	err = db.Update(func(tx *bolt.Tx) error { //更新数据库，通过事务进行操作。一个数据文件同时只支持一个读-写事务
-		cbtx := NewCoinbaseTX(address, genesisCoinbaseData) //创建创始交易
-		genesis := NewGenesisBlock(cbtx)                    //创建创始区块
+		saveChainParams(tx, params)
+		saveDbFormat(tx)
+
+		cbtx := NewCoinbaseTX(address, genesisCoinbaseData, 0) //创建创始交易
+		genesis := NewGenesisBlock(cbtx)                       //创建创始区块
 
 		b, err := tx.CreateBucket([]byte(blocksBucket))
 		if err != nil {
@@ -110,6 +134,7 @@ func CreatBlockchain(address string, nodeID string) *Blockchain {
 		if err != nil {
 			log.Panic(err)
 		}
+		indexMainChain(tx, genesis.Hash)
 		tip = genesis.Hash
 
 		return nil
@@ -118,7 +143,7 @@ func CreatBlockchain(address string, nodeID string) *Blockchain {
 		log.Panic(err)
 	}
 
-	BC := Blockchain{tip, db} //构建区块链实例
+	BC.Tip = tip
 
 	return &BC //返回区块链实例的指针
 }
@@ -220,20 +245,19 @@ func (bc *Blockchain) FindUTXO() (map[string]TxOutputs, map[string]common.Hash)
 
 		Outputs:
 			for outIdx, out := range tx.Vout {
+				if out.IsUnspendable() {
+					continue //数据输出无法花费，不是UTXO
+				}
 				// tx的输出是否已经花费
-				if spentTXOs[txID] != nil {
-					for _, spentOutIdx := range spentTXOs[txID] {
-						if spentOutIdx == outIdx {
-							outs := make([]TxOutput, 0)  //创建切片，长度为0，out实际上是一个空数组
-							UTXO[txID] = TxOutputs{outs} //UTXO[txID]为空
-							break Outputs                //返回到Outputs标识处，即退出循环for outIdx, out := range tx.Vout
-						}
+				for _, spentOutIdx := range spentTXOs[txID] {
+					if spentOutIdx == outIdx {
+						continue Outputs //该输出已经花费，检查下一个输出
 					}
 				}
 
-				//spentTXOs[txID] == nil
 				outs := UTXO[txID]
 				outs.Outputs = append(outs.Outputs, out)
+				outs.Indexes = append(outs.Indexes, outIdx) //保存输出在交易中的原始索引
 				UTXO[txID] = outs
 			}
 
@@ -263,6 +287,48 @@ func dbExist(dbFile string) bool {
 	return true
 }
 
+var errDbFormat = errors.New("数据库格式与当前版本不兼容")
+
+//saveDbFormat 在链参数表中记录数据库格式的版本
+func saveDbFormat(tx *bolt.Tx) {
+	b, err := tx.CreateBucketIfNotExists([]byte(chainParamsBucket))
+	if err != nil {
+		log.Panic(err)
+	}
+	err = b.Put([]byte("format"), []byte{dbFormatVersion})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+//checkDbFormat 检查数据库格式的版本，不兼容时返回errDbFormat
+//版本1起交易的输入输出保存解锁脚本和锁定脚本，旧格式中的签名、公钥和公钥哈希字段按字段名解码时会被悄悄丢弃，
+//读出的交易没有脚本，既无法花费也无法验证，所以不能继续使用。没有记录版本的数据库检查tip区块：
+//输出都有锁定脚本的是版本1的数据库，补上版本记录
+func checkDbFormat(tx *bolt.Tx) error {
+	if b := tx.Bucket([]byte(chainParamsBucket)); b != nil {
+		if format := b.Get([]byte("format")); len(format) == 1 {
+			if format[0] != dbFormatVersion {
+				return fmt.Errorf("%w: 格式版本为%d，当前版本只支持%d", errDbFormat, format[0], dbFormatVersion)
+			}
+			return nil
+		}
+	}
+
+	b := tx.Bucket([]byte(blocksBucket))
+	block := DeserializeBlock(b.Get(b.Get([]byte("1"))))
+	for _, t := range block.Transactions {
+		for _, out := range t.Vout {
+			if len(out.ScriptPubKey) == 0 {
+				return fmt.Errorf("%w: 交易输出没有锁定脚本，是脚本引入之前的旧格式，请删除数据库后重新创建或从其他节点同步", errDbFormat)
+			}
+		}
+	}
+	saveDbFormat(tx)
+
+	return nil
+}
+
 //NewBlockchain 从数据库中取出最后一个区块的哈希，构建一个区块链实例
 func NewBlockchain(nodeID string) *Blockchain {
 	dbFile := fmt.Sprintf(dbFile, nodeID)
@@ -277,18 +343,30 @@ func NewBlockchain(nodeID string) *Blockchain {
 		log.Panic(err)
 	}
 
-	err = db.View(func(tx *bolt.Tx) error {
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := checkDbFormat(tx); err != nil {
+			return err
+		}
+
 		b := tx.Bucket([]byte(blocksBucket)) //通过名称获得bucket
 		tip = b.Get([]byte("1"))             //获得最后区块的哈希
+		tip = append([]byte(nil), tip...)    //tip在事务结束后仍要使用，需要复制
+		indexMainChain(tx, tip)              //旧的数据库没有高度索引，第一次打开时建立
 
 		return nil
 	})
 
+	if errors.Is(err, errDbFormat) {
+		db.Close()
+		fmt.Printf("%s: %s\n", dbFile, err)
+		os.Exit(1)
+	}
 	if err != nil {
 		log.Panic(err)
 	}
 
 	bc := Blockchain{Tip: tip, Db: db}
+	consensus = NewConsensusEngine(loadChainParams(db), &bc) //按链参数设置共识引擎
 
 	return &bc
 }
@@ -324,6 +402,7 @@ func (bc *Blockchain) AddBlock(block *Block) {
 			if err != nil {
 				log.Panic(err)
 			}
+			indexMainChain(tx, block.Hash)
 			bc.Tip = block.Hash
 		}
 		fmt.Println("finished！")
@@ -334,6 +413,107 @@ func (bc *Blockchain) AddBlock(block *Block) {
 	}
 }
 
+// saveBlock 将区块存入数据库，不改变tip，区块已经存在时不做任何事
+func (bc *Blockchain) saveBlock(block *Block) {
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		if b.Get(block.Hash) != nil {
+			return nil
+		}
+
+		return b.Put(block.Hash, block.Serialize())
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// deleteBlock 从数据库中删除区块，用于丢弃验证失败的分支区块，区块不能是tip
+func (bc *Blockchain) deleteBlock(hash []byte) {
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(blocksBucket)).Delete(hash)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// setTip 将tip设为哈希为hash的区块，并更新主链的高度索引，区块必须已经存入数据库
+func (bc *Blockchain) setTip(hash []byte) {
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		err := b.Put([]byte("1"), hash)
+		if err != nil {
+			return err
+		}
+		indexMainChain(tx, hash)
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	bc.Tip = hash
+}
+
+// heightKey 高度索引的键：8字节大端序的高度，按高度排序
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+
+	return key
+}
+
+// indexMainChain tip改变后在事务中更新主链的高度索引
+// 删除新tip之上的高度，然后从新tip向前回溯，直到遇到索引中已有的区块（即与原主链的分叉点），
+// 通常只需要写入一个区块；索引不存在时（旧的数据库）会回溯到创始区块，建立完整的索引
+func indexMainChain(tx *bolt.Tx, tip []byte) {
+	blocks := tx.Bucket([]byte(blocksBucket))
+	index, err := tx.CreateBucketIfNotExists([]byte(mainChainBucket))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	block := DeserializeBlock(blocks.Get(tip))
+	c := index.Cursor()
+	for k, _ := c.Seek(heightKey(block.Height + 1)); k != nil; k, _ = c.Seek(heightKey(block.Height + 1)) {
+		if err := c.Delete(); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	for !bytes.Equal(index.Get(heightKey(block.Height)), block.Hash) {
+		if err := index.Put(heightKey(block.Height), block.Hash); err != nil {
+			log.Panic(err)
+		}
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+		block = DeserializeBlock(blocks.Get(block.PrevBlockHash))
+	}
+}
+
+// mainChainHashes 返回主链上高度为heights的区块哈希，高度超过tip时对应的哈希为nil
+func (bc *Blockchain) mainChainHashes(heights []int) [][]byte {
+	hashes := make([][]byte, len(heights))
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(mainChainBucket))
+		for i, height := range heights {
+			if hash := index.Get(heightKey(height)); hash != nil {
+				hashes[i] = append([]byte(nil), hash...)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return hashes
+}
+
 // GetBestHeight 返回最后一个区块的高度
 func (bc *Blockchain) GetBestHeight() int {
 	var lastBlock Block
@@ -429,7 +609,13 @@ func (bc *Blockchain) FindTransactionForUTXO(txID []byte) (Transaction, error) {
 		blockb := tx.Bucket([]byte(utxoBlockBucket)) //UTXOBlock
 
 		blockhash := blockb.Get(txID) //UTXOBlock
+		if blockhash == nil {
+			return nil
+		}
 		blockData := b.Get(blockhash)
+		if blockData == nil {
+			return nil
+		}
 		block := *DeserializeBlock(blockData)
 		for _, tx := range block.Transactions {
 			if bytes.Compare(tx.ID, txID) == 0 {
@@ -441,7 +627,7 @@ func (bc *Blockchain) FindTransactionForUTXO(txID []byte) (Transaction, error) {
 	if err != nil {
 		log.Panic(err)
 	}
-	if &tnx != nil {
+	if tnx.ID != nil {
 		return tnx, nil
 	}
 
@@ -472,10 +658,15 @@ func (bc *Blockchain) FindTransaction(txID []byte) (Transaction, error) {
 // SignTransaction 对一个交易的所有输入引用的输出的交易进行签名
 //注意，这里签名的不是参数tx（当前交易），而是tx输入所引用的输出的交易
 func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+	bc.signTransaction(tx, privKey, nil)
+}
+
+//signTransaction 对交易签名，输入引用的交易先在pending（尚未上链的交易，如交易池中的交易）中查找，再到区块链中查找
+func (bc *Blockchain) signTransaction(tx *Transaction, privKey ecdsa.PrivateKey, pending map[string]*Transaction) {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransactionForUTXO(vin.Txid) //通过交易输入引用的输出交易ID获得输出交易
+		prevTX, err := bc.findPrevTransaction(vin.Txid, pending) //通过交易输入引用的输出交易ID获得输出交易
 		if err != nil {
 			log.Panic(err)
 		}
@@ -486,7 +677,14 @@ func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)
 }
 
 // VerifyTransaction 验证一个交易的所有输入的签名
+//交易可能来自其他节点，输入引用了找不到的交易或不存在的输出时，视为非法交易
 func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
+	return bc.verifyTransaction(tx, nil)
+}
+
+//verifyTransaction 验证交易的签名，输入引用的交易先在pending中查找，再到区块链中查找
+//这样交易可以花费尚未上链的父交易的输出（交易链）
+func (bc *Blockchain) verifyTransaction(tx *Transaction, pending map[string]*Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
 	}
@@ -494,12 +692,120 @@ func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransactionForUTXO(vin.Txid)
+		prevTX, err := bc.findPrevTransaction(vin.Txid, pending)
 		if err != nil {
-			log.Panic(err)
+			return false
+		}
+		if vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+			return false
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
 	return tx.Verify(prevTXs)
 }
+
+//findPrevTransaction 查找输入引用的交易：先在pending中查找，再到区块链中查找
+func (bc *Blockchain) findPrevTransaction(txID []byte, pending map[string]*Transaction) (Transaction, error) {
+	if tx, ok := pending[hex.EncodeToString(txID)]; ok {
+		return *tx, nil
+	}
+
+	return bc.FindTransactionForUTXO(txID)
+}
+
+// HasBlock 检查数据库中是否已经存在某个区块
+func (bc *Blockchain) HasBlock(blockHash []byte) bool {
+	found := false
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		found = b.Get(blockHash) != nil
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return found
+}
+
+// GetBlockLocator 返回区块定位器：从tip开始，前10个区块逐个选取，之后间隔按指数增长，最后总是包含创始区块
+//对方根据定位器中第一个它也拥有的区块，即可找到双方区块链的分叉点
+func (bc *Blockchain) GetBlockLocator() [][]byte {
+	var heights []int
+	step := 1
+
+	for height := bc.GetBestHeight(); height > 0; height -= step {
+		heights = append(heights, height)
+		if len(heights) >= 10 {
+			step *= 2
+		}
+	}
+	heights = append(heights, 0) //创始区块
+
+	return bc.mainChainHashes(heights)
+}
+
+// GetBlockHashesAfter 根据对方的区块定位器找到分叉点，返回分叉点之后的主链区块哈希
+//最多返回max个，遇到stopHash时（包含stopHash）提前结束；如果定位器中没有任何本地主链上的区块，则从创始区块开始返回
+//通过主链的高度索引查找，不需要遍历区块链，只检查定位器的前maxLocatorSize个哈希
+func (bc *Blockchain) GetBlockHashesAfter(locator [][]byte, stopHash []byte, max int) [][]byte {
+	var result [][]byte
+
+	if len(locator) > maxLocatorSize {
+		locator = locator[:maxLocatorSize]
+	}
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket([]byte(blocksBucket))
+		index := tx.Bucket([]byte(mainChainBucket))
+
+		start := 0
+		for _, hash := range locator {
+			blockData := blocks.Get(hash)
+			if blockData == nil {
+				continue
+			}
+			height := DeserializeBlock(blockData).Height
+			if bytes.Equal(index.Get(heightKey(height)), hash) { //区块在主链上
+				start = height + 1
+				break
+			}
+		}
+
+		for height := start; len(result) < max; height++ {
+			hash := index.Get(heightKey(height))
+			if hash == nil {
+				break
+			}
+			result = append(result, append([]byte(nil), hash...))
+			if len(stopHash) > 0 && bytes.Equal(hash, stopHash) {
+				break
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return result
+}
+
+// GetHeadersAfter 与GetBlockHashesAfter相同，但返回的是区块头
+func (bc *Blockchain) GetHeadersAfter(locator [][]byte, stopHash []byte, max int) []BlockHeader {
+	var headers []BlockHeader
+
+	for _, hash := range bc.GetBlockHashesAfter(locator, stopHash, max) {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		headers = append(headers, block.Header())
+	}
+
+	return headers
+}
@@ -0,0 +1,167 @@
+package blockchain7
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestBlocks 在bc的tip之后连接n个区块，返回从创始区块开始的全部主链区块，下标即高度
+func newTestBlocks(t *testing.T, bc *Blockchain, wallet *Wallet, n int) []*Block {
+	genesis := bc.GetLastBlock()
+	chain := []*Block{&genesis}
+	for i := 1; i <= n; i++ {
+		b := newTestBlock(t, chain[i-1], wallet, fmt.Sprintf("main%d", i))
+		if err := connectTestBlocks(b); err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, b)
+	}
+
+	return chain
+}
+
+// blockHashes 返回区块的哈希
+func blockHashes(blocks []*Block) [][]byte {
+	var hashes [][]byte
+	for _, b := range blocks {
+		hashes = append(hashes, b.Hash)
+	}
+
+	return hashes
+}
+
+func TestGetBlockLocator(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	assert.Equal(t, [][]byte{bc.Tip}, bc.GetBlockLocator(), "genesis only")
+
+	chain := newTestBlocks(t, bc, wallet, 25)
+	var expected []*Block
+	for _, height := range []int{25, 24, 23, 22, 21, 20, 19, 18, 17, 16, 14, 10, 2, 0} {
+		expected = append(expected, chain[height])
+	}
+	assert.Equal(t, blockHashes(expected), bc.GetBlockLocator())
+}
+
+func TestGetBlockHashesAfter(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	chain := newTestBlocks(t, bc, wallet, 10)
+	side := newTestBlock(t, chain[4], wallet, "side")
+	assert.NoError(t, connectTestBlocks(side))
+
+	cases := []struct {
+		name     string
+		locator  [][]byte
+		stopHash []byte
+		max      int
+		expected []*Block
+	}{
+		{"at tip", [][]byte{chain[10].Hash}, nil, 500, nil},
+		{"fork point", [][]byte{chain[7].Hash, chain[0].Hash}, nil, 500, chain[8:]},
+		{"unknown hashes are skipped", [][]byte{[]byte("unknown"), chain[3].Hash}, nil, 500, chain[4:]},
+		{"side branch block is skipped", [][]byte{side.Hash, chain[2].Hash}, nil, 500, chain[3:]},
+		{"no common block starts at genesis", [][]byte{[]byte("unknown")}, nil, 500, chain},
+		{"empty locator starts at genesis", nil, nil, 500, chain},
+		{"stop hash is included", [][]byte{chain[2].Hash}, chain[5].Hash, 500, chain[3:6]},
+		{"stop hash on side branch is ignored", [][]byte{chain[2].Hash}, side.Hash, 500, chain[3:]},
+		{"max", [][]byte{chain[2].Hash}, nil, 3, chain[3:6]},
+		{"max before stop hash", [][]byte{chain[2].Hash}, chain[9].Hash, 2, chain[3:5]},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, blockHashes(c.expected), bc.GetBlockHashesAfter(c.locator, c.stopHash, c.max))
+		})
+	}
+
+	headers := bc.GetHeadersAfter([][]byte{chain[8].Hash}, nil, 500)
+	assert.Equal(t, []BlockHeader{chain[9].Header(), chain[10].Header()}, headers)
+}
+
+func TestMainChainIndexFollowsReorganize(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	chain := newTestBlocks(t, bc, wallet, 3)
+
+	//分支比主链长，切换后高度索引指向分支上的区块，原主链上高于分叉点的区块不再返回
+	b2 := newTestBlock(t, chain[1], wallet, "b2")
+	b3 := newTestBlock(t, b2, wallet, "b3")
+	b4 := newTestBlock(t, b3, wallet, "b4")
+	assert.NoError(t, connectTestBlocks(b2, b3, b4))
+	assert.Equal(t, blockHashes([]*Block{b2, b3, b4}), bc.GetBlockHashesAfter([][]byte{chain[1].Hash}, nil, 500))
+	assert.Equal(t, blockHashes([]*Block{b2, b3, b4}), bc.GetBlockHashesAfter([][]byte{chain[3].Hash, chain[1].Hash}, nil, 500))
+	assert.Equal(t, blockHashes([]*Block{b4, b3, b2, chain[1], chain[0]}), bc.GetBlockLocator())
+}
+
+func TestCheckDbFormat(t *testing.T) {
+	errRollback := errors.New("回滚")
+	bc, wallet := newTestChain(t)
+	oldLayout := NewCoinbaseTX(string(wallet.GetAddress()), "old", 0)
+	oldLayout.Vout[0].ScriptPubKey = nil //旧格式的输出按字段名解码后没有锁定脚本
+	oldBlock := &Block{Timestamp: 1, Transactions: []*Transaction{oldLayout}, Hash: []byte("old"), Height: 1}
+
+	cases := []struct {
+		name   string
+		format []byte //nil表示没有版本记录
+		tip    *Block //tip区块，nil表示不修改
+		err    bool
+	}{
+		{"current format", []byte{dbFormatVersion}, nil, false},
+		{"newer format", []byte{dbFormatVersion + 1}, nil, true},
+		{"unrecorded current format", nil, nil, false},
+		{"unrecorded old format", nil, oldBlock, true},
+		{"recorded format is trusted", []byte{dbFormatVersion}, oldBlock, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := bc.Db.Update(func(tx *bolt.Tx) error {
+				params := tx.Bucket([]byte(chainParamsBucket))
+				if c.format == nil {
+					assert.NoError(t, params.Delete([]byte("format")))
+				} else {
+					assert.NoError(t, params.Put([]byte("format"), c.format))
+				}
+				if c.tip != nil {
+					b := tx.Bucket([]byte(blocksBucket))
+					assert.NoError(t, b.Put(c.tip.Hash, c.tip.Serialize()))
+					assert.NoError(t, b.Put([]byte("1"), c.tip.Hash))
+				}
+
+				err := checkDbFormat(tx)
+				if err != nil {
+					return err
+				}
+				if c.format == nil {
+					assert.Equal(t, []byte{dbFormatVersion}, params.Get([]byte("format")), "format is recorded")
+				}
+				return errRollback //每个用例都不修改数据库
+			})
+			if c.err {
+				assert.ErrorIs(t, err, errDbFormat)
+			} else {
+				assert.NotErrorIs(t, err, errDbFormat)
+			}
+		})
+	}
+}
+
+func TestMineBlockNotInTurn(t *testing.T) {
+	wallet := NewWallet()
+	bc := newTestChainWithParams(t, wallet, ChainParams{Consensus: consensusPoA, Validators: []string{string(wallet.GetAddress())}})
+	tip := bc.Tip
+
+	//不是验证者的钱包出块时返回错误，区块链不变
+	consensus.(authorizer).Authorize(NewWallet())
+	block, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(wallet.GetAddress()), "b1", 0)})
+	assert.Equal(t, errNotInTurn, err)
+	assert.Nil(t, block)
+	assert.Equal(t, tip, bc.Tip)
+
+	consensus.(authorizer).Authorize(wallet)
+	block, err = bc.MineBlock([]*Transaction{NewCoinbaseTX(string(wallet.GetAddress()), "b1", 0)})
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, bc.Tip)
+}
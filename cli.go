@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 )
 
 //CLI 响应处理命令行参数
@@ -13,14 +16,32 @@ type CLI struct{}
 //printUsage 打印命令行帮助信息
 func (cli *CLI) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("   createblockchain -address ADDRESS - 创建一个新的区块链并发送创始区块奖励给到ADDRESS")
+	fmt.Println("   abandontx -txid TXID -fee FEE - 放弃交易池中尚未上链的交易TXID：用一个把金额退回给自己、支付FEE手续费的交易替换它，默认为原手续费的两倍")
+	fmt.Println("   anchor -from FROM -file FILE -fee FEE - 从FROM发送交易，把文件FILE的SHA-256哈希写入区块链的数据输出，用verifyanchor证明文件在上链时已经存在")
+	fmt.Println("   bumpfee -txid TXID -fee FEE - 将交易池中尚未上链的交易TXID的手续费提高到FEE，默认为原手续费的两倍")
+	fmt.Println("   createblockchain -address ADDRESS -consensus pow|poa|pos -validators ADDR1,ADDR2 -stakematurity N -slotinterval SECONDS - 创建一个新的区块链并发送创始区块奖励给到ADDRESS，-consensus为共识算法，默认为工作量证明，poa由-validators中的验证者按顺序轮流出块，pos按成熟UTXO的金额随机选出每个时隙的出块者")
+	fmt.Println("   createmultisig -m M -keys KEY1,KEY2,KEY3 -p2sh - 创建M-of-N多重签名地址，KEY为本节点的钱包地址或十六进制的公钥，如果设定了-p2sh，则创建P2SH地址")
 	fmt.Println("   createwallet - 创建一个新的钥匙对并存储到钱包文件中")
+	fmt.Println("   estimatefee -blocks N - 估算交易在N个区块内确认需要的手续费率（每千字节），默认为6个区块")
+	fmt.Println("   extractsecret -txid TXID -vout N - 查找花费HTLC输出TXID:N的交易，取出收款人公开的原像")
 	fmt.Println("   getbalance -address ADDRESS  - 获得地址ADDRESS的余额")
-	fmt.Println("   listaddresses - 列出钱包文件中的所有钱包地址")
+	fmt.Println("   getmininginfo -node NODE - 查询运行中的节点NODE（默认为本地节点）的挖矿信息：本节点算力、每个区块的哈希次数和估算的全网算力")
+	fmt.Println("   htlc-create -from FROM -to TO -amount AMOUNT -fee FEE -hash HASH -locktime N - 从FROM向TO支付到HTLC输出：TO出示哈希为HASH的原像可以取款，到达锁定时间N（区块高度或Unix时间戳）之后FROM可以取回，没有指定-hash时产生随机原像")
+	fmt.Println("   htlc-redeem -txid TXID -vout N -secret SECRET -address ADDRESS -fee FEE - 收款人ADDRESS出示原像SECRET，取走HTLC输出TXID:N")
+	fmt.Println("   htlc-refund -txid TXID -vout N -address ADDRESS -fee FEE - 付款人ADDRESS在到达锁定时间之后取回HTLC输出TXID:N")
+	fmt.Println("   listaddresses -pubkey - 列出钱包文件中的所有钱包地址，如果设定了-pubkey，同时列出公钥")
+	fmt.Println("   listbanned - 列出所有被封禁的节点")
+	fmt.Println("   poolshares - 列出矿池中每个矿工提交的share数，用于计算矿池收入的分配")
 	fmt.Println("   printchain - 打印区块链中的所有区块")
 	fmt.Println("   reindexutxo - 重建UTXO")
-	fmt.Println("   send -from FROM -to TO -amount AMOUNT -mine - 发送amount数量的币，从地址FROM到TO,如果设定了-mine，则由本节点完成挖矿")
-	fmt.Println("   startnode -miner ADDRESS - 通过特定的环境变量NODE_ID启动一个节点，可选参数：-miner启动挖矿")
+	fmt.Println("   signmultisig -file FILE -address ADDRESS -send - 用钱包ADDRESS对文件FILE中的多重签名交易签名，如果设定了-send，签名足够后发送交易")
+	fmt.Println("   setban -node NODE -bantime SECONDS -remove - 封禁节点NODE（host:port或IP）SECONDS秒，默认24小时，如果设定了-remove，则解除封禁")
+	fmt.Println("   sendmany -from FROM -file FILE -fee FEE -coinselect STRATEGY -inputs TXID:VOUT,... - 从FROM向文件FILE中列出的全部收款人转账，只创建一个交易，FILE为JSON（[{\"address\": ADDRESS, \"amount\": AMOUNT}]）或CSV（每行为ADDRESS,AMOUNT）格式，-coinselect和-inputs同send")
+	fmt.Println("   senddata -from FROM -hex DATA -fee FEE - 从FROM发送交易，把十六进制的数据DATA（最多80字节）写入区块链的数据输出，数据输出无法花费，不加入UTXO集")
+	fmt.Println("   send -from FROM -to TO -amount AMOUNT -fee FEE -locktime N -relativelock BLOCKS -coinselect STRATEGY -inputs TXID:VOUT,... -mine - 发送amount数量的币，从地址FROM到TO，支付FEE手续费（默认按estimatefee估算）,-locktime为交易的锁定时间（小于500000000为区块高度，否则为Unix时间戳），-relativelock为花费的输出上链之后需要经过的区块数，-coinselect为选币策略：first（默认，按UTXO集中的顺序）、largest（金额从大到小）、smallest（金额从小到大）、bnb（寻找不需要找零的组合）或random（随机），-inputs手动指定花费的输出，如果设定了-mine，则由本节点完成挖矿")
+	fmt.Println("   spendmultisig -from FROM -to TO -amount AMOUNT -fee FEE -redeemscript HEX -file FILE - 创建从多重签名地址FROM向TO转账的交易并保存到文件FILE，等待签名者签名，FROM为P2SH地址时需要提供赎回脚本")
+	fmt.Println("   verifyanchor -file FILE -hex DATA - 在区块链中查找写入了文件FILE的哈希（或十六进制数据DATA）的交易，打印所在的区块、高度、时间和确认数")
+	fmt.Println("   startnode -miner ADDRESS -minerthreads N -blockinterval SECONDS -maxmempool KB -minrelayfee FEERATE -mempoolexpiry HOURS -stratum ADDR -pooladdress ADDRESS -sharebits N - 通过特定的环境变量NODE_ID启动一个节点，可选参数：-miner启动持续挖矿，-minerthreads为挖矿协程数量，-blockinterval为目标出块间隔，-maxmempool、-minrelayfee、-mempoolexpiry为交易池的容量、最低转发费率（每千字节手续费）和交易过期时间，-stratum在ADDR启动Stratum矿池服务器，区块奖励支付给-pooladdress，-sharebits为share难度")
 }
 
 //validateArgs 校验命令，如果无效，打印使用说明
@@ -57,16 +78,103 @@ func (cli *CLI) Run() {
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	//定义名称为"reindexutxo"的空的flagset集合
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	listBannedCmd := flag.NewFlagSet("listbanned", flag.ExitOnError)
+	setBanCmd := flag.NewFlagSet("setban", flag.ExitOnError)
+	bumpFeeCmd := flag.NewFlagSet("bumpfee", flag.ExitOnError)
+	abandonTxCmd := flag.NewFlagSet("abandontx", flag.ExitOnError)
+	estimateFeeCmd := flag.NewFlagSet("estimatefee", flag.ExitOnError)
+	poolSharesCmd := flag.NewFlagSet("poolshares", flag.ExitOnError)
+	getMiningInfoCmd := flag.NewFlagSet("getmininginfo", flag.ExitOnError)
+	createMultiSigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	spendMultiSigCmd := flag.NewFlagSet("spendmultisig", flag.ExitOnError)
+	signMultiSigCmd := flag.NewFlagSet("signmultisig", flag.ExitOnError)
+	htlcCreateCmd := flag.NewFlagSet("htlc-create", flag.ExitOnError)
+	htlcRedeemCmd := flag.NewFlagSet("htlc-redeem", flag.ExitOnError)
+	htlcRefundCmd := flag.NewFlagSet("htlc-refund", flag.ExitOnError)
+	extractSecretCmd := flag.NewFlagSet("extractsecret", flag.ExitOnError)
+	sendDataCmd := flag.NewFlagSet("senddata", flag.ExitOnError)
+	sendManyCmd := flag.NewFlagSet("sendmany", flag.ExitOnError)
+	anchorCmd := flag.NewFlagSet("anchor", flag.ExitOnError)
+	verifyAnchorCmd := flag.NewFlagSet("verifyanchor", flag.ExitOnError)
 
 	//String用指定的名称给getBalanceAddress 新增一个字符串flag
 	//以指针的形式返回getBalanceAddress
 	getBalanceAddress := getBalanceCmd.String("address", "", "获得金钱的地址")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "接受挖出创始区块奖励的的地址")
+	createBlockchainConsensus := createBlockchainCmd.String("consensus", consensusPoW, "共识算法：pow、poa或pos")
+	createBlockchainValidators := createBlockchainCmd.String("validators", "", "poa的验证者地址，用逗号分隔，按顺序轮流出块")
+	createBlockchainStakeMaturity := createBlockchainCmd.Int("stakematurity", defaultStakeMaturity, "pos：UTXO计入权益需要的确认数")
+	createBlockchainSlotInterval := createBlockchainCmd.Int("slotinterval", defaultSlotInterval, "pos：出块时隙的长度（秒）")
 	sendFrom := sendCmd.String("from", "", "钱包源地址")
 	sendTo := sendCmd.String("to", "", "钱包目的地址")
 	sendAmount := sendCmd.Int("amount", 0, "转移资金的数量")
 	sendMine := sendCmd.Bool("mine", false, "在该节点立即挖矿")
+	sendFee := sendCmd.Int("fee", -1, "支付给矿工的手续费，默认按估算的手续费率计算")
+	sendLockTime := sendCmd.Int64("locktime", 0, "交易的锁定时间：区块高度或Unix时间戳")
+	sendRelativeLock := sendCmd.Int("relativelock", 0, "花费的输出上链之后需要经过的区块数")
+	sendCoinSelect := sendCmd.String("coinselect", coinSelectFirst, "选币策略：first、largest、smallest、bnb或random")
+	sendInputs := sendCmd.String("inputs", "", "手动指定花费的输出，逗号分隔的txid:vout")
 	startNodeMiner := startNodeCmd.String("miner", "", "启动挖矿模式，并制定奖励的钱包ADDRESS")
+	startNodeMaxMempool := startNodeCmd.Int("maxmempool", defaultMaxMempoolSize/1024, "交易池最大容量（KB）")
+	startNodeMinRelayFee := startNodeCmd.Int("minrelayfee", defaultMinRelayFeeRate, "最低转发费率（每千字节的手续费）")
+	startNodeMempoolExpiry := startNodeCmd.Int("mempoolexpiry", int(defaultMempoolExpiry/time.Hour), "交易在交易池中的最长停留时间（小时）")
+	startNodeMinerThreads := startNodeCmd.Int("minerthreads", runtime.NumCPU(), "挖矿协程数量，默认为CPU核数")
+	startNodeBlockInterval := startNodeCmd.Int("blockinterval", int(defaultBlockInterval/time.Second), "目标出块间隔（秒）")
+	setBanNode := setBanCmd.String("node", "", "节点地址（host:port）或IP")
+	setBanTime := setBanCmd.Int("bantime", 0, "封禁时长（秒），默认24小时")
+	setBanRemove := setBanCmd.Bool("remove", false, "解除封禁")
+	bumpFeeTxID := bumpFeeCmd.String("txid", "", "要提高手续费的交易ID")
+	bumpFeeFee := bumpFeeCmd.Int("fee", 0, "新的手续费，默认为原手续费的两倍")
+	abandonTxTxID := abandonTxCmd.String("txid", "", "要放弃的交易ID")
+	abandonTxFee := abandonTxCmd.Int("fee", 0, "替换交易的手续费，默认为原手续费的两倍")
+	startNodeStratum := startNodeCmd.String("stratum", "", "矿池服务器的监听地址（host:port），为空时不启用矿池")
+	startNodePoolAddress := startNodeCmd.String("pooladdress", "", "矿池接收区块奖励的钱包ADDRESS")
+	startNodeShareBits := startNodeCmd.Int("sharebits", defaultShareBits, "矿池的share难度：哈希前N位为0")
+	getMiningInfoNode := getMiningInfoCmd.String("node", "", "节点地址（host:port），默认为NODE_ID对应的本地节点")
+	estimateFeeBlocks := estimateFeeCmd.Int("blocks", defaultConfirmTarget, "期望在多少个区块内确认")
+	listAddressesPubKey := listAddressesCmd.Bool("pubkey", false, "同时列出十六进制的公钥")
+	createMultiSigM := createMultiSigCmd.Int("m", 0, "花费需要的签名数")
+	createMultiSigKeys := createMultiSigCmd.String("keys", "", "多重签名者的钱包地址或十六进制公钥，用逗号分隔")
+	createMultiSigP2SH := createMultiSigCmd.Bool("p2sh", false, "创建P2SH地址")
+	spendMultiSigFrom := spendMultiSigCmd.String("from", "", "多重签名地址")
+	spendMultiSigTo := spendMultiSigCmd.String("to", "", "钱包目的地址")
+	spendMultiSigAmount := spendMultiSigCmd.Int("amount", 0, "转移资金的数量")
+	spendMultiSigFee := spendMultiSigCmd.Int("fee", -1, "支付给矿工的手续费，默认按估算的手续费率计算")
+	spendMultiSigFile := spendMultiSigCmd.String("file", "", "保存待签名交易的文件")
+	spendMultiSigRedeemScript := spendMultiSigCmd.String("redeemscript", "", "P2SH地址的十六进制赎回脚本")
+	signMultiSigFile := signMultiSigCmd.String("file", "", "待签名交易的文件")
+	signMultiSigAddress := signMultiSigCmd.String("address", "", "签名者的钱包地址")
+	signMultiSigSend := signMultiSigCmd.Bool("send", false, "签名足够后发送交易")
+	htlcCreateFrom := htlcCreateCmd.String("from", "", "付款人的钱包地址")
+	htlcCreateTo := htlcCreateCmd.String("to", "", "收款人的钱包地址")
+	htlcCreateAmount := htlcCreateCmd.Int("amount", 0, "支付的金额")
+	htlcCreateFee := htlcCreateCmd.Int("fee", -1, "支付给矿工的手续费，默认按估算的手续费率计算")
+	htlcCreateHash := htlcCreateCmd.String("hash", "", "原像的SHA-256哈希（十六进制），默认产生随机原像")
+	htlcCreateLockTime := htlcCreateCmd.Int64("locktime", 0, "付款人可以取回的区块高度或Unix时间戳")
+	htlcRedeemTxID := htlcRedeemCmd.String("txid", "", "HTLC输出所在的交易ID")
+	htlcRedeemVout := htlcRedeemCmd.Int("vout", 0, "HTLC输出在交易中的索引")
+	htlcRedeemSecret := htlcRedeemCmd.String("secret", "", "原像（十六进制）")
+	htlcRedeemAddress := htlcRedeemCmd.String("address", "", "收款人的钱包地址")
+	htlcRedeemFee := htlcRedeemCmd.Int("fee", -1, "支付给矿工的手续费，默认按估算的手续费率计算")
+	htlcRefundTxID := htlcRefundCmd.String("txid", "", "HTLC输出所在的交易ID")
+	htlcRefundVout := htlcRefundCmd.Int("vout", 0, "HTLC输出在交易中的索引")
+	htlcRefundAddress := htlcRefundCmd.String("address", "", "付款人的钱包地址")
+	htlcRefundFee := htlcRefundCmd.Int("fee", -1, "支付给矿工的手续费，默认按估算的手续费率计算")
+	extractSecretTxID := extractSecretCmd.String("txid", "", "HTLC输出所在的交易ID")
+	extractSecretVout := extractSecretCmd.Int("vout", 0, "HTLC输出在交易中的索引")
+	sendDataFrom := sendDataCmd.String("from", "", "发送者的钱包地址")
+	sendDataHex := sendDataCmd.String("hex", "", "写入区块链的数据（十六进制）")
+	sendDataFee := sendDataCmd.Int("fee", -1, "支付给矿工的手续费，默认按估算的手续费率计算")
+	sendManyFrom := sendManyCmd.String("from", "", "钱包源地址")
+	sendManyFile := sendManyCmd.String("file", "", "收款人文件（JSON或CSV）")
+	sendManyFee := sendManyCmd.Int("fee", -1, "支付给矿工的手续费，默认按估算的手续费率计算")
+	sendManyCoinSelect := sendManyCmd.String("coinselect", coinSelectFirst, "选币策略：first、largest、smallest、bnb或random")
+	sendManyInputs := sendManyCmd.String("inputs", "", "手动指定花费的输出，逗号分隔的txid:vout")
+	anchorFrom := anchorCmd.String("from", "", "发送者的钱包地址")
+	anchorFile := anchorCmd.String("file", "", "要证明存在的文件")
+	anchorFee := anchorCmd.Int("fee", -1, "支付给矿工的手续费，默认按估算的手续费率计算")
+	verifyAnchorFile := verifyAnchorCmd.String("file", "", "要验证的文件")
+	verifyAnchorHex := verifyAnchorCmd.String("hex", "", "要验证的数据（十六进制）")
 
 	//os.Args包含以程序名称开始的命令行参数
 	switch os.Args[1] { //os.Args[0]为程序名称，真正传递的参数index从1开始，一般而言Args[1]为命令名称
@@ -114,6 +222,96 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "listbanned":
+		err := listBannedCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "setban":
+		err := setBanCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "bumpfee":
+		err := bumpFeeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "abandontx":
+		err := abandonTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "estimatefee":
+		err := estimateFeeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getmininginfo":
+		err := getMiningInfoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "poolshares":
+		err := poolSharesCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisig":
+		err := createMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "spendmultisig":
+		err := spendMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmultisig":
+		err := signMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "htlc-create":
+		err := htlcCreateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "htlc-redeem":
+		err := htlcRedeemCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "htlc-refund":
+		err := htlcRefundCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "extractsecret":
+		err := extractSecretCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "senddata":
+		err := sendDataCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendmany":
+		err := sendManyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "anchor":
+		err := anchorCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifyanchor":
+		err := verifyAnchorCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.printUsage()
 		os.Exit(1)
@@ -132,7 +330,20 @@ func (cli *CLI) Run() {
 			createBlockchainCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockchain(*createBlockchainAddress, nodeID)
+		params := ChainParams{
+			Consensus:     *createBlockchainConsensus,
+			StakeMaturity: *createBlockchainStakeMaturity,
+			SlotInterval:  *createBlockchainSlotInterval,
+		}
+		if *createBlockchainValidators != "" {
+			params.Validators = strings.Split(*createBlockchainValidators, ",")
+		}
+		if err := params.Validate(); err != nil {
+			fmt.Println(err)
+			createBlockchainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createBlockchain(*createBlockchainAddress, nodeID, params)
 	}
 
 	if printChainCmd.Parsed() {
@@ -144,7 +355,7 @@ func (cli *CLI) Run() {
 	}
 
 	if listAddressesCmd.Parsed() {
-		cli.listAddresses(nodeID)
+		cli.listAddresses(*listAddressesPubKey, nodeID)
 	}
 
 	if reindexUTXOCmd.Parsed() {
@@ -152,12 +363,147 @@ func (cli *CLI) Run() {
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < -1 ||
+			*sendLockTime < 0 || *sendRelativeLock < 0 || *sendRelativeLock > sequenceLockTimeMask {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, *sendLockTime, *sendRelativeLock, *sendCoinSelect, *sendInputs, nodeID, *sendMine)
+	}
+
+	if listBannedCmd.Parsed() {
+		cli.listBanned(nodeID)
+	}
+
+	if setBanCmd.Parsed() {
+		if *setBanNode == "" || *setBanTime < 0 {
+			setBanCmd.Usage()
+			os.Exit(1)
+		}
+		cli.setBan(*setBanNode, *setBanTime, *setBanRemove, nodeID)
+	}
+
+	if bumpFeeCmd.Parsed() {
+		if *bumpFeeTxID == "" || *bumpFeeFee < 0 {
+			bumpFeeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.bumpFee(*bumpFeeTxID, *bumpFeeFee, nodeID)
+	}
+
+	if abandonTxCmd.Parsed() {
+		if *abandonTxTxID == "" || *abandonTxFee < 0 {
+			abandonTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.abandonTx(*abandonTxTxID, *abandonTxFee, nodeID)
+	}
+
+	if estimateFeeCmd.Parsed() {
+		if *estimateFeeBlocks <= 0 || *estimateFeeBlocks > maxConfirmTarget {
+			estimateFeeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.estimateFee(*estimateFeeBlocks, nodeID)
+	}
+
+	if getMiningInfoCmd.Parsed() {
+		cli.getMiningInfo(*getMiningInfoNode, nodeID)
+	}
+
+	if poolSharesCmd.Parsed() {
+		cli.poolShares(nodeID)
+	}
+
+	if createMultiSigCmd.Parsed() {
+		if *createMultiSigM <= 0 || *createMultiSigKeys == "" {
+			createMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultiSig(*createMultiSigM, strings.Split(*createMultiSigKeys, ","), *createMultiSigP2SH, nodeID)
+	}
+
+	if spendMultiSigCmd.Parsed() {
+		if *spendMultiSigFrom == "" || *spendMultiSigTo == "" || *spendMultiSigAmount <= 0 ||
+			*spendMultiSigFee < -1 || *spendMultiSigFile == "" {
+			spendMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.spendMultiSig(*spendMultiSigFrom, *spendMultiSigTo, *spendMultiSigAmount, *spendMultiSigFee,
+			*spendMultiSigRedeemScript, *spendMultiSigFile, nodeID)
+	}
+
+	if signMultiSigCmd.Parsed() {
+		if *signMultiSigFile == "" || *signMultiSigAddress == "" {
+			signMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMultiSig(*signMultiSigFile, *signMultiSigAddress, *signMultiSigSend, nodeID)
+	}
+
+	if htlcCreateCmd.Parsed() {
+		if *htlcCreateFrom == "" || *htlcCreateTo == "" || *htlcCreateAmount <= 0 || *htlcCreateFee < -1 || *htlcCreateLockTime <= 0 {
+			htlcCreateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.htlcCreate(*htlcCreateFrom, *htlcCreateTo, *htlcCreateAmount, *htlcCreateFee, *htlcCreateHash, *htlcCreateLockTime, nodeID)
+	}
+
+	if htlcRedeemCmd.Parsed() {
+		if *htlcRedeemTxID == "" || *htlcRedeemSecret == "" || *htlcRedeemAddress == "" || *htlcRedeemFee < -1 {
+			htlcRedeemCmd.Usage()
+			os.Exit(1)
+		}
+		cli.htlcRedeem(*htlcRedeemTxID, *htlcRedeemVout, *htlcRedeemSecret, *htlcRedeemAddress, *htlcRedeemFee, nodeID)
+	}
+
+	if htlcRefundCmd.Parsed() {
+		if *htlcRefundTxID == "" || *htlcRefundAddress == "" || *htlcRefundFee < -1 {
+			htlcRefundCmd.Usage()
+			os.Exit(1)
+		}
+		cli.htlcRefund(*htlcRefundTxID, *htlcRefundVout, *htlcRefundAddress, *htlcRefundFee, nodeID)
+	}
+
+	if extractSecretCmd.Parsed() {
+		if *extractSecretTxID == "" {
+			extractSecretCmd.Usage()
+			os.Exit(1)
+		}
+		cli.extractSecret(*extractSecretTxID, *extractSecretVout, nodeID)
+	}
+
+	if sendDataCmd.Parsed() {
+		if *sendDataFrom == "" || *sendDataHex == "" || *sendDataFee < -1 {
+			sendDataCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendData(*sendDataFrom, *sendDataHex, *sendDataFee, nodeID)
+	}
+
+	if sendManyCmd.Parsed() {
+		if *sendManyFrom == "" || *sendManyFile == "" || *sendManyFee < -1 {
+			sendManyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendMany(*sendManyFrom, *sendManyFile, *sendManyFee, *sendManyCoinSelect, *sendManyInputs, nodeID)
+	}
+
+	if anchorCmd.Parsed() {
+		if *anchorFrom == "" || *anchorFile == "" || *anchorFee < -1 {
+			anchorCmd.Usage()
+			os.Exit(1)
+		}
+		cli.anchor(*anchorFrom, *anchorFile, *anchorFee, nodeID)
+	}
+
+	if verifyAnchorCmd.Parsed() {
+		if (*verifyAnchorFile == "") == (*verifyAnchorHex == "") {
+			verifyAnchorCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyAnchor(*verifyAnchorFile, *verifyAnchorHex, nodeID)
 	}
 
 	if startNodeCmd.Parsed() {
@@ -166,6 +512,27 @@ func (cli *CLI) Run() {
 			startNodeCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startNode(nodeID, *startNodeMiner)
+		if *startNodeMaxMempool <= 0 || *startNodeMinRelayFee < 0 || *startNodeMempoolExpiry <= 0 ||
+			*startNodeMinerThreads <= 0 || *startNodeBlockInterval < 0 ||
+			*startNodeShareBits <= 0 || *startNodeShareBits > targetBits {
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+		if *startNodeStratum != "" && !ValidateAddress(*startNodePoolAddress) {
+			fmt.Println("启用矿池时必须用-pooladdress指定有效的钱包地址")
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+		options := ServerOptions{
+			MaxMempoolSize:  *startNodeMaxMempool * 1024,
+			MinRelayFeeRate: *startNodeMinRelayFee,
+			MempoolExpiry:   time.Duration(*startNodeMempoolExpiry) * time.Hour,
+			MinerWorkers:    *startNodeMinerThreads,
+			BlockInterval:   time.Duration(*startNodeBlockInterval) * time.Second,
+			StratumAddress:  *startNodeStratum,
+			PoolAddress:     *startNodePoolAddress,
+			ShareBits:       *startNodeShareBits,
+		}
+		cli.startNode(nodeID, *startNodeMiner, options)
 	}
 }
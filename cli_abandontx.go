@@ -0,0 +1,42 @@
+package blockchain7
+
+import (
+	"fmt"
+	"log"
+)
+
+// abandonTx 放弃本节点交易池中一个尚未上链的交易
+// 创建一个花费相同输入、把全部金额（扣除更高的手续费）退回给发送者的交易，替换原交易
+// fee为新交易的手续费，为0时使用原交易（连同其后代交易）手续费总额的两倍
+func (cli *CLI) abandonTx(txID string, fee int, nodeID string) {
+	bc := NewBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	pool := NewMempool(bc, defaultMaxMempoolSize, defaultMinRelayFeeRate, defaultMempoolExpiry)
+	pool.LoadFromFile(nodeID)
+
+	tx, wallet := findReplaceableTx(pool, txID, nodeID)
+	from := fmt.Sprintf("%s", wallet.GetAddress())
+
+	oldFee := pool.DescendantFee(tx.ID)
+	if fee == 0 {
+		fee = oldFee * 2
+	}
+	if fee <= oldFee {
+		log.Panicf("ERROR: 新的手续费必须高于原交易（连同其后代交易）的手续费 %d", oldFee)
+	}
+
+	//原交易的输入总额：输出总额加上原交易自身的手续费
+	inValue := pool.Fee(tx.ID)
+	for _, out := range tx.Vout {
+		inValue += out.Value
+	}
+	if inValue <= fee {
+		log.Panic("ERROR: 原交易的输入总额不足以支付新的手续费")
+	}
+
+	newTx := replaceTx(pool, tx, wallet, []TxOutput{*NewTxOutput(inValue-fee, from)}, fee)
+	pool.SaveToFile(nodeID)
+
+	fmt.Printf("已放弃交易 %x，%d 退回 %s，新交易 %x\n", tx.ID, inValue-fee, from, newTx.ID)
+}
@@ -0,0 +1,27 @@
+package blockchain7
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// anchor 把文件的SHA-256哈希写入区块链的数据输出，交易上链后可以用verifyanchor证明文件在该区块的时间之前已经存在
+func (cli *CLI) anchor(from, file string, fee int, nodeID string) {
+	hash := fileHash(file)
+
+	tx := sendDataTx(from, hash, fee, nodeID)
+	fmt.Printf("文件 %s 的哈希 %x 已写入交易 %x\n", file, hash, tx.ID)
+}
+
+// fileHash 计算文件内容的SHA-256哈希
+func fileHash(file string) []byte {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	hash := sha256.Sum256(content)
+
+	return hash[:]
+}
@@ -0,0 +1,102 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// bumpFee 提高本节点交易池中一个交易的手续费
+// 创建一个花费相同输入、原样保留所有付款输出（包括P2SH、多重签名、HTLC和数据输出）、但手续费更高的交易，替换原交易
+// fee为新交易的手续费，为0时使用原交易（连同其后代交易）手续费总额的两倍
+func (cli *CLI) bumpFee(txID string, fee int, nodeID string) {
+	bc := NewBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	pool := NewMempool(bc, defaultMaxMempoolSize, defaultMinRelayFeeRate, defaultMempoolExpiry)
+	pool.LoadFromFile(nodeID)
+
+	tx, wallet := findReplaceableTx(pool, txID, nodeID)
+
+	oldFee := pool.DescendantFee(tx.ID)
+	if fee == 0 {
+		fee = oldFee * 2
+	}
+	if fee <= oldFee {
+		log.Panicf("ERROR: 新的手续费必须高于原交易（连同其后代交易）的手续费 %d", oldFee)
+	}
+
+	payments := replacementPayments(tx, wallet)
+	if len(payments) == 0 {
+		log.Panic("ERROR: 交易只有找零，没有付款输出，请使用abandontx")
+	}
+
+	newTx := replaceTx(pool, tx, wallet, payments, fee)
+	pool.SaveToFile(nodeID)
+
+	fmt.Printf("交易 %x 的手续费从 %d 提高到 %d，新交易 %x\n", tx.ID, oldFee, fee, newTx.ID)
+	fmt.Printf("保留了原交易的 %d 个付款输出\n", len(payments))
+}
+
+// replacementPayments 原交易的付款输出：除了锁定到发送者自己的P2PKH找零之外的全部输出，锁定脚本原样保留
+func replacementPayments(tx *Transaction, wallet *Wallet) []TxOutput {
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+	var payments []TxOutput
+	for _, out := range tx.Vout {
+		if !out.IsLockedWithKey(pubKeyHash) {
+			payments = append(payments, out)
+		}
+	}
+
+	return payments
+}
+
+// findReplaceableTx 在交易池中查找一个接受手续费替换的交易，以及本节点中发送该交易的钱包
+func findReplaceableTx(pool *Mempool, txID string, nodeID string) (*Transaction, *Wallet) {
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx, ok := pool.Get(id)
+	if !ok {
+		log.Panic("ERROR: 交易不在本节点的交易池中，可能已经上链")
+	}
+	if !tx.SignalsReplacement() {
+		log.Panic("ERROR: 交易不接受手续费替换")
+	}
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	for _, wallet := range wallets.Wallets {
+		if bytes.Compare(wallet.PublicKey, tx.Vin[0].SignerPubKey()) == 0 {
+			return &tx, wallet
+		}
+	}
+	log.Panic("ERROR: 交易不是本节点的钱包发送的")
+
+	return nil, nil
+}
+
+// replaceTx 创建一个支付payments的替换交易：优先花费原交易的输入，这样新交易与原交易冲突，节点按手续费替换规则用它替换原交易
+// 新交易沿用原交易的锁定时间和相对锁定；原交易（连同其后代交易）从本地交易池中删除，新交易加入本地交易池并发送给中心节点
+func replaceTx(pool *Mempool, tx *Transaction, wallet *Wallet, payments []TxOutput, fee int) *Transaction {
+	pool.Remove(tx.ID)
+
+	view := pool.View()
+	for _, vin := range tx.Vin {
+		view.Prefer(vin.Txid, vin.Vout)
+	}
+	newTx := NewPaymentTransaction(wallet, payments, fee, tx.LockTime, tx.Vin[0].RelativeLock(), view)
+
+	err := pool.Add(newTx)
+	if err != nil {
+		fmt.Printf("交易未能加入本地交易池: %s\n", err)
+	}
+	sendTx(knownNodes[0], newTx) //发送给中心节点
+
+	return newTx
+}
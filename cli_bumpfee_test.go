@@ -0,0 +1,58 @@
+package blockchain7
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceTxKeepsPaymentOutputs(t *testing.T) {
+	script, err := NewMultiSigScript(1, [][]byte{NewWallet().PublicKey, NewWallet().PublicKey})
+	assert.NoError(t, err)
+	data, err := NewDataOutput([]byte("anchor"))
+	assert.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		payments func() []TxOutput
+	}{
+		{"p2sh payment", func() []TxOutput {
+			return []TxOutput{*NewTxOutput(3, string(ScriptHashAddress(script)))}
+		}},
+		{"sendmany payment with data output", func() []TxOutput {
+			return []TxOutput{
+				*NewTxOutput(1, string(NewWallet().GetAddress())),
+				*NewTxOutput(2, string(NewWallet().GetAddress())),
+				*NewTxOutput(3, string(MultiSigAddress(script))),
+				*data,
+			}
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, wallet := newTestChain(t)
+			peer := newTestPeer(t)
+			newTestBanManager(t, peer.addr)
+			payments := c.payments()
+			original := NewPaymentTransaction(wallet, payments, 1, 0, 0, mempool.View())
+			assert.NoError(t, mempool.Add(original))
+
+			kept := replacementPayments(original, wallet)
+			assert.Equal(t, payments, kept, "change is not a payment")
+			replacement := replaceTx(mempool, original, wallet, kept, 3)
+
+			//付款输出的锁定脚本原样保留，只有找零减少
+			assert.Equal(t, original.TrimmedCopy().Vin, replacement.TrimmedCopy().Vin, "spends the same inputs")
+			assert.Equal(t, payments, replacement.Vout[:len(payments)])
+			assert.Len(t, replacement.Vout, len(original.Vout))
+			assert.Equal(t, original.Vout[len(payments)].Value-2, replacement.Vout[len(payments)].Value)
+			assert.Equal(t, 3, mempool.Fee(replacement.ID))
+			assert.False(t, mempool.Has(original.ID))
+
+			var msg tx
+			peer.expect(t, "tx", &msg)
+			assert.Equal(t, replacement.Serialize(), msg.Transaction)
+		})
+	}
+}
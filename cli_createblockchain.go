@@ -6,11 +6,11 @@ import (
 )
 
 //createBlockchain 创建全新区块链
-func (cli *CLI) createBlockchain(address string, nodeID string) {
+func (cli *CLI) createBlockchain(address string, nodeID string, params ChainParams) {
 	if !ValidateAddress(address) {
 		log.Panic("ERROR: 地址非法")
 	}
-	bc := CreatBlockchain(address, nodeID) //注意，这里调用的是blockchain.go中的函数
+	bc := CreatBlockchain(address, nodeID, params) //注意，这里调用的是blockchain.go中的函数
 	//bc := NewBlockchain()
 	defer bc.Db.Close()
 
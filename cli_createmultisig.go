@@ -0,0 +1,43 @@
+package blockchain7
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// createMultiSig 创建M-of-N多重签名地址，keys为本节点的钱包地址或十六进制的原生态公钥（其他节点用listaddresses -pubkey查看）
+// p2sh为true时创建较短的P2SH地址，花费时需要提供赎回脚本
+func (cli *CLI) createMultiSig(m int, keys []string, p2sh bool, nodeID string) {
+	wallets, _ := NewWallets(nodeID) //没有钱包文件时所有的key都必须是公钥
+
+	var pubKeys [][]byte
+	for _, key := range keys {
+		if wallet, ok := wallets.Wallets[canonicalAddress(key)]; ok {
+			pubKeys = append(pubKeys, wallet.PublicKey)
+			continue
+		}
+		pubKey, err := hex.DecodeString(key)
+		if err != nil || len(pubKey) == 0 {
+			log.Panicf("ERROR: %s 既不是本节点的钱包地址，也不是十六进制的公钥", key)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	script, err := NewMultiSigScript(m, pubKeys)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if p2sh {
+		if len(script) > maxScriptElementSize {
+			log.Panicf("ERROR: 赎回脚本超过%d个字节，请减少公钥数", maxScriptElementSize)
+		}
+		fmt.Printf("P2SH多重签名地址（%d-of-%d）: %s\n", m, len(pubKeys), ScriptHashAddress(script))
+		fmt.Printf("赎回脚本: %x\n", script)
+		fmt.Printf("         %s\n", DisasmScript(script))
+		return
+	}
+	fmt.Printf("多重签名地址（%d-of-%d）: %s\n", m, len(pubKeys), MultiSigAddress(script))
+	fmt.Printf("锁定脚本: %s\n", DisasmScript(script))
+}
@@ -0,0 +1,30 @@
+package blockchain7
+
+import "fmt"
+
+// estimateFee 估算交易在blocks个区块内确认需要的手续费率
+func (cli *CLI) estimateFee(blocks int, nodeID string) {
+	estimator := NewFeeEstimator(0)
+	estimator.LoadFromFile(nodeID)
+
+	feeRate, ok := estimator.Estimate(blocks)
+	if !ok {
+		fmt.Printf("数据不足，无法估算手续费率，最低转发费率为 %d（每千字节）\n", defaultMinRelayFeeRate)
+		return
+	}
+
+	fmt.Printf("在%d个区块内确认需要的手续费率: %d（每千字节）\n", blocks, feeRate)
+}
+
+// estimateFeeRate 估算在blocks个区块内确认需要的手续费率，数据不足时使用最低转发费率
+func estimateFeeRate(blocks int, nodeID string) int {
+	estimator := NewFeeEstimator(0)
+	estimator.LoadFromFile(nodeID)
+
+	feeRate, ok := estimator.Estimate(blocks)
+	if !ok || feeRate < defaultMinRelayFeeRate {
+		return defaultMinRelayFeeRate
+	}
+
+	return feeRate
+}
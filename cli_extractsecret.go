@@ -0,0 +1,62 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// extractSecret 查找花费HTLC输出txid:vout的交易，取出收款人取款时公开的原像
+// 跨链原子交换中，发起方在另一条链上取款后，对方用这个原像在本链上取款
+func (cli *CLI) extractSecret(txid string, vout int, nodeID string) {
+	htlcTxID, err := hex.DecodeString(txid)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := NewBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	htlcTx, err := bc.FindTransaction(htlcTxID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if vout < 0 || vout >= len(htlcTx.Vout) {
+		log.Panic("ERROR: HTLC输出不存在")
+	}
+	h := extractHTLC(htlcTx.Vout[vout].ScriptPubKey)
+	if h == nil {
+		log.Panic("ERROR: 输出不是HTLC输出")
+	}
+
+	//先查找本地交易池中尚未上链的交易，再查找区块链
+	pool := NewMempool(bc, defaultMaxMempoolSize, defaultMinRelayFeeRate, defaultMempoolExpiry)
+	pool.LoadFromFile(nodeID)
+	txs := pool.Transactions()
+
+	bci := bc.Iterator()
+	for {
+		block := bci.Next()
+		txs = append(txs, block.Transactions...)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	for _, tx := range txs {
+		for _, vin := range tx.Vin {
+			if !bytes.Equal(vin.Txid, htlcTxID) || vin.Vout != vout {
+				continue
+			}
+			secret := extractHTLCSecret(vin, h)
+			if secret == nil {
+				log.Panicf("ERROR: HTLC输出已经被交易 %x 取回，没有公开原像", tx.ID)
+			}
+			fmt.Printf("交易 %x 公开了原像: %x\n", tx.ID, secret)
+			return
+		}
+	}
+
+	fmt.Println("HTLC输出还没有被花费")
+}
@@ -14,14 +14,13 @@ func (cli *CLI) getBalance(address string, nodeID string) {
 	defer bc.Db.Close()
 
 	balance := 0
-	pubKeyHash := Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	script := NewTxOutput(0, address).ScriptPubKey //地址对应的锁定脚本，普通地址、P2SH地址和多重签名地址都适用
 
 	UTXOSet := UTXOSet{bc}
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
+	UTXOs := UTXOSet.FindScriptOutputs(script)
 
-	for _, output := range UTXOs {
-		balance += output.Value
+	for _, u := range UTXOs {
+		balance += u.Output.Value
 	}
 
 	fmt.Printf("'%s'的账号余额是: %d\n", address, balance)
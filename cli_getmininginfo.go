@@ -0,0 +1,41 @@
+package blockchain7
+
+import (
+	"fmt"
+	"log"
+)
+
+// getMiningInfo 查询运行中的节点的挖矿信息
+func (cli *CLI) getMiningInfo(node, nodeID string) {
+	if node == "" {
+		node = fmt.Sprintf("localhost:%s", nodeID)
+	}
+
+	var reply mininginfo
+	err := callNode(node, "getmininginfo", getmininginfo{}, &reply)
+	if err != nil {
+		log.Panic(err)
+	}
+	info := reply.Info
+
+	fmt.Printf("共识算法: %s\n", info.Consensus)
+	fmt.Printf("区块高度: %d\n", info.Height)
+	if info.Consensus == "PoW" {
+		fmt.Printf("难度: %d位（平均每个区块 %.0f 次哈希）\n", info.TargetBits, info.Difficulty)
+		fmt.Printf("全网算力（估算）: %.0f 哈希/秒\n", info.NetworkHashRate)
+	}
+	if info.AvgBlockInterval > 0 {
+		fmt.Printf("平均出块间隔: %.1f 秒\n", info.AvgBlockInterval)
+	}
+	if !info.Mining {
+		fmt.Println("节点没有在挖矿")
+		return
+	}
+	fmt.Printf("目标出块间隔: %.1f 秒\n", info.TargetBlockInterval)
+	fmt.Printf("挖矿协程数: %d\n", info.Workers)
+	fmt.Printf("本节点算力: %.0f 哈希/秒\n", info.HashRate)
+	fmt.Printf("挖出区块数: %d\n", info.BlocksMined)
+	if info.BlocksMined > 0 {
+		fmt.Printf("最近一个区块的哈希次数: %d，平均每个区块: %.0f\n", info.LastAttempts, info.AvgAttempts)
+	}
+}
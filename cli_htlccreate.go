@@ -0,0 +1,70 @@
+package blockchain7
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// htlcCreate 从from向to支付amount到一个HTLC输出：to出示原像可以取款，到达lockTime之后from可以取回
+// secretHash为空时产生一个随机原像，由发起方保存；跨链原子交换的另一方使用发起方公布的secretHash
+// fee小于0时按estimatefee估算的手续费率支付手续费
+func (cli *CLI) htlcCreate(from, to string, amount, fee int, secretHash string, lockTime int64, nodeID string) {
+	if !ValidateAddress(from) {
+		log.Panic("ERROR: 发送地址非法")
+	}
+	if !ValidateAddress(to) {
+		log.Panic("ERROR: 接收地址非法")
+	}
+	recipient := NewTxOutput(0, to).PubKeyHash()
+	if recipient == nil {
+		log.Panic("ERROR: 接收地址必须是普通的钱包地址")
+	}
+
+	var secret, hash []byte
+	if secretHash == "" {
+		secret, hash = NewHTLCSecret()
+	} else {
+		var err error
+		hash, err = hex.DecodeString(secretHash)
+		if err != nil || len(hash) != 32 {
+			log.Panic("ERROR: 原像哈希必须是32字节的十六进制数")
+		}
+	}
+
+	wallet := loadWallet(from, nodeID)
+	h := HTLC{hash, recipient, HashPubKey(wallet.PublicKey), lockTime}
+	payments := []TxOutput{{amount, h.Script()}}
+
+	bc := NewBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	pool := NewMempool(bc, defaultMaxMempoolSize, defaultMinRelayFeeRate, defaultMempoolExpiry)
+	pool.LoadFromFile(nodeID)
+
+	view := pool.View()
+	build := func(fee int) *Transaction {
+		return NewPaymentTransaction(wallet, payments, fee, 0, 0, view)
+	}
+	var tx *Transaction
+	if fee < 0 {
+		feeRate := estimateFeeRate(defaultConfirmTarget, nodeID)
+		tx, fee = buildWithFeeRate(feeRate, build)
+		fmt.Printf("手续费率 %d（每千字节），手续费 %d\n", feeRate, fee)
+	} else {
+		tx = build(fee)
+	}
+	err := pool.Add(tx)
+	if err != nil {
+		fmt.Printf("交易未能加入本地交易池: %s\n", err)
+	}
+	sendTx(knownNodes[0], tx) //发送给中心节点
+	pool.SaveToFile(nodeID)
+
+	fmt.Printf("HTLC输出: %x:0\n", tx.ID)
+	fmt.Printf("原像哈希: %x\n", hash)
+	if secret != nil {
+		fmt.Printf("原像: %x（请妥善保存，取款时公开）\n", secret)
+	}
+	fmt.Printf("锁定脚本: %s\n", DisasmScript(h.Script()))
+}
@@ -0,0 +1,65 @@
+package blockchain7
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// htlcRedeem 收款人address出示原像secret，取走HTLC输出txid:vout
+func (cli *CLI) htlcRedeem(txid string, vout int, secret, address string, fee int, nodeID string) {
+	preimage, err := hex.DecodeString(secret)
+	if err != nil || len(preimage) == 0 {
+		log.Panic("ERROR: 原像必须是十六进制数")
+	}
+
+	spendHTLC(txid, vout, preimage, address, fee, nodeID)
+}
+
+// spendHTLC 花费HTLC输出txid:vout，支付给钱包address，secret为nil时为付款人取回
+// 交易加入本地交易池并发送给中心节点，fee小于0时按estimatefee估算的手续费率支付手续费
+func spendHTLC(txid string, vout int, secret []byte, address string, fee int, nodeID string) {
+	htlcTxID, err := hex.DecodeString(txid)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := loadWallet(address, nodeID)
+
+	bc := NewBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	pool := NewMempool(bc, defaultMaxMempoolSize, defaultMinRelayFeeRate, defaultMempoolExpiry)
+	pool.LoadFromFile(nodeID)
+
+	out, ok := pool.View().FindOutput(htlcTxID, vout)
+	if !ok {
+		log.Panic("ERROR: HTLC输出不存在或已经花费")
+	}
+
+	if fee < 0 {
+		feeRate := estimateFeeRate(defaultConfirmTarget, nodeID)
+		draft, err := NewHTLCSpendTransaction(htlcTxID, vout, out, wallet, secret, 0)
+		if err != nil {
+			log.Panic(err)
+		}
+		fee = feeForSize(feeRate, len(draft.Serialize()))
+		fmt.Printf("手续费率 %d（每千字节），手续费 %d\n", feeRate, fee)
+	}
+
+	tx, err := NewHTLCSpendTransaction(htlcTxID, vout, out, wallet, secret, fee)
+	if err != nil {
+		log.Panic(err)
+	}
+	err = pool.Add(tx)
+	if errors.Is(err, ErrTxNonFinal) {
+		log.Panic(err)
+	}
+	if err != nil {
+		fmt.Printf("交易未能加入本地交易池: %s\n", err)
+	}
+	sendTx(knownNodes[0], tx) //发送给中心节点
+	pool.SaveToFile(nodeID)
+
+	fmt.Printf("交易 %x 将HTLC输出中的 %d 支付给 %s\n", tx.ID, tx.Vout[0].Value, address)
+}
@@ -0,0 +1,6 @@
+package blockchain7
+
+// htlcRefund 付款人address在到达锁定时间之后取回HTLC输出txid:vout
+func (cli *CLI) htlcRefund(txid string, vout int, address string, fee int, nodeID string) {
+	spendHTLC(txid, vout, nil, address, fee, nodeID)
+}
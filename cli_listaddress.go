@@ -5,8 +5,8 @@ import (
 	"log"
 )
 
-//listAddresses 列出所有钱包的地址
-func (cli *CLI) listAddresses(nodeID string) {
+//listAddresses 列出所有钱包的地址，showPubKey为true时同时列出十六进制的原生态公钥，用于创建多重签名地址
+func (cli *CLI) listAddresses(showPubKey bool, nodeID string) {
 	wallets, err := NewWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
@@ -14,6 +14,10 @@ func (cli *CLI) listAddresses(nodeID string) {
 	addresses := wallets.GetAddresses()
 
 	for _, address := range addresses {
+		if showPubKey {
+			fmt.Printf("%s %x\n", address, wallets.Wallets[address].PublicKey)
+			continue
+		}
 		fmt.Println(address)
 	}
 }
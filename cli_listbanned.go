@@ -0,0 +1,19 @@
+package blockchain7
+
+import (
+	"fmt"
+	"time"
+)
+
+//listBanned 列出所有被封禁的节点
+func (cli *CLI) listBanned(nodeID string) {
+	bans := NewBans(nodeID)
+
+	for address, entry := range bans.Entries {
+		if !bans.IsBanned(address) { //已经过期，节点运行时会清除
+			continue
+		}
+		until := time.Unix(entry.Until, 0).Format("2006-01-02 15:04:05")
+		fmt.Printf("%s 封禁至 %s，原因: %s\n", address, until, entry.Reason)
+	}
+}
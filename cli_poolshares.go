@@ -0,0 +1,34 @@
+package blockchain7
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// poolShares 列出矿池中每个矿工提交的share数和所占比例，按比例分配矿池收入
+func (cli *CLI) poolShares(nodeID string) {
+	shares := NewPoolShares(nodeID)
+
+	total := 0
+	var names []string
+	for name, w := range shares.Workers {
+		total += w.Shares
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		w := shares.Workers[name]
+		percent := 0.0
+		if total > 0 {
+			percent = float64(w.Shares) * 100 / float64(total)
+		}
+		lastShare := "无"
+		if w.LastShare > 0 {
+			lastShare = time.Unix(w.LastShare, 0).Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%s: 有效share %d（%.2f%%），拒绝 %d，找到区块 %d，最后提交 %s\n", name, w.Shares, percent, w.Rejected, w.Blocks, lastShare)
+	}
+	fmt.Printf("共 %d 个矿工，%d 个有效share\n", len(names), total)
+}
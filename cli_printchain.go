@@ -17,8 +17,8 @@ func (cli *CLI) printChain(nodeID string) {
 		fmt.Printf("Prev. Hash:%x\n", block.PrevBlockHash)
 		//fmt.Printf("Data:%s\n", block.Data)
 		fmt.Printf("Hash:%x\n", block.Hash)
-		pow := NewProofOfWork(block)
-		fmt.Printf("PoW:%s\n", strconv.FormatBool(pow.Validate()))
+		header := block.Header()
+		fmt.Printf("%s:%s\n", consensus.Name(), strconv.FormatBool(consensus.VerifyHeader(&header)))
 		fmt.Println()
 
 		for _, tx := range block.Transactions {
@@ -1,12 +1,18 @@
 package blockchain7
 
 import (
+	"encoding/hex"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 )
 
-//send 转账
-func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
+//send 转账，fee小于0时按estimatefee估算的手续费率支付手续费
+//lockTime和relativeLock为交易的锁定时间和输入的相对锁定（区块数），到达之前交易不能上链，见NewLockedUTXOTransaction
+//coinSelect为选币策略，inputs为手动指定花费的输出，见applyCoinSelection
+func (cli *CLI) send(from, to string, amount, fee int, lockTime int64, relativeLock int, coinSelect, inputs string, nodeID string, mineNow bool) {
 	if !ValidateAddress(from) {
 		log.Panic("ERROR: 发送地址非法")
 	}
@@ -24,17 +30,107 @@ func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
 	}
 	wallet := wallets.GetWallet(from)
 
-	tx := NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
+	//读取本节点保存的交易池，这样可以花费之前转账中尚未上链的找零，连续转账时不必等待确认
+	pool := NewMempool(bc, defaultMaxMempoolSize, defaultMinRelayFeeRate, defaultMempoolExpiry)
+	pool.LoadFromFile(nodeID)
 
-	if mineNow { //当前是挖矿节点，有奖励
-		cbTx := NewCoinbaseTX(from, "")
-		txs := []*Transaction{cbTx, tx}
+	view := pool.View()
+	applyCoinSelection(view, coinSelect, inputs, &wallet)
+	build := func(fee int) *Transaction {
+		return NewLockedUTXOTransaction(&wallet, to, amount, fee, lockTime, relativeLock, view)
+	}
+	var tx *Transaction
+	if fee < 0 { //未指定手续费，按估算的手续费率和交易的大小计算
+		feeRate := estimateFeeRate(defaultConfirmTarget, nodeID)
+		tx, fee = buildWithFeeRate(feeRate, build)
+		fmt.Printf("手续费率 %d（每千字节），手续费 %d\n", feeRate, fee)
+	} else {
+		tx = build(fee)
+	}
 
-		newBlock := bc.MineBlock(txs)
-		UTXOSet.Update(newBlock)
-	} else { //非挖矿节点
+	if mineNow { //当前是挖矿节点，有奖励，手续费也归自己
+		err := checkLocks(tx, bc.GetBestHeight()+1, time.Now().Unix(), UTXOSet.TxHeight)
+		if err != nil {
+			log.Panic(err)
+		}
+		if a, ok := consensus.(authorizer); ok { //用发送者的私钥出块，发送者须是轮到出块的验证者
+			a.Authorize(&wallet)
+		}
+		//交易可能花费交易池中交易的输出，交易池中的交易一起打包，父交易在前
+		txs := pool.Select(maxBlockSize)
+		for _, t := range txs {
+			fee += pool.Fee(t.ID)
+		}
+		cbTx := NewCoinbaseTX(from, "", fee)
+		txs = append([]*Transaction{cbTx}, append(txs, tx)...)
+
+		newBlock, err := bc.MineBlock(txs)
+		if err != nil { //如还没有轮到发送者出块，交易按非挖矿节点的方式发送
+			fmt.Printf("未能出块: %s\n", err)
+			mineNow = false
+		} else {
+			UTXOSet.Update(newBlock)
+			pool.BlockConnected(newBlock)
+		}
+	}
+	if !mineNow { //非挖矿节点
+		err := pool.Add(tx)
+		if err != nil {
+			fmt.Printf("交易未能加入本地交易池: %s\n", err)
+		}
 		sendTx(knownNodes[0], tx) //发送给中心节点
 	}
+	pool.SaveToFile(nodeID)
 
 	fmt.Println("转账成功！")
 }
+
+//applyCoinSelection 设置视图的选币策略coinSelect（first、largest、smallest、bnb或random）
+//inputs为逗号分隔的txid:vout，不为空时只花费并且全部花费这些输出，它们必须是wallet的未花费输出
+func applyCoinSelection(view *UTXOView, coinSelect, inputs string, wallet *Wallet) {
+	selector, err := NewCoinSelector(coinSelect)
+	if err != nil {
+		log.Panic(err)
+	}
+	view.SetCoinSelector(selector)
+
+	if inputs == "" {
+		return
+	}
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+	for _, input := range strings.Split(inputs, ",") {
+		i := strings.LastIndex(input, ":")
+		if i < 0 {
+			log.Panicf("ERROR: 输出 %s 的格式应为txid:vout", input)
+		}
+		txID, err := hex.DecodeString(strings.TrimSpace(input[:i]))
+		if err != nil {
+			log.Panic(err)
+		}
+		vout, err := strconv.Atoi(input[i+1:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+		out, ok := view.FindOutput(txID, vout)
+		if !ok || !out.IsLockedWithKey(pubKeyHash) {
+			log.Panicf("ERROR: 输出 %s 不存在、已经花费或者不属于发送者", input)
+		}
+		view.UseInput(txID, vout)
+	}
+}
+
+//buildWithFeeRate 按手续费率feeRate创建交易，build(fee)创建支付手续费fee的交易
+//选币的目标金额包含手续费，而手续费又取决于选中的输入个数（交易的大小），所以用上一次创建的交易的大小计算手续费，
+//重新选币创建交易，直到交易支付的手续费足够为止，返回该交易和它支付的手续费
+func buildWithFeeRate(feeRate int, build func(fee int) *Transaction) (*Transaction, int) {
+	fee := 0
+	for {
+		tx := build(fee)
+		needed := feeForSize(feeRate, len(tx.Serialize()))
+		if needed <= fee {
+			return tx, fee
+		}
+		fee = needed //手续费只增不减，交易的大小有上限，循环一定会结束
+	}
+}
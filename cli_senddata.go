@@ -0,0 +1,59 @@
+package blockchain7
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// sendData 从from发送一个带数据输出的交易，把十六进制的data写入区块链，fee小于0时按estimatefee估算的手续费率支付手续费
+func (cli *CLI) sendData(from, data string, fee int, nodeID string) {
+	raw, err := hex.DecodeString(data)
+	if err != nil {
+		log.Panic("ERROR: 数据必须是十六进制数")
+	}
+
+	tx := sendDataTx(from, raw, fee, nodeID)
+	fmt.Printf("数据交易: %x\n", tx.ID)
+}
+
+// sendDataTx 创建并发送一个只有数据输出（以及找零）的交易，交易加入本地交易池并发送给中心节点
+func sendDataTx(from string, data []byte, fee int, nodeID string) *Transaction {
+	if !ValidateAddress(from) {
+		log.Panic("ERROR: 发送地址非法")
+	}
+	out, err := NewDataOutput(data)
+	if err != nil {
+		log.Panic(err)
+	}
+	payments := []TxOutput{*out}
+
+	wallet := loadWallet(from, nodeID)
+
+	bc := NewBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	pool := NewMempool(bc, defaultMaxMempoolSize, defaultMinRelayFeeRate, defaultMempoolExpiry)
+	pool.LoadFromFile(nodeID)
+
+	view := pool.View()
+	build := func(fee int) *Transaction {
+		return NewPaymentTransaction(wallet, payments, fee, 0, 0, view)
+	}
+	var tx *Transaction
+	if fee < 0 {
+		feeRate := estimateFeeRate(defaultConfirmTarget, nodeID)
+		tx, fee = buildWithFeeRate(feeRate, build)
+		fmt.Printf("手续费率 %d（每千字节），手续费 %d\n", feeRate, fee)
+	} else {
+		tx = build(fee)
+	}
+	err = pool.Add(tx)
+	if err != nil {
+		fmt.Printf("交易未能加入本地交易池: %s\n", err)
+	}
+	sendTx(knownNodes[0], tx) //发送给中心节点
+	pool.SaveToFile(nodeID)
+
+	return tx
+}
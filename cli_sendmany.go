@@ -0,0 +1,116 @@
+package blockchain7
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// recipient 批量转账中的一个收款人
+type recipient struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// sendMany 从from向文件file中列出的全部收款人转账，只创建一个交易：每个收款人一个输出，另加找零
+// fee小于0时按estimatefee估算的手续费率支付手续费，coinSelect和inputs见applyCoinSelection
+func (cli *CLI) sendMany(from, file string, fee int, coinSelect, inputs string, nodeID string) {
+	if !ValidateAddress(from) {
+		log.Panic("ERROR: 发送地址非法")
+	}
+	recipients, err := readRecipients(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	total := 0
+	var payments []TxOutput
+	for _, r := range recipients {
+		payments = append(payments, *NewTxOutput(r.Amount, r.Address))
+		total += r.Amount
+	}
+
+	wallet := loadWallet(from, nodeID)
+
+	bc := NewBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	pool := NewMempool(bc, defaultMaxMempoolSize, defaultMinRelayFeeRate, defaultMempoolExpiry)
+	pool.LoadFromFile(nodeID)
+
+	view := pool.View()
+	applyCoinSelection(view, coinSelect, inputs, wallet)
+	build := func(fee int) *Transaction {
+		return NewPaymentTransaction(wallet, payments, fee, 0, 0, view)
+	}
+	var tx *Transaction
+	if fee < 0 {
+		feeRate := estimateFeeRate(defaultConfirmTarget, nodeID)
+		tx, fee = buildWithFeeRate(feeRate, build)
+		fmt.Printf("手续费率 %d（每千字节），手续费 %d\n", feeRate, fee)
+	} else {
+		tx = build(fee)
+	}
+	err = pool.Add(tx)
+	if err != nil {
+		fmt.Printf("交易未能加入本地交易池: %s\n", err)
+	}
+	sendTx(knownNodes[0], tx) //发送给中心节点
+	pool.SaveToFile(nodeID)
+
+	fmt.Printf("交易 %x 向 %d 个收款人转账，合计 %d，手续费 %d\n", tx.ID, len(recipients), total, fee)
+}
+
+// readRecipients 读取收款人文件：扩展名为.json时是[{"address": "...", "amount": 10}, ...]格式的数组，
+// 否则按CSV读取，每行为“地址,金额”，第一行的金额不是数字时作为表头跳过
+func readRecipients(file string) ([]recipient, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []recipient
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		err = json.Unmarshal(content, &recipients)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		r := csv.NewReader(strings.NewReader(string(content)))
+		r.FieldsPerRecord = 2
+		r.TrimLeadingSpace = true
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			amount, err := strconv.Atoi(strings.TrimSpace(record[1]))
+			if err != nil {
+				if i == 0 {
+					continue //表头
+				}
+				return nil, fmt.Errorf("第 %d 行的金额不是整数: %s", i+1, record[1])
+			}
+			recipients = append(recipients, recipient{strings.TrimSpace(record[0]), amount})
+		}
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("文件 %s 中没有收款人", file)
+	}
+	for _, r := range recipients {
+		if !ValidateAddress(r.Address) {
+			return nil, fmt.Errorf("收款地址非法: %s", r.Address)
+		}
+		if r.Amount <= 0 {
+			return nil, fmt.Errorf("向 %s 转账的金额必须为正数", r.Address)
+		}
+	}
+
+	return recipients, nil
+}
@@ -0,0 +1,83 @@
+package blockchain7
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadRecipients(t *testing.T) {
+	alice, bob := string(NewWallet().GetAddress()), string(NewWallet().GetAddress())
+	both := []recipient{{alice, 3}, {bob, 5}}
+
+	cases := []struct {
+		name     string
+		file     string
+		content  string
+		expected []recipient //nil表示应当出错
+	}{
+		{"json", "r.json", `[{"address": "` + alice + `", "amount": 3}, {"address": "` + bob + `", "amount": 5}]`, both},
+		{"json extension is case insensitive", "r.JSON", `[{"address": "` + alice + `", "amount": 3}]`, both[:1]},
+		{"csv", "r.csv", alice + ",3\n" + bob + ", 5\n", both},
+		{"csv with header", "r.csv", "address,amount\n" + alice + ",3\n" + bob + ",5\n", both},
+		{"other extensions are csv", "r.txt", alice + ",3\n", both[:1]},
+		{"malformed json", "r.json", `[{"address": `, nil},
+		{"amount is not an integer", "r.csv", alice + ",3\n" + bob + ",five\n", nil},
+		{"wrong number of fields", "r.csv", alice + ",3,1\n", nil},
+		{"invalid address", "r.csv", "invalid,3\n", nil},
+		{"zero amount", "r.csv", alice + ",0\n", nil},
+		{"negative amount", "r.json", `[{"address": "` + alice + `", "amount": -3}]`, nil},
+		{"header only", "r.csv", "address,amount\n", nil},
+		{"empty json", "r.json", `[]`, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), c.file)
+			assert.NoError(t, ioutil.WriteFile(file, []byte(c.content), 0644))
+
+			recipients, err := readRecipients(file)
+			if c.expected == nil {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, c.expected, recipients)
+			}
+		})
+	}
+
+	_, err := readRecipients(filepath.Join(t.TempDir(), "missing.csv"))
+	assert.Error(t, err)
+}
+
+func TestSendManyTransaction(t *testing.T) {
+	_, wallet := newTestChain(t)
+	alice, bob, carol := NewWallet(), NewWallet(), NewWallet()
+
+	//与sendmany相同：每个收款人一个输出，另加找零，按手续费率计算手续费
+	var payments []TxOutput
+	for i, w := range []*Wallet{alice, bob, carol} {
+		payments = append(payments, *NewTxOutput(i+1, string(w.GetAddress())))
+	}
+	view := mempool.View()
+	tx, fee := buildWithFeeRate(1, func(fee int) *Transaction {
+		return NewPaymentTransaction(wallet, payments, fee, 0, 0, view)
+	})
+
+	assert.Len(t, tx.Vout, 4)
+	assert.Equal(t, payments, tx.Vout[:3])
+	assert.True(t, tx.Vout[3].IsLockedWithKey(HashPubKey(wallet.PublicKey)), "change")
+	assert.Equal(t, subsidy-6-fee, tx.Vout[3].Value)
+	assert.Equal(t, feeForSize(1, txSize(tx)), fee)
+	assert.NoError(t, mempool.Add(tx))
+
+	//收款人在交易池中的交易确认之前就能看到各自的输出
+	view = mempool.View()
+	for i, w := range []*Wallet{alice, bob, carol} {
+		outputs := view.FindUnspentOutputs(HashPubKey(w.PublicKey))
+		assert.Len(t, outputs, 1)
+		assert.Equal(t, i+1, outputs[0].Output.Value)
+	}
+}
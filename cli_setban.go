@@ -0,0 +1,31 @@
+package blockchain7
+
+import (
+	"fmt"
+	"time"
+)
+
+//setBan 封禁或解除封禁一个节点，address可以是节点地址（host:port）或IP
+//封禁列表保存在文件中，运行中的节点会自动读取修改后的封禁列表
+func (cli *CLI) setBan(address string, banTime int, remove bool, nodeID string) {
+	bans := NewBans(nodeID)
+
+	if remove {
+		if !bans.Unban(address) {
+			fmt.Printf("%s 没有被封禁\n", address)
+			return
+		}
+		bans.SaveToFile(nodeID)
+		fmt.Printf("已解除对 %s 的封禁\n", address)
+		return
+	}
+
+	duration := defaultBanTime
+	if banTime > 0 {
+		duration = time.Duration(banTime) * time.Second
+	}
+	bans.Ban(address, duration, "手动封禁")
+	bans.SaveToFile(nodeID)
+
+	fmt.Printf("已封禁 %s，时长 %s\n", address, duration)
+}
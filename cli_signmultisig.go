@@ -0,0 +1,54 @@
+package blockchain7
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// signMultiSig 用本节点钱包address的私钥对文件file中的多重签名交易签名，签名后写回文件
+// 签名数足够时交易完整，send为true时加入本地交易池并发送给中心节点
+func (cli *CLI) signMultiSig(file, address string, send bool, nodeID string) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	ptx, err := DeserializePartiallySignedTx(string(data))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallet := loadWallet(address, nodeID)
+	added, err := ptx.Sign(wallet)
+	if err != nil {
+		log.Panic(err)
+	}
+	err = ioutil.WriteFile(file, []byte(ptx.Serialize()), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("添加了%d个签名\n", added)
+
+	if !ptx.Complete() {
+		fmt.Println("签名数还不够，请其他签名者继续签名")
+		return
+	}
+	fmt.Printf("交易 %x 已收集齐签名\n", ptx.Tx.ID)
+	if !send {
+		return
+	}
+
+	bc := NewBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	pool := NewMempool(bc, defaultMaxMempoolSize, defaultMinRelayFeeRate, defaultMempoolExpiry)
+	pool.LoadFromFile(nodeID)
+	err = pool.Add(&ptx.Tx)
+	if err != nil {
+		fmt.Printf("交易未能加入本地交易池: %s\n", err)
+	}
+	sendTx(knownNodes[0], &ptx.Tx) //发送给中心节点
+	pool.SaveToFile(nodeID)
+
+	fmt.Println("交易已发送")
+}
@@ -0,0 +1,61 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+const signatureScriptSize = 65 //解锁脚本中一个签名占用的字节数：1字节长度和64字节签名
+
+// spendMultiSig 创建从多重签名地址from转账的交易，保存到文件file，由签名者用signmultisig依次签名
+// from为P2SH地址时redeemScript为十六进制的多重签名赎回脚本（createmultisig -p2sh的输出）
+// fee小于0时按estimatefee估算的手续费率和收集齐签名后的交易大小支付手续费
+func (cli *CLI) spendMultiSig(from, to string, amount, fee int, redeemScript, file, nodeID string) {
+	if !ValidateAddress(from) {
+		log.Panic("ERROR: 发送地址非法")
+	}
+	if !ValidateAddress(to) {
+		log.Panic("ERROR: 接收地址非法")
+	}
+	script := NewTxOutput(0, from).ScriptPubKey
+	multisigScript := script
+	var redeem []byte
+	if scriptHash := extractP2SH(script); scriptHash != nil {
+		var err error
+		redeem, err = hex.DecodeString(redeemScript)
+		if err != nil || !bytes.Equal(HashPubKey(redeem), scriptHash) {
+			log.Panic("ERROR: 赎回脚本与P2SH地址不匹配")
+		}
+		multisigScript = redeem
+	}
+	m, _ := extractMultiSig(multisigScript)
+	if m == 0 {
+		log.Panic("ERROR: 发送地址不是多重签名地址")
+	}
+
+	bc := NewBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	pool := NewMempool(bc, defaultMaxMempoolSize, defaultMinRelayFeeRate, defaultMempoolExpiry)
+	pool.LoadFromFile(nodeID)
+
+	view := pool.View()
+	if fee < 0 {
+		feeRate := estimateFeeRate(defaultConfirmTarget, nodeID)
+		draft := NewMultiSigTransaction(script, redeem, to, amount, 0, view)
+		size := len(draft.Tx.Serialize()) + len(draft.Tx.Vin)*(m*signatureScriptSize+len(redeem))
+		fee = feeForSize(feeRate, size)
+		fmt.Printf("手续费率 %d（每千字节），手续费 %d\n", feeRate, fee)
+	}
+
+	ptx := NewMultiSigTransaction(script, redeem, to, amount, fee, view)
+	err := ioutil.WriteFile(file, []byte(ptx.Serialize()), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("交易 %x 需要%d个签名，已保存到 %s\n", ptx.Tx.ID, m, file)
+}
@@ -5,7 +5,7 @@ import (
 	"log"
 )
 
-func (cli *CLI) startNode(nodeID, minerAddress string) {
+func (cli *CLI) startNode(nodeID, minerAddress string, options ServerOptions) {
 	fmt.Printf("开始节点 %s\n", nodeID)
 	if len(minerAddress) > 0 {
 		if ValidateAddress(minerAddress) {
@@ -14,5 +14,5 @@ func (cli *CLI) startNode(nodeID, minerAddress string) {
 			log.Panic("错误的挖矿地址!")
 		}
 	}
-	StartServer(nodeID, minerAddress) //启动节点服务器：区块链中每一个节点都是服务器
+	StartServer(nodeID, minerAddress, options) //启动节点服务器：区块链中每一个节点都是服务器
 }
@@ -0,0 +1,71 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+// verifyAnchor 在区块链中查找写入了文件哈希（或十六进制数据）的数据输出，证明数据在哪个区块、什么时间之前已经存在
+// 证明的依据：交易到区块头中Merkle根的Merkle证明路径有效，区块头按共识规则有效，且区块在当前的主链上
+func (cli *CLI) verifyAnchor(file, data string, nodeID string) {
+	var target []byte
+	if file != "" {
+		target = fileHash(file)
+	} else {
+		var err error
+		target, err = hex.DecodeString(data)
+		if err != nil {
+			log.Panic("ERROR: 数据必须是十六进制数")
+		}
+	}
+
+	bc := NewBlockchain(nodeID)
+	defer bc.Db.Close()
+	bestHeight := bc.GetBestHeight()
+
+	bci := bc.Iterator()
+	for {
+		block := bci.Next()
+
+		for txIndex, tx := range block.Transactions {
+			for i, out := range tx.Vout {
+				if !out.IsUnspendable() || !bytes.Equal(extractData(out.ScriptPubKey), target) {
+					continue
+				}
+
+				header := block.Header()
+				if !consensus.VerifyHeader(&header) {
+					log.Panicf("ERROR: 区块 %x 的区块头验证失败", block.Hash)
+				}
+				var txs [][]byte
+				for _, t := range block.Transactions {
+					txs = append(txs, t.Serialize())
+				}
+				proof := MerkleProof(txs, txIndex)
+				if !VerifyMerkleProof(tx.Serialize(), txIndex, proof, header.MerkleRoot) {
+					log.Panicf("ERROR: 交易 %x 的Merkle证明验证失败", tx.ID)
+				}
+				fmt.Printf("数据 %x 已写入区块链\n", target)
+				fmt.Printf("交易: %x（输出 %d）\n", tx.ID, i)
+				fmt.Printf("区块: %x\n", block.Hash)
+				fmt.Printf("高度: %d，确认数: %d\n", block.Height, bestHeight-block.Height+1)
+				fmt.Printf("区块时间: %s\n", time.Unix(block.Timestamp, 0).Format("2006-01-02 15:04:05"))
+				fmt.Printf("Merkle根: %x\n", header.MerkleRoot)
+				fmt.Printf("Merkle证明（交易位置 %d）:\n", txIndex)
+				for _, hash := range proof {
+					fmt.Printf("  %x\n", hash)
+				}
+				return
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	fmt.Printf("区块链中没有找到数据 %x\n", target)
+}
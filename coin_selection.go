@@ -0,0 +1,141 @@
+package blockchain7
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// 选币策略的名称
+const (
+	coinSelectFirst    = "first"    //按UTXO集中的顺序，先已确认的输出，后交易池中交易的输出
+	coinSelectLargest  = "largest"  //金额从大到小，输入最少，交易最小
+	coinSelectSmallest = "smallest" //金额从小到大，合并零碎的输出，减少钱包的碎片
+	coinSelectBnB      = "bnb"      //分支定界搜索总额恰好等于需要金额的组合，不产生找零，找不到时按largest选择
+	coinSelectRandom   = "random"   //随机顺序，不暴露哪些输出属于同一个钱包
+)
+
+const maxBnBTries = 100000 //分支定界最多搜索的节点数
+
+// CoinSelector 选币策略：从候选的未花费输出中选出总额不少于amount的输出
+// 候选输出的总额不足时返回全部候选输出，由调用者报告余额不足
+type CoinSelector interface {
+	Select(candidates []UnspentOutput, amount int) []UnspentOutput
+}
+
+// NewCoinSelector 根据名称创建选币策略
+func NewCoinSelector(name string) (CoinSelector, error) {
+	switch name {
+	case coinSelectFirst, "":
+		return firstSelector{}, nil
+	case coinSelectLargest:
+		return largestFirstSelector{}, nil
+	case coinSelectSmallest:
+		return smallestFirstSelector{}, nil
+	case coinSelectBnB:
+		return bnbSelector{}, nil
+	case coinSelectRandom:
+		return randomSelector{}, nil
+	}
+
+	return nil, fmt.Errorf("未知的选币策略 %s，可选 %s、%s、%s、%s、%s", name,
+		coinSelectFirst, coinSelectLargest, coinSelectSmallest, coinSelectBnB, coinSelectRandom)
+}
+
+// takeUntil 按顺序取出输出，直至总额不少于amount
+func takeUntil(candidates []UnspentOutput, amount int) []UnspentOutput {
+	accumulated := 0
+	for i, u := range candidates {
+		if accumulated >= amount {
+			return candidates[:i]
+		}
+		accumulated += u.Output.Value
+	}
+
+	return candidates
+}
+
+// sortedByValue 返回按金额排序的副本，金额相同时保持原来的顺序（已确认的输出在前）
+func sortedByValue(candidates []UnspentOutput, descending bool) []UnspentOutput {
+	sorted := append([]UnspentOutput(nil), candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if descending {
+			return sorted[i].Output.Value > sorted[j].Output.Value
+		}
+		return sorted[i].Output.Value < sorted[j].Output.Value
+	})
+
+	return sorted
+}
+
+type firstSelector struct{}
+
+func (firstSelector) Select(candidates []UnspentOutput, amount int) []UnspentOutput {
+	return takeUntil(candidates, amount)
+}
+
+type largestFirstSelector struct{}
+
+func (largestFirstSelector) Select(candidates []UnspentOutput, amount int) []UnspentOutput {
+	return takeUntil(sortedByValue(candidates, true), amount)
+}
+
+type smallestFirstSelector struct{}
+
+func (smallestFirstSelector) Select(candidates []UnspentOutput, amount int) []UnspentOutput {
+	return takeUntil(sortedByValue(candidates, false), amount)
+}
+
+type randomSelector struct{}
+
+func (randomSelector) Select(candidates []UnspentOutput, amount int) []UnspentOutput {
+	shuffled := append([]UnspentOutput(nil), candidates...)
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	return takeUntil(shuffled, amount)
+}
+
+// bnbSelector 分支定界选币：按金额从大到小深度优先搜索，每个输出选或不选，
+// 已选总额超过amount，或者加上剩余输出的总额也达不到amount时剪枝
+type bnbSelector struct{}
+
+func (bnbSelector) Select(candidates []UnspentOutput, amount int) []UnspentOutput {
+	sorted := sortedByValue(candidates, true)
+	remaining := make([]int, len(sorted)+1) //remaining[i]为第i个及之后输出的总额
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Output.Value
+	}
+
+	var chosen []int
+	tries := 0
+	var search func(i, total int) bool
+	search = func(i, total int) bool {
+		tries++
+		if total == amount {
+			return true
+		}
+		if i == len(sorted) || total > amount || total+remaining[i] < amount || tries > maxBnBTries {
+			return false
+		}
+
+		chosen = append(chosen, i)
+		if search(i+1, total+sorted[i].Output.Value) {
+			return true
+		}
+		chosen = chosen[:len(chosen)-1]
+
+		return search(i+1, total)
+	}
+
+	if amount > 0 && search(0, 0) {
+		var selected []UnspentOutput
+		for _, i := range chosen {
+			selected = append(selected, sorted[i])
+		}
+		return selected
+	}
+
+	return takeUntil(sorted, amount) //没有恰好相等的组合，退回到largest
+}
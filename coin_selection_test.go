@@ -0,0 +1,169 @@
+package blockchain7
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unspentOutputs 按金额创建候选输出，第i个输出的交易ID为i
+func unspentOutputs(values ...int) []UnspentOutput {
+	var candidates []UnspentOutput
+	for i, value := range values {
+		candidates = append(candidates, UnspentOutput{[]byte{byte(i)}, 0, TxOutput{value, nil}})
+	}
+
+	return candidates
+}
+
+// selectedValues 选中的输出的金额，按选中的顺序
+func selectedValues(selected []UnspentOutput) []int {
+	var values []int
+	for _, u := range selected {
+		values = append(values, u.Output.Value)
+	}
+
+	return values
+}
+
+func TestCoinSelectors(t *testing.T) {
+	candidates := unspentOutputs(5, 1, 3, 8, 2)
+
+	cases := []struct {
+		selector string
+		amount   int
+		values   []int
+	}{
+		{coinSelectFirst, 6, []int{5, 1}},
+		{coinSelectFirst, 5, []int{5}},
+		{coinSelectFirst, 100, []int{5, 1, 3, 8, 2}},
+		{coinSelectFirst, 0, nil},
+		{coinSelectLargest, 6, []int{8}},
+		{coinSelectLargest, 10, []int{8, 5}},
+		{coinSelectLargest, 100, []int{8, 5, 3, 2, 1}},
+		{coinSelectSmallest, 6, []int{1, 2, 3}},
+		{coinSelectSmallest, 10, []int{1, 2, 3, 5}},
+		{coinSelectSmallest, 100, []int{1, 2, 3, 5, 8}},
+		{coinSelectBnB, 6, []int{5, 1}},  //恰好相等，没有找零
+		{coinSelectBnB, 10, []int{8, 2}}, //largest会选8和5
+		{coinSelectBnB, 19, []int{8, 5, 3, 2, 1}},
+		{coinSelectBnB, 100, []int{8, 5, 3, 2, 1}}, //余额不足时返回全部
+		{coinSelectBnB, 0, nil},
+	}
+
+	for _, c := range cases {
+		selector, err := NewCoinSelector(c.selector)
+		assert.NoError(t, err)
+		assert.Equal(t, c.values, selectedValues(selector.Select(candidates, c.amount)), "%s %d", c.selector, c.amount)
+	}
+	assert.Equal(t, []int{5, 1, 3, 8, 2}, selectedValues(candidates), "candidates are not reordered")
+}
+
+func TestBnBFallsBackToLargest(t *testing.T) {
+	//4、6、10都是偶数，凑不出7，退回到largest
+	candidates := unspentOutputs(4, 6, 10)
+	bnb, err := NewCoinSelector(coinSelectBnB)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []int{10}, selectedValues(bnb.Select(candidates, 7)))
+}
+
+func TestCoinSelectorsKeepConfirmedFirst(t *testing.T) {
+	//金额相同时保持原来的顺序，已确认的输出排在交易池中交易的输出之前
+	candidates := unspentOutputs(3, 3, 3)
+
+	for _, name := range []string{coinSelectFirst, coinSelectLargest, coinSelectSmallest, coinSelectBnB} {
+		selector, err := NewCoinSelector(name)
+		assert.NoError(t, err)
+
+		selected := selector.Select(candidates, 3)
+		assert.Len(t, selected, 1, name)
+		assert.Equal(t, []byte{0}, selected[0].TxID, name)
+	}
+}
+
+func TestRandomSelector(t *testing.T) {
+	candidates := unspentOutputs(5, 1, 3, 8, 2)
+	selector, err := NewCoinSelector(coinSelectRandom)
+	assert.NoError(t, err)
+
+	for _, amount := range []int{1, 6, 10, 19} {
+		for i := 0; i < 20; i++ {
+			selected := selector.Select(candidates, amount)
+
+			total := 0
+			seen := make(map[byte]bool)
+			for _, u := range selected {
+				assert.False(t, seen[u.TxID[0]], "duplicate output")
+				seen[u.TxID[0]] = true
+				total += u.Output.Value
+			}
+			assert.GreaterOrEqual(t, total, amount)
+			//去掉最后选中的输出后总额不足，没有多选
+			assert.Less(t, total-selected[len(selected)-1].Output.Value, amount)
+		}
+	}
+
+	assert.Len(t, selector.Select(candidates, 100), len(candidates), "insufficient funds returns every candidate")
+}
+
+func TestNewCoinSelector(t *testing.T) {
+	cases := []struct {
+		name string
+		ok   bool
+	}{
+		{"", true},
+		{coinSelectFirst, true},
+		{coinSelectLargest, true},
+		{coinSelectSmallest, true},
+		{coinSelectBnB, true},
+		{coinSelectRandom, true},
+		{"fifo", false},
+	}
+
+	for _, c := range cases {
+		selector, err := NewCoinSelector(c.name)
+		if c.ok {
+			assert.NoError(t, err, c.name)
+			assert.NotNil(t, selector, c.name)
+		} else {
+			assert.Error(t, err, c.name)
+		}
+	}
+}
+
+func TestBuildWithFeeRate(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+	address := string(wallet.GetAddress())
+
+	//把创始区块的奖励拆成10个1个币的输出，每多选一个输入，交易就更大，手续费也更高
+	split := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1)
+	assert.NoError(t, mempool.Add(split))
+
+	for _, selector := range []string{coinSelectSmallest, coinSelectBnB} {
+		view := mempool.View()
+		applyCoinSelection(view, selector, "", wallet)
+		build := func(fee int) *Transaction {
+			return NewLockedUTXOTransaction(wallet, address, 2, fee, 0, 0, view)
+		}
+
+		const feeRate = 3
+		tx, fee := buildWithFeeRate(feeRate, build)
+		inValue := 0
+		for _, vin := range tx.Vin {
+			out, ok := view.FindOutput(vin.Txid, vin.Vout)
+			assert.True(t, ok)
+			inValue += out.Value
+		}
+		outValue, err := checkOutputs(tx)
+		assert.NoError(t, err)
+		assert.Equal(t, fee, inValue-outValue, "%s: transaction pays the returned fee", selector)
+		assert.GreaterOrEqual(t, fee, feeForSize(feeRate, len(tx.Serialize())), "%s: fee covers the final size", selector)
+
+		//只按转账金额选币时输入更少，按那时的大小计算的手续费不够支付最终交易
+		draft := build(0)
+		assert.Less(t, len(draft.Vin), len(tx.Vin), selector)
+		assert.Less(t, feeForSize(feeRate, len(draft.Serialize())), fee, selector)
+	}
+}
@@ -0,0 +1,231 @@
+package blockchain7
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+const chainParamsBucket = "chainparams"
+
+// 共识算法的名称，保存在链参数中
+const (
+	consensusPoW = "pow"
+	consensusPoA = "poa"
+	consensusPoS = "pos"
+)
+
+var errNotInTurn = errors.New("还没有轮到本节点出块")
+
+// ConsensusEngine 共识引擎：决定由谁、以什么方式产生区块，以及如何验证区块头
+type ConsensusEngine interface {
+	// Name 共识算法的名称，用于显示
+	Name() string
+	// Seal 封装区块：填写区块的Nonce、Hash等共识字段，ctx被取消时放弃并返回ctx.Err()
+	Seal(ctx context.Context, block *Block, workers int) error
+	// VerifyHeader 仅根据区块头验证区块是否按共识规则产生
+	VerifyHeader(h *BlockHeader) bool
+	// Weight 区块对链的贡献：工作量证明为挖出区块平均需要的哈希次数，其他共识为1
+	// 出现分支时选择分叉点之后累计权重最大的链
+	Weight(h *BlockHeader) *big.Int
+}
+
+// blockVerifier 需要区块链状态才能完整验证区块的共识引擎，区块连接到tip之前调用
+type blockVerifier interface {
+	VerifyBlock(block *Block) error
+}
+
+// forkChooser 分支与主链在分叉点之后的累计权重相同时决定是否切换到分支的共识引擎，branch和detached都按高度从高到低
+type forkChooser interface {
+	preferBranch(branch, detached []*Block) bool
+}
+
+// authorizer 需要本节点的密钥才能出块的共识引擎
+type authorizer interface {
+	Authorize(wallet *Wallet)
+}
+
+// consensus 当前区块链使用的共识引擎，打开或创建区块链时根据链参数设置
+var consensus ConsensusEngine = &PoWEngine{}
+
+// ChainParams 链参数，创建区块链时确定，保存在区块链数据库中，随数据库复制到其他节点
+type ChainParams struct {
+	Consensus     string   //共识算法：pow、poa或pos
+	Validators    []string //poa的验证者地址，按顺序轮流出块
+	StakeMaturity int      //pos：UTXO计入权益需要的确认数
+	SlotInterval  int      //pos：出块时隙的长度（秒）
+}
+
+// DefaultChainParams 默认的链参数：工作量证明
+func DefaultChainParams() ChainParams {
+	return ChainParams{Consensus: consensusPoW}
+}
+
+// Validate 检查链参数是否有效
+func (p ChainParams) Validate() error {
+	switch p.Consensus {
+	case consensusPoW:
+		return nil
+	case consensusPoS:
+		if p.StakeMaturity < 0 || p.SlotInterval < 0 {
+			return errors.New("权益成熟确认数和时隙长度不能为负数")
+		}
+		return nil
+	case consensusPoA:
+		if len(p.Validators) == 0 {
+			return errors.New("poa至少需要一个验证者")
+		}
+		for _, address := range p.Validators {
+			if !ValidateAddress(address) {
+				return fmt.Errorf("验证者地址 %s 非法", address)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("未知的共识算法%q", p.Consensus)
+	}
+}
+
+// NewConsensusEngine 根据链参数创建共识引擎，权益证明需要读取bc的UTXO集
+func NewConsensusEngine(params ChainParams, bc *Blockchain) ConsensusEngine {
+	switch params.Consensus {
+	case consensusPoA:
+		return NewPoAEngine(params.Validators)
+	case consensusPoS:
+		return NewPoSEngine(bc, params.StakeMaturity, params.SlotInterval)
+	default:
+		return &PoWEngine{}
+	}
+}
+
+// saveChainParams 在创建区块链的事务中保存链参数
+func saveChainParams(tx *bolt.Tx, params ChainParams) {
+	var content bytes.Buffer
+	err := gob.NewEncoder(&content).Encode(params)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	b, err := tx.CreateBucketIfNotExists([]byte(chainParamsBucket))
+	if err != nil {
+		log.Panic(err)
+	}
+	err = b.Put([]byte("params"), content.Bytes())
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// loadChainParams 读取链参数，旧的数据库没有保存链参数，使用工作量证明
+func loadChainParams(db *bolt.DB) ChainParams {
+	params := DefaultChainParams()
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(chainParamsBucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte("params"))
+		if data == nil {
+			return nil
+		}
+
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(&params)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return params
+}
+
+// sealHash 签名出块（权威证明和权益证明）的区块哈希，包含高度和出块者公钥，出块者对它签名
+func sealHash(prevBlockHash, merkleRoot []byte, timestamp int64, height int, signer []byte) []byte {
+	data := bytes.Join(
+		[][]byte{
+			prevBlockHash,
+			merkleRoot,
+			IntToHex(timestamp),
+			IntToHex(int64(height)),
+			signer,
+		},
+		[]byte{},
+	)
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// verifyBlock 区块连接到tip之前，检查需要区块链状态的共识规则
+func verifyBlock(block *Block) error {
+	if v, ok := consensus.(blockVerifier); ok {
+		return v.VerifyBlock(block)
+	}
+
+	return nil
+}
+
+// chainWork 区块的累计权重
+func chainWork(blocks []*Block) *big.Int {
+	work := big.NewInt(0)
+	for _, b := range blocks {
+		header := b.Header()
+		work.Add(work, consensus.Weight(&header))
+	}
+
+	return work
+}
+
+// preferBranch 累计权重相同时是否切换到分支，共识引擎没有规定时保留先收到的链
+func preferBranch(branch, detached []*Block) bool {
+	if c, ok := consensus.(forkChooser); ok && len(branch) > 0 && len(detached) > 0 {
+		return c.preferBranch(branch, detached)
+	}
+
+	return false
+}
+
+// signHash 用私钥对哈希签名，签名为定长的r和s拼接而成
+func signHash(privKey ecdsa.PrivateKey, hash []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signature
+}
+
+// verifyHashSignature 用原生态公钥（各32字节的坐标X和Y拼接）验证哈希的签名，公钥必须是pubKeyLen个字节
+func verifyHashSignature(pubKey, hash, signature []byte) bool {
+	if len(pubKey) != pubKeyLen || len(signature) != 64 {
+		return false
+	}
+
+	var r, s, x, y big.Int
+	r.SetBytes(signature[:32])
+	s.SetBytes(signature[32:])
+	x.SetBytes(pubKey[:pubKeyLen/2])
+	y.SetBytes(pubKey[pubKeyLen/2:])
+
+	curve := elliptic.P256()
+	if !curve.IsOnCurve(&x, &y) {
+		return false
+	}
+	rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
+
+	return ecdsa.Verify(&rawPubKey, hash, &r, &s)
+}
@@ -0,0 +1,80 @@
+package blockchain7
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+)
+
+// PoAEngine 轮流出块的权威证明（Proof-of-Authority）
+// 高度为h的区块只能由第h%n个验证者产生，验证者用自己的私钥对区块头签名，不需要计算哈希，不消耗CPU
+// 创始区块随数据库一起分发，不需要签名
+type PoAEngine struct {
+	validators [][]byte //验证者的公钥哈希，顺序即出块顺序
+	wallet     *Wallet  //本节点的验证者钱包，不是验证者时为nil
+}
+
+// NewPoAEngine 创建权威证明共识引擎，validators为验证者地址
+func NewPoAEngine(validators []string) *PoAEngine {
+	engine := &PoAEngine{}
+	for _, address := range validators {
+		engine.validators = append(engine.validators, pubKeyHashFromAddress(address))
+	}
+
+	return engine
+}
+
+// Name 共识算法的名称
+func (e *PoAEngine) Name() string {
+	return "PoA"
+}
+
+// Authorize 设置本节点出块使用的验证者钱包
+func (e *PoAEngine) Authorize(wallet *Wallet) {
+	e.wallet = wallet
+}
+
+// inTurn 返回应当产生height高度区块的验证者的公钥哈希
+func (e *PoAEngine) inTurn(height int) []byte {
+	return e.validators[height%len(e.validators)]
+}
+
+// Seal 轮到本节点时，用验证者的私钥对区块签名
+func (e *PoAEngine) Seal(ctx context.Context, block *Block, workers int) error {
+	if len(block.PrevBlockHash) == 0 { //创始区块
+		block.Nonce = 0
+		block.Hash = sealHash(block.PrevBlockHash, block.HashTransactions(), block.Timestamp, block.Height, nil)
+		return nil
+	}
+	if e.wallet == nil || !bytes.Equal(HashPubKey(e.wallet.PublicKey), e.inTurn(block.Height)) {
+		return errNotInTurn
+	}
+
+	block.Nonce = 0
+	block.Signer = e.wallet.PublicKey
+	block.Hash = sealHash(block.PrevBlockHash, block.HashTransactions(), block.Timestamp, block.Height, block.Signer)
+	block.Signature = signHash(e.wallet.PrivateKey, block.Hash)
+
+	return nil
+}
+
+// VerifyHeader 验证区块头的哈希，以及签名者是否为轮到出块的验证者
+func (e *PoAEngine) VerifyHeader(h *BlockHeader) bool {
+	hash := sealHash(h.PrevBlockHash, h.MerkleRoot, h.Timestamp, h.Height, h.Signer)
+	if !bytes.Equal(hash, h.Hash) || h.Nonce != 0 {
+		return false
+	}
+	if len(h.PrevBlockHash) == 0 { //创始区块
+		return true
+	}
+	if !bytes.Equal(HashPubKey(h.Signer), e.inTurn(h.Height)) {
+		return false
+	}
+
+	return verifyHashSignature(h.Signer, h.Hash, h.Signature)
+}
+
+// Weight 每个区块的权重都为1
+func (e *PoAEngine) Weight(h *BlockHeader) *big.Int {
+	return big.NewInt(1)
+}
@@ -0,0 +1,191 @@
+package blockchain7
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+const defaultStakeMaturity = 10 //UTXO至少经过这么多个区块确认才计入权益
+const defaultSlotInterval = 10  //出块时隙的长度（秒）
+
+var errNoStake = errors.New("没有成熟的UTXO，无法选出出块者")
+
+// PoSEngine 基于UTXO的权益证明（Proof-of-Stake）
+// 时间按slotInterval秒划分为时隙，区块时间戳所在的时隙（相对父区块）与父区块哈希一起作为随机种子，
+// 按成熟UTXO的金额加权选出该时隙的出块者，出块者用钱包私钥对区块签名
+// 选中的出块者不在线时，下一个时隙会选出新的出块者，链不会停止
+// 区块时间戳不能晚于当前时间所在的时隙，出块者不能挑选未来的时隙；同一父区块之后的两个区块竞争时选择时隙较早的
+// 出块资格依赖父区块的UTXO集，只能在区块连接到tip时用VerifyBlock检查，VerifyHeader只检查哈希和签名；
+// 分支上的区块在切换分支时从分叉点开始逐个连接，每个区块都在父区块成为tip时检查
+type PoSEngine struct {
+	bc           *Blockchain
+	maturity     int     //UTXO计入权益需要的确认数
+	slotInterval int64   //时隙长度（秒）
+	wallet       *Wallet //本节点的出块钱包
+}
+
+// NewPoSEngine 创建权益证明共识引擎
+func NewPoSEngine(bc *Blockchain, maturity, slotInterval int) *PoSEngine {
+	if maturity <= 0 {
+		maturity = defaultStakeMaturity
+	}
+	if slotInterval <= 0 {
+		slotInterval = defaultSlotInterval
+	}
+
+	return &PoSEngine{bc: bc, maturity: maturity, slotInterval: int64(slotInterval)}
+}
+
+// Name 共识算法的名称
+func (e *PoSEngine) Name() string {
+	return "PoS"
+}
+
+// Authorize 设置本节点出块使用的钱包
+func (e *PoSEngine) Authorize(wallet *Wallet) {
+	e.wallet = wallet
+}
+
+// Seal 本节点被选为区块时间戳所在时隙的出块者时，用钱包私钥对区块签名
+func (e *PoSEngine) Seal(ctx context.Context, block *Block, workers int) error {
+	block.Nonce = 0
+	if len(block.PrevBlockHash) == 0 { //创始区块
+		block.Hash = sealHash(block.PrevBlockHash, block.HashTransactions(), block.Timestamp, block.Height, nil)
+		return nil
+	}
+	if e.wallet == nil {
+		return errNotInTurn
+	}
+
+	parent, err := e.bc.GetBlock(block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	if block.Timestamp <= parent.Timestamp { //时间戳必须晚于父区块
+		block.Timestamp = parent.Timestamp + 1
+	}
+	if e.futureSlot(&parent, block.Timestamp) {
+		return errNotInTurn
+	}
+	proposer, err := e.proposer(&parent, block.Timestamp)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(HashPubKey(e.wallet.PublicKey), proposer) {
+		return errNotInTurn
+	}
+
+	block.Signer = e.wallet.PublicKey
+	block.Hash = sealHash(block.PrevBlockHash, block.HashTransactions(), block.Timestamp, block.Height, block.Signer)
+	block.Signature = signHash(e.wallet.PrivateKey, block.Hash)
+
+	return nil
+}
+
+// VerifyHeader 验证区块头的哈希和出块者的签名
+func (e *PoSEngine) VerifyHeader(h *BlockHeader) bool {
+	hash := sealHash(h.PrevBlockHash, h.MerkleRoot, h.Timestamp, h.Height, h.Signer)
+	if !bytes.Equal(hash, h.Hash) || h.Nonce != 0 {
+		return false
+	}
+	if len(h.PrevBlockHash) == 0 { //创始区块
+		return true
+	}
+
+	return verifyHashSignature(h.Signer, h.Hash, h.Signature)
+}
+
+// VerifyBlock 检查出块者是否有资格产生该区块，区块的父区块必须是当前的tip，这样UTXO集是父区块之后的权益分布
+func (e *PoSEngine) VerifyBlock(block *Block) error {
+	if len(block.PrevBlockHash) == 0 {
+		return nil
+	}
+	if !bytes.Equal(block.PrevBlockHash, e.bc.Tip) {
+		return errors.New("区块的父区块不是当前的tip，无法按父区块的权益分布验证")
+	}
+
+	parent, err := e.bc.GetBlock(block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	if block.Timestamp <= parent.Timestamp {
+		return errors.New("区块的时间戳早于父区块")
+	}
+	if e.futureSlot(&parent, block.Timestamp) {
+		return fmt.Errorf("区块的时隙%d还没有到", e.slot(&parent, block.Timestamp))
+	}
+
+	proposer, err := e.proposer(&parent, block.Timestamp)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(HashPubKey(block.Signer), proposer) {
+		return fmt.Errorf("出块者不是时隙%d选出的出块者", e.slot(&parent, block.Timestamp))
+	}
+
+	return nil
+}
+
+// Weight 每个区块的权重都为1
+func (e *PoSEngine) Weight(h *BlockHeader) *big.Int {
+	return big.NewInt(1)
+}
+
+// slot 区块时间戳相对父区块所在的时隙
+func (e *PoSEngine) slot(parent *Block, timestamp int64) int64 {
+	return (timestamp - parent.Timestamp) / e.slotInterval
+}
+
+// futureSlot 时间戳是否晚于当前时间所在的时隙
+func (e *PoSEngine) futureSlot(parent *Block, timestamp int64) bool {
+	return e.slot(parent, timestamp) > e.slot(parent, time.Now().Unix())
+}
+
+// preferBranch 分叉点之后的第一个区块所在的时隙比原主链的早时切换到分支，时隙相同时保留先收到的链
+func (e *PoSEngine) preferBranch(branch, detached []*Block) bool {
+	first, current := branch[len(branch)-1], detached[len(detached)-1]
+	parent, err := e.bc.GetBlock(first.PrevBlockHash)
+	if err != nil {
+		return false
+	}
+
+	return e.slot(&parent, first.Timestamp) < e.slot(&parent, current.Timestamp)
+}
+
+// proposer 选出父区块之后、timestamp所在时隙的出块者，返回其公钥哈希
+// 以父区块哈希和时隙的哈希为随机数，在按公钥哈希排序的权益区间中查找，任何节点都能重复计算出相同的结果
+func (e *PoSEngine) proposer(parent *Block, timestamp int64) ([]byte, error) {
+	UTXOSet := UTXOSet{e.bc}
+	stakes := UTXOSet.StakeDistribution(parent.Height, e.maturity)
+
+	var keys []string
+	total := big.NewInt(0)
+	for key, value := range stakes {
+		keys = append(keys, key)
+		total.Add(total, big.NewInt(int64(value)))
+	}
+	if total.Sign() == 0 {
+		return nil, errNoStake
+	}
+	sort.Strings(keys)
+
+	seed := sha256.Sum256(append(append([]byte{}, parent.Hash...), IntToHex(e.slot(parent, timestamp))...))
+	r := new(big.Int).SetBytes(seed[:])
+	r.Mod(r, total)
+
+	acc := big.NewInt(0)
+	for _, key := range keys {
+		acc.Add(acc, big.NewInt(int64(stakes[key])))
+		if r.Cmp(acc) < 0 {
+			return []byte(key), nil
+		}
+	}
+
+	return []byte(keys[len(keys)-1]), nil
+}
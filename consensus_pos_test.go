@@ -0,0 +1,160 @@
+package blockchain7
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPoSChain 权益证明的测试区块链，创始区块的奖励属于返回的钱包，它是唯一持有权益的地址，每个时隙都由它出块
+func newTestPoSChain(t *testing.T, maturity int) (*Blockchain, *Wallet, *PoSEngine) {
+	wallet := NewWallet()
+	bc := newTestChainWithParams(t, wallet, ChainParams{Consensus: consensusPoS, StakeMaturity: maturity, SlotInterval: 10})
+
+	return bc, wallet, consensus.(*PoSEngine)
+}
+
+// sealAs 用wallet的私钥对区块签名，不检查wallet是否有资格出块
+func sealAs(block *Block, wallet *Wallet) {
+	block.Signer = wallet.PublicKey
+	block.Hash = sealHash(block.PrevBlockHash, block.HashTransactions(), block.Timestamp, block.Height, block.Signer)
+	block.Signature = signHash(wallet.PrivateKey, block.Hash)
+}
+
+func TestStakeDistribution(t *testing.T) {
+	bc, wallet, _ := newTestPoSChain(t, 2)
+	other := NewWallet()
+	genesis := bc.GetLastBlock()
+	owner := string(HashPubKey(wallet.PublicKey))
+
+	//创始区块的UTXO始终成熟，新区块的coinbase输出需要2个确认
+	b1 := newTestBlock(t, &genesis, other, "b1")
+	assert.NoError(t, connectTestBlocks(b1))
+	assert.Equal(t, map[string]int{owner: subsidy}, UTXOSet{bc}.StakeDistribution(1, 2))
+
+	b2 := newTestBlock(t, b1, wallet, "b2")
+	assert.NoError(t, connectTestBlocks(b2))
+	assert.Equal(t, map[string]int{owner: subsidy, string(HashPubKey(other.PublicKey)): subsidy}, UTXOSet{bc}.StakeDistribution(2, 2))
+	assert.Equal(t, map[string]int{owner: 2 * subsidy, string(HashPubKey(other.PublicKey)): subsidy}, UTXOSet{bc}.StakeDistribution(2, 1))
+}
+
+func TestPoSProposerIsWeightedByStake(t *testing.T) {
+	bc, wallet, engine := newTestPoSChain(t, 1)
+	other := NewWallet()
+	genesis := bc.GetLastBlock()
+	assert.NoError(t, connectTestBlocks(newTestBlock(t, &genesis, other, "stake")))
+	parent := bc.GetLastBlock()
+
+	//两个地址的权益相同，各个时隙都会选中它们，同一时隙总是选出同一个出块者
+	chosen := make(map[string]int)
+	for slot := int64(0); slot < 50; slot++ {
+		timestamp := parent.Timestamp + slot*engine.slotInterval
+		proposer, err := engine.proposer(&parent, timestamp)
+		assert.NoError(t, err)
+		again, _ := engine.proposer(&parent, timestamp+engine.slotInterval-1)
+		assert.Equal(t, proposer, again, "same slot")
+		chosen[string(proposer)]++
+	}
+	assert.Len(t, chosen, 2)
+	assert.Contains(t, chosen, string(HashPubKey(wallet.PublicKey)))
+	assert.Contains(t, chosen, string(HashPubKey(other.PublicKey)))
+}
+
+func TestPoSSeal(t *testing.T) {
+	bc, wallet, engine := newTestPoSChain(t, 1)
+	genesis := bc.GetLastBlock()
+
+	//时间戳不晚于父区块时推迟到父区块之后
+	block := &Block{Timestamp: genesis.Timestamp, Transactions: []*Transaction{NewCoinbaseTX(string(wallet.GetAddress()), "b1", 0)}, PrevBlockHash: genesis.Hash, Height: 1}
+	assert.NoError(t, engine.Seal(context.Background(), block, 1))
+	assert.Equal(t, genesis.Timestamp+1, block.Timestamp)
+	assert.Equal(t, wallet.PublicKey, block.Signer)
+	header := block.Header()
+	assert.True(t, engine.VerifyHeader(&header))
+	assert.NoError(t, engine.VerifyBlock(block))
+
+	//没有权益的钱包不会被选中
+	engine.Authorize(NewWallet())
+	assert.Equal(t, errNotInTurn, engine.Seal(context.Background(), block, 1))
+	engine.Authorize(nil)
+	assert.Equal(t, errNotInTurn, engine.Seal(context.Background(), block, 1))
+}
+
+func TestPoSVerifyBlock(t *testing.T) {
+	bc, wallet, engine := newTestPoSChain(t, 1)
+	genesis := bc.GetLastBlock()
+	newBlock := func(timestamp int64, signer *Wallet) *Block {
+		block := &Block{Timestamp: timestamp, Transactions: []*Transaction{NewCoinbaseTX(string(wallet.GetAddress()), "b1", 0)}, PrevBlockHash: genesis.Hash, Height: 1}
+		sealAs(block, signer)
+		return block
+	}
+
+	assert.NoError(t, engine.VerifyBlock(newBlock(genesis.Timestamp+1, wallet)))
+	assert.Error(t, engine.VerifyBlock(newBlock(genesis.Timestamp, wallet)), "not after parent")
+	future := newBlock(time.Now().Unix()+engine.slotInterval, wallet)
+	assert.Error(t, engine.VerifyBlock(future), "slot has not started yet")
+	assert.ErrorIs(t, connectTestBlocks(future), ErrBlockInvalidSeal)
+	assert.Equal(t, genesis.Hash, bc.Tip)
+	assert.Error(t, engine.VerifyBlock(newBlock(genesis.Timestamp+1, NewWallet())), "signer has no stake")
+
+	//签名有效的区块头也不能越过VerifyBlock连接到tip
+	forged := newBlock(genesis.Timestamp+1, NewWallet())
+	header := forged.Header()
+	assert.True(t, engine.VerifyHeader(&header))
+	assert.ErrorIs(t, connectTestBlocks(forged), ErrBlockInvalidSeal)
+	assert.Equal(t, genesis.Hash, bc.Tip)
+
+	//父区块不是tip时无法按父区块的权益分布验证
+	assert.NoError(t, connectTestBlocks(newTestBlock(t, &genesis, wallet, "tip")))
+	assert.Error(t, engine.VerifyBlock(newBlock(genesis.Timestamp+1, wallet)))
+}
+
+func TestPoSReorganizeChecksBranchBlocks(t *testing.T) {
+	bc, wallet, _ := newTestPoSChain(t, 1)
+	genesis := bc.GetLastBlock()
+	main := newTestBlock(t, &genesis, wallet, "main")
+	assert.NoError(t, connectTestBlocks(main))
+
+	//分支更长，但第二个区块由没有权益的钱包签名，切换失败后仍在原主链上
+	//权益证明封装区块时要读取父区块，分支上的区块只保存不连接
+	s1 := newTestBlock(t, &genesis, wallet, "s1")
+	assert.NoError(t, connectTestBlocks(s1))
+	s2 := newTestBlock(t, s1, wallet, "s2")
+	sealAs(s2, NewWallet())
+	assert.ErrorIs(t, connectTestBlocks(s2), ErrBlockInvalidSeal)
+	assert.Equal(t, main.Hash, bc.Tip)
+	_, err := bc.GetBlock(s2.Hash)
+	assert.Error(t, err, "invalid branch block is deleted")
+
+	//合法的分支按分叉点之后的权益分布逐个验证，切换成功
+	s2 = newTestBlock(t, s1, wallet, "s2")
+	assert.NoError(t, connectTestBlocks(s2))
+	assert.Equal(t, s2.Hash, bc.Tip)
+}
+
+func TestPoSPrefersEarlierSlot(t *testing.T) {
+	bc, wallet, engine := newTestPoSChain(t, 1)
+	genesis := bc.GetLastBlock()
+	engine.slotInterval = 1
+	newBlock := func(timestamp int64, data string) *Block {
+		block := &Block{Timestamp: timestamp, Transactions: []*Transaction{NewCoinbaseTX(string(wallet.GetAddress()), data, 0)}, PrevBlockHash: genesis.Hash, Height: 1}
+		sealAs(block, wallet)
+		return block
+	}
+	early := newBlock(genesis.Timestamp+1, "early")
+	late := newBlock(genesis.Timestamp+2, "late")
+	again := newBlock(genesis.Timestamp+1, "again")
+	for time.Now().Unix() < late.Timestamp { //等到late的时隙开始
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	//权重相同的两个区块竞争时选择时隙较早的，时隙相同时保留先收到的
+	assert.NoError(t, connectTestBlocks(late))
+	assert.Equal(t, late.Hash, bc.Tip)
+	assert.NoError(t, connectTestBlocks(early))
+	assert.Equal(t, early.Hash, bc.Tip, "earlier slot wins")
+	assert.NoError(t, connectTestBlocks(again))
+	assert.Equal(t, early.Hash, bc.Tip, "same slot keeps the current tip")
+}
@@ -0,0 +1,136 @@
+package blockchain7
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainParamsValidate(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+
+	cases := []struct {
+		name   string
+		params ChainParams
+		valid  bool
+	}{
+		{"pow", DefaultChainParams(), true},
+		{"poa", ChainParams{Consensus: consensusPoA, Validators: []string{address}}, true},
+		{"poa without validators", ChainParams{Consensus: consensusPoA}, false},
+		{"poa with invalid validator", ChainParams{Consensus: consensusPoA, Validators: []string{address, "invalid"}}, false},
+		{"pos", ChainParams{Consensus: consensusPoS, StakeMaturity: 5, SlotInterval: 3}, true},
+		{"pos with defaults", ChainParams{Consensus: consensusPoS}, true},
+		{"pos with negative maturity", ChainParams{Consensus: consensusPoS, StakeMaturity: -1}, false},
+		{"unknown", ChainParams{Consensus: "pob"}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.valid, c.params.Validate() == nil)
+		})
+	}
+}
+
+func TestChainParamsSelectEngine(t *testing.T) {
+	bc, wallet := newTestChain(t)
+
+	//重新打开数据库时按保存的链参数设置共识引擎
+	bc.Db.Close()
+	consensus = &PoWEngine{}
+	reopened := NewBlockchain("test")
+	bc.Db = reopened.Db
+	engine, ok := consensus.(*PoAEngine)
+	assert.True(t, ok)
+	assert.Equal(t, [][]byte{HashPubKey(wallet.PublicKey)}, engine.validators)
+	assert.Equal(t, "PoA", consensus.Name())
+
+	//旧的数据库没有保存链参数，使用工作量证明
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(chainParamsBucket)).Delete([]byte("params"))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultChainParams(), loadChainParams(bc.Db))
+	assert.IsType(t, &PoWEngine{}, NewConsensusEngine(loadChainParams(bc.Db), bc))
+}
+
+func TestPoASealAndVerifyHeader(t *testing.T) {
+	first, second := NewWallet(), NewWallet()
+	validators := []string{string(first.GetAddress()), string(second.GetAddress())}
+	engine := NewPoAEngine(validators)
+	tx := &Transaction{ID: []byte("tx"), Vout: []TxOutput{{Value: 10}}, Timestamp: 1}
+	newBlock := func(height int) *Block {
+		return &Block{Timestamp: 1, Transactions: []*Transaction{tx}, PrevBlockHash: []byte("prev"), Height: height}
+	}
+	seal := func(wallet *Wallet, block *Block) error {
+		engine.Authorize(wallet)
+		return engine.Seal(context.Background(), block, 1)
+	}
+
+	//验证者按高度轮流出块
+	assert.Equal(t, errNotInTurn, seal(nil, newBlock(2)), "not a validator")
+	assert.Equal(t, errNotInTurn, seal(NewWallet(), newBlock(2)))
+	assert.Equal(t, errNotInTurn, seal(first, newBlock(1)))
+	block := newBlock(2)
+	assert.NoError(t, seal(first, block))
+	assert.Equal(t, first.PublicKey, block.Signer)
+	header := block.Header()
+	assert.True(t, engine.VerifyHeader(&header))
+	odd := newBlock(3)
+	assert.NoError(t, seal(second, odd))
+	header = odd.Header()
+	assert.True(t, engine.VerifyHeader(&header))
+
+	//创始区块不需要签名
+	genesis := &Block{Timestamp: 1, Transactions: []*Transaction{tx}}
+	assert.NoError(t, seal(nil, genesis))
+	header = genesis.Header()
+	assert.True(t, engine.VerifyHeader(&header))
+
+	cases := []struct {
+		name   string
+		tamper func(h *BlockHeader)
+	}{
+		{"validator out of turn", func(h *BlockHeader) {
+			h.Height = 3
+			h.Hash = sealHash(h.PrevBlockHash, h.MerkleRoot, h.Timestamp, h.Height, h.Signer)
+			h.Signature = signHash(first.PrivateKey, h.Hash)
+		}},
+		{"signature of another key", func(h *BlockHeader) { h.Signature = signHash(second.PrivateKey, h.Hash) }},
+		{"hash does not match", func(h *BlockHeader) { h.Timestamp++ }},
+		{"nonce is not zero", func(h *BlockHeader) { h.Nonce = 1 }},
+		{"missing signer", func(h *BlockHeader) { h.Signer = nil }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			header := block.Header()
+			c.tamper(&header)
+			assert.False(t, engine.VerifyHeader(&header))
+		})
+	}
+}
+
+func TestPoARejectsHeadersFromOtherSigners(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	peer := newTestPeer(t)
+
+	//不是验证者的钱包自己组建的PoA链上的区块
+	outsider := NewWallet()
+	engine := NewPoAEngine([]string{string(outsider.GetAddress())})
+	engine.Authorize(outsider)
+	forged := &Block{Timestamp: genesis.Timestamp + 1, Transactions: []*Transaction{NewCoinbaseTX(string(outsider.GetAddress()), "forged", 0)}, PrevBlockHash: genesis.Hash, Height: 1}
+	assert.NoError(t, engine.Seal(context.Background(), forged, 1))
+	assert.Error(t, syncer.processHeaders(peer.addr, []BlockHeader{forged.Header()}))
+	assert.False(t, syncer.isSyncing())
+
+	//验证者的区块头通过验证，开始下载区块
+	valid := newTestBlock(t, &genesis, wallet, "valid")
+	assert.NoError(t, syncer.processHeaders(peer.addr, []BlockHeader{valid.Header()}))
+	assert.True(t, syncer.isSyncing())
+	var request getdata
+	peer.expect(t, "getdata", &request)
+	assert.Equal(t, valid.Hash, request.ID)
+	peer.expectNone(t)
+}
@@ -0,0 +1,65 @@
+package blockchain7
+
+import (
+	"errors"
+	"fmt"
+)
+
+const maxDataSize = 80 //数据输出最多携带的字节数
+
+// NewDataScript 创建数据输出的锁定脚本：OP_RETURN <data>
+// 执行到OP_RETURN时脚本失败，输出可以证明无法花费，不会加入UTXO集
+func NewDataScript(data []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddOp(opReturn).AddData(data)
+
+	return b.Script()
+}
+
+// NewDataOutput 创建携带data的数据输出，金额为0
+func NewDataOutput(data []byte) (*TxOutput, error) {
+	if len(data) == 0 || len(data) > maxDataSize {
+		return nil, fmt.Errorf("数据的长度必须在1到%d字节之间", maxDataSize)
+	}
+
+	return &TxOutput{0, NewDataScript(data)}, nil
+}
+
+// extractData 锁定脚本是数据输出的脚本时返回携带的数据，否则返回nil
+func extractData(script []byte) []byte {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 2 || ops[0].Opcode != opReturn || ops[1].Opcode > opPushData2 {
+		return nil
+	}
+
+	return ops[1].Data
+}
+
+// checkOutputs 检查交易的输出并返回输出总额
+// 普通输出的金额必须为正数；数据输出的金额必须为0，数据不超过maxDataSize字节，每个交易最多一个数据输出
+func checkOutputs(tx *Transaction) (int, error) {
+	outValue := 0
+	dataOutputs := 0
+	for _, out := range tx.Vout {
+		if !out.IsUnspendable() {
+			if out.Value <= 0 {
+				return 0, errors.New("输出金额必须为正数")
+			}
+			outValue += out.Value
+			continue
+		}
+
+		dataOutputs++
+		if dataOutputs > 1 {
+			return 0, errors.New("最多只能有一个数据输出")
+		}
+		if out.Value != 0 {
+			return 0, errors.New("数据输出的金额必须为0")
+		}
+		if data := extractData(out.ScriptPubKey); data == nil || len(data) > maxDataSize {
+			return 0, fmt.Errorf("数据输出的格式不正确或超过%d字节", maxDataSize)
+		}
+	}
+
+	return outValue, nil
+}
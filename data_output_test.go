@@ -0,0 +1,119 @@
+package blockchain7
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDataOutput(t *testing.T) {
+	cases := []struct {
+		name string
+		size int
+		ok   bool
+	}{
+		{"empty", 0, false},
+		{"one byte", 1, true},
+		{"hash", sha256.Size, true},
+		{"pushdata1", opPushData1 + 1, true},
+		{"max size", maxDataSize, true},
+		{"too large", maxDataSize + 1, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte{0xda}, c.size)
+			out, err := NewDataOutput(data)
+			if !c.ok {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, 0, out.Value)
+			assert.True(t, out.IsUnspendable())
+			assert.Equal(t, data, extractData(out.ScriptPubKey))
+			assert.ErrorIs(t, verifyScript(nil, out.ScriptPubKey, newSpendingTx(sequenceFinal, 0), 0), ErrScriptFailed)
+		})
+	}
+}
+
+func TestCheckOutputs(t *testing.T) {
+	data, err := NewDataOutput([]byte("anchor"))
+	assert.NoError(t, err)
+	pay := TxOutput{5, NewP2PKHScript(make([]byte, 20))}
+	valued := TxOutput{1, data.ScriptPubKey}
+	malformed := TxOutput{0, []byte{opReturn, opDup}}
+
+	cases := []struct {
+		name    string
+		outputs []TxOutput
+		value   int
+		ok      bool
+	}{
+		{"payment", []TxOutput{pay}, 5, true},
+		{"payment and data", []TxOutput{pay, *data, pay}, 10, true},
+		{"data only", []TxOutput{*data}, 0, true},
+		{"two data outputs", []TxOutput{*data, *data}, 0, false},
+		{"data with value", []TxOutput{pay, valued}, 0, false},
+		{"malformed data", []TxOutput{malformed}, 0, false},
+		{"zero payment", []TxOutput{{0, pay.ScriptPubKey}}, 0, false},
+		{"negative payment", []TxOutput{pay, {-1, pay.ScriptPubKey}}, 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			value, err := checkOutputs(&Transaction{Vout: c.outputs})
+			if !c.ok {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.value, value)
+		})
+	}
+}
+
+// 与verifyanchor相同的方式证明数据：在区块中找到数据输出，用Merkle证明路径把交易连到区块头的Merkle根
+func TestAnchorRoundTrip(t *testing.T) {
+	wallet := NewWallet()
+	hash := sha256.Sum256([]byte("document"))
+	data, err := NewDataOutput(hash[:])
+	assert.NoError(t, err)
+
+	prev := Transaction{[]byte("prev"), nil, []TxOutput{{10, NewP2PKHScript(HashPubKey(wallet.PublicKey))}}, 0, 0}
+	anchor := &Transaction{nil, []TxInput{{prev.ID, 0, nil, maxReplaceableSequence}}, []TxOutput{*data, {9, prev.Vout[0].ScriptPubKey}}, 1600000000, 0}
+	anchor.ID = anchor.Hash()
+	anchor.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(prev.ID): prev})
+	assert.Equal(t, anchor.ID, anchor.Hash(), "signatures are not part of the ID")
+
+	for _, n := range []int{1, 2, 3, 4, 5, 7} { //区块中交易的数量，数据交易放在最后
+		var txs []*Transaction
+		for i := 0; i < n-1; i++ {
+			txs = append(txs, NewCoinbaseTX(string(wallet.GetAddress()), string(rune('a'+i)), 0))
+		}
+		txs = append(txs, anchor)
+		block := &Block{Timestamp: 1600000000, Transactions: txs, Height: 1}
+		header := block.Header()
+
+		found := false
+		for txIndex, tx := range block.Transactions {
+			if tx.Vout[0].IsUnspendable() && bytes.Equal(extractData(tx.Vout[0].ScriptPubKey), hash[:]) {
+				var serialized [][]byte
+				for _, btx := range block.Transactions {
+					serialized = append(serialized, btx.Serialize())
+				}
+				proof := MerkleProof(serialized, txIndex)
+				assert.True(t, VerifyMerkleProof(tx.Serialize(), txIndex, proof, header.MerkleRoot), "%d transactions", n)
+
+				tampered := *tx
+				tampered.Vout = []TxOutput{tx.Vout[1]}
+				assert.False(t, VerifyMerkleProof(tampered.Serialize(), txIndex, proof, header.MerkleRoot), "%d transactions: tampered", n)
+				found = true
+			}
+		}
+		assert.True(t, found, "%d transactions", n)
+	}
+}
@@ -0,0 +1,257 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const feeEstimatesFile = "fee_estimates_%s.dat"
+
+const maxConfirmTarget = 25       //最多估算多少个区块内确认的手续费率
+const defaultConfirmTarget = 6    //send命令未指定手续费时，按多少个区块内确认估算
+const feeEstimateDecay = 0.998    //每个新区块对历史数据的衰减系数，越早的区块权重越低
+const feeEstimateSuccess = 0.85   //一个费率区间内在目标区块数内确认的交易比例达到该值，才认为该费率足够
+const minFeeEstimateSamples = 2.0 //一组费率区间内至少要有这么多（衰减后的）交易，估算才有意义
+const maxFeeBucket = 1 << 20      //最高的费率区间下限
+
+// feeBuckets 费率区间的下限：1, 2, 4, 8, ...，按指数划分，高费率的交易很少，不需要细分
+var feeBuckets = func() []int {
+	var buckets []int
+	for rate := 1; rate <= maxFeeBucket; rate *= 2 {
+		buckets = append(buckets, rate)
+	}
+	return buckets
+}()
+
+// bucketIndex 返回费率所在的区间
+func bucketIndex(feeRate int) int {
+	i := 0
+	for i+1 < len(feeBuckets) && feeBuckets[i+1] <= feeRate {
+		i++
+	}
+
+	return i
+}
+
+// trackedTx 交易池中正在跟踪的交易：进入交易池时的区块高度和费率区间
+type trackedTx struct {
+	Height int
+	Bucket int
+}
+
+// FeeEstimator 手续费估算器
+// 记录交易池中的交易从进入交易池到被区块确认经过了多少个区块，按手续费率分区间统计，
+// 从而估算“在N个区块内确认需要多高的手续费率”
+// 在交易池中等待超过maxConfirmTarget个区块仍未确认的交易，记为该费率区间的失败样本
+type FeeEstimator struct {
+	mtx     sync.Mutex
+	height  int //已知的最新区块高度
+	stats   feeStats
+	changed bool //上次保存之后是否有新数据
+}
+
+// feeStats 保存到文件中的统计数据
+type feeStats struct {
+	Confirmed [][]float64          //[费率区间][n-1]：在n个区块内确认的交易数（衰减后）
+	Total     []float64            //[费率区间]：已确认或已判定为失败的交易数（衰减后）
+	Tracked   map[string]trackedTx //交易ID->正在跟踪的交易
+}
+
+// NewFeeEstimator 创建没有任何数据的手续费估算器，height为当前的区块高度
+func NewFeeEstimator(height int) *FeeEstimator {
+	stats := feeStats{
+		Confirmed: make([][]float64, len(feeBuckets)),
+		Total:     make([]float64, len(feeBuckets)),
+		Tracked:   make(map[string]trackedTx),
+	}
+	for i := range stats.Confirmed {
+		stats.Confirmed[i] = make([]float64, maxConfirmTarget)
+	}
+
+	return &FeeEstimator{height: height, stats: stats}
+}
+
+// processTx 开始跟踪一个进入交易池的交易，从文件读取的交易池交易保留原来的高度
+func (fe *FeeEstimator) processTx(txID string, feeRate int) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	if _, ok := fe.stats.Tracked[txID]; ok {
+		return
+	}
+	fe.stats.Tracked[txID] = trackedTx{fe.height, bucketIndex(feeRate)}
+	fe.changed = true
+}
+
+// removeTx 交易因替换、淘汰或过期离开交易池，不再跟踪，也不计入统计
+func (fe *FeeEstimator) removeTx(txID string) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	delete(fe.stats.Tracked, txID)
+}
+
+// processBlock 新区块连接后，记录区块中被跟踪的交易经过了多少个区块才确认
+func (fe *FeeEstimator) processBlock(block *Block) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	if block.Height <= fe.height { //分叉切换或旧区块，不影响统计
+		return
+	}
+	fe.height = block.Height
+
+	for b := range fe.stats.Total {
+		fe.stats.Total[b] *= feeEstimateDecay
+		for n := range fe.stats.Confirmed[b] {
+			fe.stats.Confirmed[b][n] *= feeEstimateDecay
+		}
+	}
+
+	for _, tx := range block.Transactions {
+		txID := fmt.Sprintf("%x", tx.ID)
+		t, ok := fe.stats.Tracked[txID]
+		if !ok {
+			continue
+		}
+		delete(fe.stats.Tracked, txID)
+
+		blocks := block.Height - t.Height
+		if blocks < 1 {
+			blocks = 1
+		}
+		fe.stats.Total[t.Bucket]++
+		for n := blocks; n <= maxConfirmTarget; n++ {
+			fe.stats.Confirmed[t.Bucket][n-1]++
+		}
+	}
+
+	//等待太久的交易记为失败样本，不再跟踪
+	for txID, t := range fe.stats.Tracked {
+		if block.Height-t.Height > maxConfirmTarget {
+			fe.stats.Total[t.Bucket]++
+			delete(fe.stats.Tracked, txID)
+		}
+	}
+	fe.changed = true
+}
+
+// Estimate 估算在blocks个区块内确认需要的手续费率（每千字节的手续费）
+// 从最高的费率区间开始向下合并区间，直到样本足够，如果这组区间的确认比例达到feeEstimateSuccess，
+// 就继续尝试更低的费率，否则停止，返回最后一组达标区间中最低的费率
+// 数据不足时返回false
+func (fe *FeeEstimator) Estimate(blocks int) (int, bool) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	if blocks < 1 {
+		blocks = 1
+	}
+	if blocks > maxConfirmTarget {
+		blocks = maxConfirmTarget
+	}
+
+	best := -1
+	confirmed, total := 0.0, 0.0
+	for b := len(feeBuckets) - 1; b >= 0; b-- {
+		confirmed += fe.stats.Confirmed[b][blocks-1]
+		total += fe.stats.Total[b]
+		if total < minFeeEstimateSamples {
+			continue
+		}
+		if confirmed/total < feeEstimateSuccess {
+			break
+		}
+		best = b
+		confirmed, total = 0, 0
+	}
+
+	if best < 0 {
+		return 0, false
+	}
+
+	return feeBuckets[best], true
+}
+
+// SaveToFile 保存统计数据到文件
+func (fe *FeeEstimator) SaveToFile(nodeID string) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	var content bytes.Buffer
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(fe.stats)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf(feeEstimatesFile, nodeID), content.Bytes(), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+	fe.changed = false
+}
+
+// LoadFromFile 从文件读取统计数据，区间划分不同的旧文件直接忽略
+func (fe *FeeEstimator) LoadFromFile(nodeID string) error {
+	feeEstimatesFile := fmt.Sprintf(feeEstimatesFile, nodeID)
+	if _, err := os.Stat(feeEstimatesFile); os.IsNotExist(err) {
+		return err
+	}
+
+	fileContent, err := ioutil.ReadFile(feeEstimatesFile)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var stats feeStats
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	err = decoder.Decode(&stats)
+	if err != nil {
+		return err
+	}
+	if len(stats.Total) != len(feeBuckets) || len(stats.Confirmed) != len(feeBuckets) {
+		return fmt.Errorf("手续费估算文件的费率区间不匹配")
+	}
+	for _, confirmed := range stats.Confirmed {
+		if len(confirmed) != maxConfirmTarget {
+			return fmt.Errorf("手续费估算文件的确认区块数不匹配")
+		}
+	}
+	if stats.Tracked == nil {
+		stats.Tracked = make(map[string]trackedTx)
+	}
+
+	fe.mtx.Lock()
+	fe.stats = stats
+	fe.mtx.Unlock()
+
+	return nil
+}
+
+// run 定时保存统计数据，这样节点运行时estimatefee命令也能读到较新的数据
+func (fe *FeeEstimator) run(nodeID string) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fe.mtx.Lock()
+		changed := fe.changed
+		fe.mtx.Unlock()
+
+		if changed {
+			fe.SaveToFile(nodeID)
+		}
+	}
+}
+
+// feeForSize 按手续费率（每千字节）计算size字节的交易需要的手续费，向上取整
+func feeForSize(feeRate, size int) int {
+	return (feeRate*size + 999) / 1000
+}
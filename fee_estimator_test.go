@@ -0,0 +1,115 @@
+package blockchain7
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// feeSample 一个交易：手续费率，以及进入交易池后经过几个区块确认，0表示一直没有确认
+type feeSample struct {
+	feeRate int
+	blocks  int
+}
+
+// repeatSample 重复n次的样本
+func repeatSample(s feeSample, n int) []feeSample {
+	var samples []feeSample
+	for i := 0; i < n; i++ {
+		samples = append(samples, s)
+	}
+
+	return samples
+}
+
+// feedSamples 依次让每个样本进入交易池，再连接区块直到它被确认，或者等待超过maxConfirmTarget个区块
+func feedSamples(fe *FeeEstimator, samples []feeSample) {
+	for i, s := range samples {
+		txID := []byte{byte(i >> 8), byte(i)}
+		fe.processTx(hex.EncodeToString(txID), s.feeRate)
+
+		n := s.blocks
+		if n == 0 {
+			n = maxConfirmTarget + 1
+		}
+		for k := 1; k <= n; k++ {
+			block := &Block{Height: fe.height + 1}
+			if k == s.blocks {
+				block.Transactions = []*Transaction{{ID: txID}}
+			}
+			fe.processBlock(block)
+		}
+	}
+}
+
+func TestFeeEstimate(t *testing.T) {
+	fast := repeatSample(feeSample{100, 1}, 3) //费率区间64
+	slow := repeatSample(feeSample{10, 5}, 3)  //费率区间8
+	never := repeatSample(feeSample{10, 0}, 3)
+
+	cases := []struct {
+		name    string
+		samples []feeSample
+		blocks  int
+		feeRate int
+		ok      bool
+	}{
+		{"no data", nil, 1, 0, false},
+		{"no data long target", nil, maxConfirmTarget, 0, false},
+		{"single sample", []feeSample{{100, 1}}, 1, 0, false},
+		{"confirmed in next block", fast, 1, 64, true},
+		{"target is clamped", fast, maxConfirmTarget + 10, 64, true},
+		{"slow bucket misses short target", append(fast, slow...), 1, 64, true},
+		{"slow bucket meets long target", append(fast, slow...), 6, 8, true},
+		{"unconfirmed bucket never qualifies", append(fast, never...), maxConfirmTarget, 64, true},
+		{"only unconfirmed samples", never, maxConfirmTarget, 0, false},
+		//费率1000的区间只有一个样本，与费率100的区间合并后样本才足够，返回合并后的较低费率
+		{"sparse buckets are merged", append([]feeSample{{1000, 1}}, repeatSample(feeSample{100, 1}, 2)...), 1, 64, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fe := NewFeeEstimator(0)
+			feedSamples(fe, c.samples)
+
+			feeRate, ok := fe.Estimate(c.blocks)
+			assert.Equal(t, c.ok, ok)
+			assert.Equal(t, c.feeRate, feeRate)
+		})
+	}
+}
+
+func TestFeeEstimateIgnoresRemovedTx(t *testing.T) {
+	fe := NewFeeEstimator(0)
+	for i := 0; i < 3; i++ {
+		fe.processTx(hex.EncodeToString([]byte{byte(i)}), 100)
+	}
+
+	//被替换或淘汰的交易离开交易池，之后即使同样的ID出现在区块中也不计入统计
+	fe.removeTx(hex.EncodeToString([]byte{0}))
+	fe.processBlock(&Block{Height: 1, Transactions: []*Transaction{{ID: []byte{0}}, {ID: []byte{1}}}})
+
+	_, ok := fe.Estimate(1)
+	assert.False(t, ok)
+}
+
+func TestBucketIndex(t *testing.T) {
+	cases := []struct {
+		feeRate int
+		bucket  int
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 1},
+		{3, 1},
+		{64, 6},
+		{100, 6},
+		{maxFeeBucket, len(feeBuckets) - 1},
+		{maxFeeBucket * 4, len(feeBuckets) - 1},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.bucket, bucketIndex(c.feeRate), "fee rate %d", c.feeRate)
+	}
+}
@@ -0,0 +1,176 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// 各个测试文件共用的测试夹具
+
+// newTestChain 在临时目录中创建只有创始区块的区块链，创始区块的奖励属于返回的钱包
+// 使用不需要计算哈希的PoA共识，钱包是唯一的验证者，可以用newTestBlock出块
+// 全局的交易池和同步管理器指向这条区块链，测试结束时关闭数据库、恢复全局变量并回到原来的目录
+func newTestChain(t *testing.T) (*Blockchain, *Wallet) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+
+	return newTestChainWithParams(t, wallet, ChainParams{Consensus: consensusPoA, Validators: []string{address}}), wallet
+}
+
+// newTestChainWithParams 与newTestChain相同，但使用给定的链参数，需要密钥出块的共识引擎使用wallet出块
+func newTestChainWithParams(t *testing.T, wallet *Wallet, params ChainParams) *Blockchain {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	bc := CreatBlockchain(string(wallet.GetAddress()), "test", params)
+	UTXOSet{bc}.Reindex()
+	if a, ok := consensus.(authorizer); ok {
+		a.Authorize(wallet)
+	}
+
+	oldMempool, oldSyncer := mempool, syncer
+	mempool = NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+	syncer = newSyncManager(bc)
+
+	t.Cleanup(func() {
+		mempool, syncer = oldMempool, oldSyncer
+		consensus = &PoWEngine{}
+		bc.Db.Close()
+		os.Chdir(wd)
+	})
+
+	return bc
+}
+
+// newTestTx 创建花费inputs、向wallet支付values中各个金额的交易并签名，pending为交易池中的父交易
+func newTestTx(bc *Blockchain, wallet *Wallet, pending map[string]*Transaction, inputs []TxInput, values ...int) *Transaction {
+	var outputs []TxOutput
+	for _, value := range values {
+		outputs = append(outputs, *NewTxOutput(value, string(wallet.GetAddress())))
+	}
+
+	tx := &Transaction{nil, inputs, outputs, time.Now().Unix(), 0}
+	tx.ID = tx.Hash()
+	bc.signTransaction(tx, wallet.PrivateKey, pending)
+
+	return tx
+}
+
+// txSize 交易序列化后的字节数，即交易池记录的大小
+func txSize(tx *Transaction) int {
+	return len(tx.Serialize())
+}
+
+// newTestBlock 以prev为父区块，用newTestChain的共识引擎创建包含txs的区块，区块不连接到区块链
+// coinbase交易的数据为tag，奖励给wallet，同一高度不同tag的区块互不相同，可以用来构造分支
+func newTestBlock(t *testing.T, prev *Block, wallet *Wallet, tag string, txs ...*Transaction) *Block {
+	coinbase := NewCoinbaseTX(string(wallet.GetAddress()), tag, 0)
+	block := NewBlock(append(txs, coinbase), prev.Hash, prev.Height+1)
+	if len(block.Hash) == 0 {
+		t.Fatal("区块没有封装")
+	}
+
+	return block
+}
+
+// connectTestBlocks 按顺序把区块交给同步管理器连接，与连接本节点挖出的区块相同，但区块可以在分支上
+func connectTestBlocks(blocks ...*Block) error {
+	for _, b := range blocks {
+		syncer.mtx.Lock()
+		err := syncer.connectBlock(b)
+		syncer.mtx.Unlock()
+		syncer.announce()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// utxoContents 返回UTXO集的全部内容：交易ID->序列化的未花费输出
+func utxoContents(t *testing.T, bc *Blockchain) map[string][]byte {
+	contents := make(map[string][]byte)
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+			contents[string(k)] = append([]byte(nil), v...)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return contents
+}
+
+// testPeer 在本地监听的假节点，记录本节点发给它的消息
+type testPeer struct {
+	addr     string
+	messages chan []byte
+}
+
+// newTestPeer 启动假节点，测试结束时关闭
+func newTestPeer(t *testing.T) *testPeer {
+	ln, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	p := &testPeer{ln.Addr().String(), make(chan []byte, 100)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			request, _ := ioutil.ReadAll(conn)
+			conn.Close()
+			p.messages <- request
+		}
+	}()
+
+	return p
+}
+
+// expect 等待下一条消息，检查命令并把payload解码到payload中
+func (p *testPeer) expect(t *testing.T, command string, payload interface{}) {
+	t.Helper()
+
+	select {
+	case request := <-p.messages:
+		if got := bytesToCommand(request[:commandLength]); got != command {
+			t.Fatalf("收到%s命令，应为%s", got, command)
+		}
+		err := gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("没有收到%s命令", command)
+	}
+}
+
+// expectNone 检查没有收到更多的消息
+func (p *testPeer) expectNone(t *testing.T) {
+	t.Helper()
+
+	select {
+	case request := <-p.messages:
+		t.Fatalf("收到多余的%s命令", bytesToCommand(request[:commandLength]))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,131 @@
+package blockchain7
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"time"
+)
+
+const htlcSecretSize = 32 //HTLC原像的长度
+
+// HTLC 哈希时间锁定合约（Hashed Time-Locked Contract）
+// 收款人提供哈希为SecretHash的原像和自己的签名即可取走输出；到达LockTime之后，付款人可以用自己的签名取回输出
+// 两条链上用同一个SecretHash创建HTLC，可以实现跨链原子交换：一方在一条链上取款时公开了原像，另一方用它在另一条链上取款
+type HTLC struct {
+	SecretHash []byte //原像的SHA-256哈希
+	Recipient  []byte //收款人的公钥哈希
+	Sender     []byte //付款人的公钥哈希
+	LockTime   int64  //付款人可以取回的区块高度或Unix时间戳
+}
+
+// NewHTLCSecret 产生一个随机的原像，返回原像和它的哈希
+func NewHTLCSecret() ([]byte, []byte) {
+	secret := make([]byte, htlcSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		log.Panic(err)
+	}
+	hash := sha256.Sum256(secret)
+
+	return secret, hash[:]
+}
+
+// Script 生成HTLC锁定脚本：
+// OP_IF OP_SHA256 <SecretHash> OP_EQUALVERIFY OP_DUP OP_HASH160 <Recipient>
+// OP_ELSE <LockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <Sender>
+// OP_ENDIF OP_EQUALVERIFY OP_CHECKSIG
+func (h *HTLC) Script() []byte {
+	b := &ScriptBuilder{}
+	b.AddOp(opIf)
+	b.AddOp(opSha256).AddData(h.SecretHash).AddOp(opEqualVerify).AddOp(opDup).AddOp(opHash160).AddData(h.Recipient)
+	b.AddOp(opElse)
+	b.AddInt(h.LockTime).AddOp(opCheckLockTimeVerify).AddOp(opDrop).AddOp(opDup).AddOp(opHash160).AddData(h.Sender)
+	b.AddOp(opEndIf)
+	b.AddOp(opEqualVerify).AddOp(opCheckSig)
+
+	return b.Script()
+}
+
+// extractHTLC 锁定脚本是HTLC脚本时返回合约的内容，否则返回nil
+func extractHTLC(script []byte) *HTLC {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 17 {
+		return nil
+	}
+
+	lockTime, err := parseScriptNum(ops[8].Data, 5)
+	if ops[8].Opcode >= op1 && ops[8].Opcode <= op16 {
+		lockTime, err = int64(smallInt(ops[8].Opcode)), nil
+	}
+	if err != nil {
+		return nil
+	}
+
+	h := &HTLC{ops[2].Data, ops[6].Data, ops[13].Data, lockTime}
+	if len(h.SecretHash) != sha256.Size || len(h.Recipient) != 20 || len(h.Sender) != 20 || !bytes.Equal(h.Script(), script) {
+		return nil
+	}
+
+	return h
+}
+
+// NewHTLCSpendTransaction 创建花费HTLC输出out（交易htlcTxID的第vout个输出）的交易，扣除手续费后全部支付给wallet
+// secret不为nil时由收款人用原像取款，为nil时由付款人在到达锁定时间之后取回
+func NewHTLCSpendTransaction(htlcTxID []byte, vout int, out TxOutput, wallet *Wallet, secret []byte, fee int) (*Transaction, error) {
+	h := extractHTLC(out.ScriptPubKey)
+	if h == nil {
+		return nil, errors.New("输出不是HTLC输出")
+	}
+	if out.Value <= fee {
+		return nil, errors.New("HTLC输出的金额不足以支付手续费")
+	}
+
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+	input := TxInput{htlcTxID, vout, nil, maxReplaceableSequence}
+	var lockTime int64
+	if secret != nil {
+		if !bytes.Equal(pubKeyHash, h.Recipient) {
+			return nil, errors.New("钱包不是HTLC的收款人")
+		}
+		if hash := sha256.Sum256(secret); !bytes.Equal(hash[:], h.SecretHash) {
+			return nil, errors.New("原像的哈希与HTLC不一致")
+		}
+	} else {
+		if !bytes.Equal(pubKeyHash, h.Sender) {
+			return nil, errors.New("钱包不是HTLC的付款人")
+		}
+		lockTime = h.LockTime //OP_CHECKLOCKTIMEVERIFY要求交易的LockTime不早于合约的锁定时间，输入的序号不能是sequenceFinal
+	}
+
+	payment := NewTxOutput(out.Value-fee, string(wallet.GetAddress()))
+	tx := Transaction{nil, []TxInput{input}, []TxOutput{*payment}, time.Now().Unix(), lockTime}
+	tx.ID = tx.Hash()
+
+	signature := signHash(wallet.PrivateKey, tx.signatureHash(0, out.ScriptPubKey))
+	b := &ScriptBuilder{}
+	b.AddData(signature).AddData(wallet.PublicKey)
+	if secret != nil {
+		b.AddData(secret).AddInt(1) //OP_IF分支
+	} else {
+		b.AddInt(0) //OP_ELSE分支
+	}
+	tx.Vin[0].ScriptSig = b.Script()
+
+	return &tx, nil
+}
+
+// extractHTLCSecret 如果输入是用原像花费HTLC输出的，返回原像
+func extractHTLCSecret(in TxInput, h *HTLC) []byte {
+	ops, err := parseScript(in.ScriptSig)
+	if err != nil || len(ops) != 4 || ops[3].Opcode != op1 {
+		return nil
+	}
+	if hash := sha256.Sum256(ops[2].Data); !bytes.Equal(hash[:], h.SecretHash) {
+		return nil
+	}
+
+	return ops[2].Data
+}
@@ -0,0 +1,119 @@
+package blockchain7
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTLCScript(t *testing.T) {
+	_, secretHash := NewHTLCSecret()
+	recipient := bytes.Repeat([]byte{1}, 20)
+	sender := bytes.Repeat([]byte{2}, 20)
+
+	for _, lockTime := range []int64{1, 16, 17, 100, 70000, lockTimeThreshold + 1} {
+		h := &HTLC{secretHash, recipient, sender, lockTime}
+		assert.Equal(t, h, extractHTLC(h.Script()), "lock time %d", lockTime)
+	}
+
+	assert.Nil(t, extractHTLC(NewP2PKHScript(recipient)))
+	assert.Nil(t, extractHTLC((&HTLC{secretHash[:31], recipient, sender, 100}).Script()), "short secret hash")
+}
+
+// htlcScriptSig 按NewHTLCSpendTransaction的方式为tx重新签名，secret为nil时走付款人取回的分支
+func htlcScriptSig(tx *Transaction, script []byte, wallet *Wallet, secret []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddData(signHash(wallet.PrivateKey, tx.signatureHash(0, script))).AddData(wallet.PublicKey)
+	if secret != nil {
+		b.AddData(secret).AddInt(1)
+	} else {
+		b.AddInt(0)
+	}
+
+	return b.Script()
+}
+
+func TestHTLCSpend(t *testing.T) {
+	recipient := NewWallet()
+	sender := NewWallet()
+	secret, secretHash := NewHTLCSecret()
+	wrongSecret, _ := NewHTLCSecret()
+
+	const lockTime = 100
+	h := &HTLC{secretHash, HashPubKey(recipient.PublicKey), HashPubKey(sender.PublicKey), lockTime}
+	out := TxOutput{10, h.Script()}
+	htlcTxID := []byte("htlc")
+
+	cases := []struct {
+		name   string
+		wallet *Wallet
+		secret []byte
+		ok     bool
+	}{
+		{"recipient redeems with secret", recipient, secret, true},
+		{"sender refunds after lock time", sender, nil, true},
+		{"recipient with wrong secret", recipient, wrongSecret, false},
+		{"sender with secret", sender, secret, false},
+		{"recipient refund", recipient, nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tx, err := NewHTLCSpendTransaction(htlcTxID, 0, out, c.wallet, c.secret, 1)
+			if !c.ok {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NoError(t, verifyScript(tx.Vin[0].ScriptSig, out.ScriptPubKey, tx, 0))
+			assert.Equal(t, c.secret, extractHTLCSecret(tx.Vin[0], h))
+			assert.Equal(t, 9, tx.Vout[0].Value)
+		})
+	}
+}
+
+func TestHTLCSpendScript(t *testing.T) {
+	recipient := NewWallet()
+	sender := NewWallet()
+	secret, secretHash := NewHTLCSecret()
+	wrongSecret, _ := NewHTLCSecret()
+
+	const lockTime = 100
+	h := &HTLC{secretHash, HashPubKey(recipient.PublicKey), HashPubKey(sender.PublicKey), lockTime}
+	script := h.Script()
+
+	//绕过NewHTLCSpendTransaction的检查，直接构造解锁脚本，由脚本本身拒绝
+	cases := []struct {
+		name     string
+		wallet   *Wallet
+		secret   []byte
+		lockTime int64
+		sequence uint32
+		ok       bool
+	}{
+		{"redeem", recipient, secret, 0, maxReplaceableSequence, true},
+		{"redeem with wrong secret", recipient, wrongSecret, 0, maxReplaceableSequence, false},
+		{"redeem by sender", sender, secret, 0, maxReplaceableSequence, false},
+		{"refund", sender, nil, lockTime, maxReplaceableSequence, true},
+		{"refund after lock time", sender, nil, lockTime + 50, maxReplaceableSequence, true},
+		{"refund before lock time", sender, nil, lockTime - 1, maxReplaceableSequence, false},
+		{"refund with timestamp lock time", sender, nil, lockTimeThreshold + 1, maxReplaceableSequence, false},
+		{"refund with final sequence", sender, nil, lockTime, sequenceFinal, false},
+		{"refund by recipient", recipient, nil, lockTime, maxReplaceableSequence, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tx := newSpendingTx(c.sequence, c.lockTime)
+			tx.Vin[0].ScriptSig = htlcScriptSig(tx, script, c.wallet, c.secret)
+
+			err := verifyScript(tx.Vin[0].ScriptSig, script, tx, 0)
+			if c.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrScriptFailed)
+			}
+		})
+	}
+}
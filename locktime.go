@@ -0,0 +1,86 @@
+package blockchain7
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrTxNonFinal 交易的锁定时间或相对锁定还没有到，暂时不能被打包
+var ErrTxNonFinal = errors.New("交易尚未到达锁定时间")
+
+// IsFinal 交易能否被打包进高度为height、时间戳为blockTime的区块
+// LockTime为0或者所有输入的序号都是sequenceFinal时交易不锁定；否则LockTime小于lockTimeThreshold时为区块高度，
+// 区块高度必须大于它，不小于lockTimeThreshold时为Unix时间戳，区块时间戳必须晚于它
+func (tx *Transaction) IsFinal(height int, blockTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	limit := blockTime
+	if tx.LockTime < lockTimeThreshold {
+		limit = int64(height)
+	}
+	if tx.LockTime < limit {
+		return true
+	}
+
+	for _, vin := range tx.Vin {
+		if vin.Sequence != sequenceFinal {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkLocks 检查交易能否被打包进高度为height、时间戳为blockTime的区块：LockTime和每个输入的相对锁定
+// heightOf返回输入引用的交易所在区块的高度，交易尚未上链时返回false
+// 输入的相对锁定为n时，引用的输出所在区块之后至少还要有n个区块，即区块高度不小于输出所在区块的高度加n
+func checkLocks(tx *Transaction, height int, blockTime int64, heightOf func(txID []byte) (int, bool)) error {
+	if !tx.IsFinal(height, blockTime) {
+		return fmt.Errorf("%w: LockTime为%d", ErrTxNonFinal, tx.LockTime)
+	}
+
+	for _, vin := range tx.Vin {
+		n := vin.RelativeLock()
+		if n == 0 {
+			continue
+		}
+		confirmed, ok := heightOf(vin.Txid)
+		if !ok {
+			return fmt.Errorf("%w: 输入 %s 相对锁定%d个区块，引用的交易尚未上链", ErrTxNonFinal, outpoint(vin.Txid, vin.Vout), n)
+		}
+		if height < confirmed+n {
+			return fmt.Errorf("%w: 输入 %s 相对锁定到高度%d", ErrTxNonFinal, outpoint(vin.Txid, vin.Vout), confirmed+n)
+		}
+	}
+
+	return nil
+}
+
+// TxHeight 返回UTXO集中的交易所在区块的高度，交易的输出已经全部花费或不存在时返回false
+func (u UTXOSet) TxHeight(txID []byte) (int, bool) {
+	height := 0
+	found := false
+
+	err := u.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		blockHash := tx.Bucket([]byte(utxoBlockBucket)).Get(txID)
+		if blockHash == nil {
+			return nil
+		}
+		blockData := tx.Bucket([]byte(blocksBucket)).Get(blockHash)
+		if blockData == nil {
+			return nil
+		}
+
+		height, found = DeserializeBlock(blockData).Height, true
+		return nil
+	})
+	if err != nil {
+		return 0, false
+	}
+
+	return height, found
+}
@@ -0,0 +1,98 @@
+package blockchain7
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsFinal(t *testing.T) {
+	const blockTime = lockTimeThreshold + 1000
+
+	cases := []struct {
+		name     string
+		lockTime int64
+		sequence uint32
+		final    bool
+	}{
+		{"no lock time", 0, maxReplaceableSequence, true},
+		{"height passed", 99, maxReplaceableSequence, true},
+		{"height of this block", 100, maxReplaceableSequence, false},
+		{"height in the future", 200, maxReplaceableSequence, false},
+		{"time passed", blockTime - 1, maxReplaceableSequence, true},
+		{"time of this block", blockTime, maxReplaceableSequence, false},
+		{"time in the future", blockTime + 1, maxReplaceableSequence, false},
+		{"final sequence ignores lock time", 200, sequenceFinal, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tx := newSpendingTx(c.sequence, c.lockTime)
+			assert.Equal(t, c.final, tx.IsFinal(100, blockTime))
+		})
+	}
+}
+
+func TestRelativeLock(t *testing.T) {
+	cases := []struct {
+		sequence uint32
+		blocks   int
+	}{
+		{0, 0},
+		{1, 1},
+		{10, 10},
+		{sequenceLockTimeMask, sequenceLockTimeMask},
+		{0x00010005, 5}, //只有低16位是区块数
+		{sequenceLockTimeDisableFlag | 10, 0},
+		{maxReplaceableSequence, 0},
+		{sequenceFinal, 0},
+	}
+
+	for _, c := range cases {
+		in := TxInput{Sequence: c.sequence}
+		assert.Equal(t, c.blocks, in.RelativeLock(), "sequence %x", c.sequence)
+	}
+}
+
+func TestCheckLocks(t *testing.T) {
+	confirmed := []byte("confirmed") //在高度10上链的交易
+	heightOf := func(txID []byte) (int, bool) {
+		if string(txID) == string(confirmed) {
+			return 10, true
+		}
+		return 0, false
+	}
+	unconfirmed := []byte("unconfirmed")
+
+	cases := []struct {
+		name     string
+		txID     []byte
+		sequence uint32
+		lockTime int64
+		height   int
+		ok       bool
+	}{
+		{"no locks", confirmed, maxReplaceableSequence, 0, 11, true},
+		{"relative lock reached", confirmed, 5, 0, 15, true},
+		{"relative lock not reached", confirmed, 5, 0, 14, false},
+		{"relative lock on unconfirmed parent", unconfirmed, 5, 0, 100, false},
+		{"unconfirmed parent without relative lock", unconfirmed, maxReplaceableSequence, 0, 11, true},
+		{"disabled relative lock", confirmed, sequenceLockTimeDisableFlag | 5, 0, 11, true},
+		{"lock time not reached", confirmed, maxReplaceableSequence, 20, 20, false},
+		{"lock time and relative lock reached", confirmed, 5, 20, 21, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tx := newSpendingTx(c.sequence, c.lockTime)
+			tx.Vin[0].Txid = c.txID
+
+			err := checkLocks(tx, c.height, lockTimeThreshold, heightOf)
+			if c.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrTxNonFinal)
+			}
+		})
+	}
+}
@@ -0,0 +1,701 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const mempoolFile = "mempool_%s.dat"
+
+const defaultMaxMempoolSize = 5 * 1024 * 1024 //交易池默认最大容量（字节）
+const defaultMinRelayFeeRate = 1              //默认最低转发费率（每千字节的手续费）
+const defaultMempoolExpiry = 72 * time.Hour   //交易在交易池中的默认最长停留时间
+const maxReplacementEvictions = 100           //一次手续费替换最多删除的交易数量（包括后代交易）
+
+// 交易进入交易池失败的原因
+var (
+	ErrTxAlreadyKnown  = errors.New("交易已经在交易池中")
+	ErrTxCoinbase      = errors.New("coinbase交易不能进入交易池")
+	ErrTxConflict      = errors.New("交易与交易池中的交易花费了同一个输出")
+	ErrTxMissingInputs = errors.New("交易引用的输出不存在或已经花费")
+	ErrTxInvalid       = errors.New("非法交易")
+	ErrTxFeeTooLow     = errors.New("交易手续费率低于最低转发费率")
+	ErrMempoolFull     = errors.New("交易池已满且交易的手续费率不够高")
+)
+
+// mempoolEntry 交易池中的一条记录
+type mempoolEntry struct {
+	tx    *Transaction
+	fee   int       //手续费：输入总额减去输出总额
+	size  int       //序列化后的字节数
+	added time.Time //进入交易池的时间
+}
+
+// feeRate 每千字节的手续费
+func (e *mempoolEntry) feeRate() int {
+	return e.fee * 1000 / e.size
+}
+
+// Mempool 交易池，保存已验证、等待上链的交易
+// 所有方法都是并发安全的，可以在多个处理连接的协程中同时使用
+// 交易池的总容量有上限，满了之后按手续费率从低到高淘汰交易（连同花费其输出的后代交易）
+type Mempool struct {
+	mtx       sync.RWMutex
+	bc        *Blockchain
+	txs       map[string]*mempoolEntry //交易ID->交易记录
+	spent     map[string]string        //被交易池中的交易花费的输出（outpoint）->花费它的交易ID
+	byFeeRate []*mempoolEntry          //按手续费率从低到高排列的索引
+	totalSize int                      //交易池中所有交易的字节数
+
+	orphans       map[string]*orphanTx       //孤儿交易ID->孤儿交易
+	orphansByPrev map[string]map[string]bool //缺失的父交易输出（outpoint）->等待它的孤儿交易ID
+
+	maxSize         int           //交易池最大容量（字节）
+	minRelayFeeRate int           //最低转发费率（每千字节的手续费）
+	expiry          time.Duration //交易在交易池中的最长停留时间
+
+	estimator *FeeEstimator //手续费估算器，可以为nil
+}
+
+var mempool *Mempool
+
+// NewMempool 创建一个空的交易池
+func NewMempool(bc *Blockchain, maxSize, minRelayFeeRate int, expiry time.Duration) *Mempool {
+	return &Mempool{
+		bc:              bc,
+		txs:             make(map[string]*mempoolEntry),
+		spent:           make(map[string]string),
+		orphans:         make(map[string]*orphanTx),
+		orphansByPrev:   make(map[string]map[string]bool),
+		maxSize:         maxSize,
+		minRelayFeeRate: minRelayFeeRate,
+		expiry:          expiry,
+	}
+}
+
+// SetFeeEstimator 设置手续费估算器，交易池把交易进入交易池和被区块确认的情况报告给它
+func (mp *Mempool) SetFeeEstimator(estimator *FeeEstimator) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	mp.estimator = estimator
+}
+
+// outpoint 输出的唯一标识：交易ID加上输出在交易中的索引
+func outpoint(txID []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", txID, vout)
+}
+
+// Add 验证交易并加入交易池
+// 交易的每个输入都必须引用UTXO集或交易池中交易的未花费输出，签名必须有效，
+// 输入总额不能小于输出总额，手续费率不能低于最低转发费率
+// 输入已被交易池中的其他交易花费时，只有满足手续费替换（RBF）的条件才能替换这些交易，否则拒绝
+// 交易池满时，淘汰手续费率最低的交易，如果新交易的手续费率不高于交易池中最低的，则拒绝新交易
+func (mp *Mempool) Add(tx *Transaction) error {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	return mp.add(tx, time.Now())
+}
+
+//add 验证交易并加入交易池，added为交易进入交易池的时间，调用者需持有锁
+func (mp *Mempool) add(tx *Transaction, added time.Time) error {
+	entry, conflicts, err := mp.validate(tx)
+	if err != nil {
+		return err
+	}
+	entry.added = added
+
+	if entry.feeRate() < mp.minRelayFeeRate {
+		return fmt.Errorf("%w: %d < %d", ErrTxFeeTooLow, entry.feeRate(), mp.minRelayFeeRate)
+	}
+
+	replaced := make(map[string]*mempoolEntry)
+	if len(conflicts) > 0 {
+		replaced, err = mp.checkReplacement(entry, conflicts)
+		if err != nil {
+			return err
+		}
+	}
+
+	//所有检查都在删除被替换的交易之前完成，新交易被拒绝时交易池保持原样
+	freed := 0
+	for _, e := range replaced {
+		freed += e.size
+	}
+	full := mp.totalSize-freed+entry.size > mp.maxSize
+	if lowest := mp.lowestEntry(replaced); full && lowest != nil && entry.feeRate() <= lowest.feeRate() {
+		return ErrMempoolFull
+	}
+
+	var snapshot []*mempoolEntry //可能淘汰交易时，保存交易池原来的内容，新交易最终没能留下时恢复
+	if full || len(replaced) > 0 {
+		snapshot = mp.sortedEntries()
+	}
+
+	//新交易最终留下后才通知手续费估算器，恢复原样时估算器对原有交易的跟踪不受影响
+	estimator := mp.estimator
+	mp.estimator = nil
+	for txID := range replaced {
+		fmt.Printf("交易 %x 替换了交易 %s\n", tx.ID, txID)
+		mp.removeTx(txID)
+	}
+	mp.addUnchecked(entry)
+	mp.trim()
+	mp.estimator = estimator
+
+	if mp.txs[hex.EncodeToString(tx.ID)] == nil { //新交易连同其父交易一起被淘汰了
+		for _, e := range snapshot {
+			if mp.txs[hex.EncodeToString(e.tx.ID)] == nil {
+				mp.addUnchecked(e)
+			}
+		}
+		return ErrMempoolFull
+	}
+
+	if estimator != nil {
+		for _, e := range snapshot {
+			if txID := hex.EncodeToString(e.tx.ID); mp.txs[txID] == nil {
+				estimator.removeTx(txID)
+			}
+		}
+		estimator.processTx(hex.EncodeToString(tx.ID), entry.feeRate())
+	}
+
+	return nil
+}
+
+// lowestEntry 手续费率最低、并且不在excluded中的交易，没有时返回nil，调用者需持有锁
+func (mp *Mempool) lowestEntry(excluded map[string]*mempoolEntry) *mempoolEntry {
+	for _, e := range mp.byFeeRate {
+		if excluded[hex.EncodeToString(e.tx.ID)] == nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// validate 检查交易能否进入交易池，返回交易池记录，调用者需持有锁
+// 与交易池中的交易花费了同一个输出时，不直接拒绝，而是返回这些冲突交易的ID，由调用者决定能否替换它们
+func (mp *Mempool) validate(tx *Transaction) (*mempoolEntry, map[string]bool, error) {
+	if !bytes.Equal(tx.ID, tx.Hash()) { //ID与内容不符的交易会冒充其他交易，如使真正的交易被当作已知交易拒绝
+		return nil, nil, fmt.Errorf("%w: 交易ID与交易的哈希不符", ErrTxInvalid)
+	}
+	txID := hex.EncodeToString(tx.ID)
+	if mp.txs[txID] != nil {
+		return nil, nil, ErrTxAlreadyKnown
+	}
+	if tx.IsCoinbase() {
+		return nil, nil, ErrTxCoinbase
+	}
+	if len(tx.Vin) == 0 || len(tx.Vout) == 0 {
+		return nil, nil, fmt.Errorf("%w: 没有输入或输出", ErrTxInvalid)
+	}
+
+	outValue, err := checkOutputs(tx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrTxInvalid, err)
+	}
+
+	inValue := 0
+	seen := make(map[string]bool)
+	conflicts := make(map[string]bool)
+	for _, vin := range tx.Vin {
+		op := outpoint(vin.Txid, vin.Vout)
+		if seen[op] {
+			return nil, nil, fmt.Errorf("%w: 重复的输入 %s", ErrTxInvalid, op)
+		}
+		seen[op] = true
+
+		if spender, ok := mp.spent[op]; ok {
+			conflicts[spender] = true
+		}
+
+		out, ok := mp.findOutput(vin.Txid, vin.Vout)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrTxMissingInputs, op)
+		}
+		inValue += out.Value
+	}
+
+	if inValue < outValue {
+		return nil, nil, fmt.Errorf("%w: 输入总额 %d 小于输出总额 %d", ErrTxInvalid, inValue, outValue)
+	}
+
+	//交易最早被打包进下一个区块，花费交易池中交易的输出的输入不能有相对锁定
+	UTXOSet := UTXOSet{mp.bc}
+	if err := checkLocks(tx, mp.bc.GetBestHeight()+1, time.Now().Unix(), UTXOSet.TxHeight); err != nil {
+		return nil, nil, err
+	}
+
+	if !mp.bc.verifyTransaction(tx, mp.parents(tx)) {
+		return nil, nil, fmt.Errorf("%w: 签名无效", ErrTxInvalid)
+	}
+
+	return &mempoolEntry{tx, inValue - outValue, len(tx.Serialize()), time.Now()}, conflicts, nil
+}
+
+// checkReplacement 检查新交易能否替换与它冲突的交易，返回将被删除的全部交易（冲突交易及其后代交易），调用者需持有锁
+// 冲突交易都必须选择接受替换，新交易的手续费率必须高于每个冲突交易，手续费总额必须高于被删除的全部交易，
+// 并且增加的手续费至少能按最低转发费率支付新交易的转发费用
+func (mp *Mempool) checkReplacement(entry *mempoolEntry, conflicts map[string]bool) (map[string]*mempoolEntry, error) {
+	replaced := make(map[string]*mempoolEntry)
+	for txID := range conflicts {
+		conflict := mp.txs[txID]
+		if !conflict.tx.SignalsReplacement() {
+			return nil, fmt.Errorf("%w: 交易 %s 不接受替换", ErrTxConflict, txID)
+		}
+		if entry.feeRate() <= conflict.feeRate() {
+			return nil, fmt.Errorf("%w: 手续费率 %d 不高于交易 %s 的 %d", ErrTxConflict, entry.feeRate(), txID, conflict.feeRate())
+		}
+		mp.collectDescendants(txID, replaced)
+	}
+
+	if len(replaced) > maxReplacementEvictions {
+		return nil, fmt.Errorf("%w: 替换将删除%d个交易", ErrTxConflict, len(replaced))
+	}
+
+	for _, vin := range entry.tx.Vin {
+		if replaced[hex.EncodeToString(vin.Txid)] != nil {
+			return nil, fmt.Errorf("%w: 交易花费了将被替换的交易 %x 的输出", ErrTxConflict, vin.Txid)
+		}
+	}
+
+	replacedFee := 0
+	for _, e := range replaced {
+		replacedFee += e.fee
+	}
+	if entry.fee <= replacedFee {
+		return nil, fmt.Errorf("%w: 手续费 %d 不高于被替换交易的手续费总额 %d", ErrTxConflict, entry.fee, replacedFee)
+	}
+	if entry.fee-replacedFee < mp.minRelayFeeRate*entry.size/1000 {
+		return nil, fmt.Errorf("%w: 增加的手续费 %d 不足以支付转发费用", ErrTxConflict, entry.fee-replacedFee)
+	}
+
+	return replaced, nil
+}
+
+// collectDescendants 将交易及其所有后代交易加入descendants，调用者需持有锁
+func (mp *Mempool) collectDescendants(txID string, descendants map[string]*mempoolEntry) {
+	entry := mp.txs[txID]
+	if entry == nil || descendants[txID] != nil {
+		return
+	}
+
+	descendants[txID] = entry
+	for i := range entry.tx.Vout {
+		if child, ok := mp.spent[outpoint(entry.tx.ID, i)]; ok {
+			mp.collectDescendants(child, descendants)
+		}
+	}
+}
+
+// findOutput 查找输入引用的输出，调用者需持有锁
+// 输出可以是UTXO集中已确认的输出，也可以是交易池中交易的输出，是否已被交易池中的交易花费由调用者检查
+func (mp *Mempool) findOutput(txID []byte, vout int) (TxOutput, bool) {
+	if parent := mp.txs[hex.EncodeToString(txID)]; parent != nil {
+		if vout < 0 || vout >= len(parent.tx.Vout) || parent.tx.Vout[vout].IsUnspendable() {
+			return TxOutput{}, false
+		}
+		return parent.tx.Vout[vout], true
+	}
+
+	return (UTXOSet{mp.bc}).FindOutput(txID, vout)
+}
+
+// parents 返回交易在交易池中的父交易，调用者需持有锁
+func (mp *Mempool) parents(tx *Transaction) map[string]*Transaction {
+	parents := make(map[string]*Transaction)
+	for _, vin := range tx.Vin {
+		key := hex.EncodeToString(vin.Txid)
+		if parent := mp.txs[key]; parent != nil {
+			parents[key] = parent.tx
+		}
+	}
+
+	return parents
+}
+
+// addUnchecked 将交易加入交易池，不做任何检查，调用者需持有锁
+func (mp *Mempool) addUnchecked(entry *mempoolEntry) {
+	tx := entry.tx
+	txID := hex.EncodeToString(tx.ID)
+
+	mp.txs[txID] = entry
+	for _, vin := range tx.Vin {
+		mp.spent[outpoint(vin.Txid, vin.Vout)] = txID
+	}
+
+	//插入手续费率索引，保持从低到高的顺序
+	i := sort.Search(len(mp.byFeeRate), func(i int) bool {
+		return mp.byFeeRate[i].feeRate() > entry.feeRate()
+	})
+	mp.byFeeRate = append(mp.byFeeRate, nil)
+	copy(mp.byFeeRate[i+1:], mp.byFeeRate[i:])
+	mp.byFeeRate[i] = entry
+	mp.totalSize += entry.size
+
+	if mp.estimator != nil {
+		mp.estimator.processTx(txID, entry.feeRate())
+	}
+}
+
+// removeTx 从交易池中删除交易，调用者需持有锁
+func (mp *Mempool) removeTx(txID string) {
+	entry := mp.txs[txID]
+	if entry == nil {
+		return
+	}
+
+	for _, vin := range entry.tx.Vin {
+		delete(mp.spent, outpoint(vin.Txid, vin.Vout))
+	}
+	delete(mp.txs, txID)
+
+	for i, e := range mp.byFeeRate {
+		if e == entry {
+			mp.byFeeRate = append(mp.byFeeRate[:i], mp.byFeeRate[i+1:]...)
+			break
+		}
+	}
+	mp.totalSize -= entry.size
+
+	if mp.estimator != nil {
+		mp.estimator.removeTx(txID)
+	}
+}
+
+// removeWithDescendants 删除交易以及所有花费其输出的后代交易，调用者需持有锁
+func (mp *Mempool) removeWithDescendants(txID string) {
+	entry := mp.txs[txID]
+	if entry == nil {
+		return
+	}
+
+	for i := range entry.tx.Vout {
+		if child, ok := mp.spent[outpoint(entry.tx.ID, i)]; ok {
+			mp.removeWithDescendants(child)
+		}
+	}
+	mp.removeTx(txID)
+}
+
+// trim 交易池超出容量时，按手续费率从低到高淘汰交易，调用者需持有锁
+func (mp *Mempool) trim() {
+	for mp.totalSize > mp.maxSize && len(mp.byFeeRate) > 0 {
+		lowest := mp.byFeeRate[0]
+		fmt.Printf("交易池已满，淘汰交易 %x (费率 %d)\n", lowest.tx.ID, lowest.feeRate())
+		mp.removeWithDescendants(hex.EncodeToString(lowest.tx.ID))
+	}
+}
+
+// Expire 删除在交易池中停留超过最长时间的交易（连同其后代交易），以及过期的孤儿交易
+func (mp *Mempool) Expire() {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	deadline := time.Now().Add(-mp.expiry)
+	for txID, entry := range mp.txs {
+		if entry.added.Before(deadline) {
+			fmt.Printf("交易 %s 在交易池中已过期\n", txID)
+			mp.removeWithDescendants(txID)
+		}
+	}
+	mp.expireOrphans()
+}
+
+// BlockConnected 区块连接到区块链后，删除交易池中已经上链的交易，
+// 以及与区块中的交易花费了同一个输出的冲突交易，然后重新处理父交易已经上链的孤儿交易
+// 返回因此进入交易池的孤儿交易
+func (mp *Mempool) BlockConnected(block *Block) []*Transaction {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	if mp.estimator != nil { //先统计确认情况，再删除已上链的交易
+		mp.estimator.processBlock(block)
+	}
+
+	for _, tx := range block.Transactions {
+		mp.removeTx(hex.EncodeToString(tx.ID))
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, vin := range tx.Vin {
+			if spender, ok := mp.spent[outpoint(vin.Txid, vin.Vout)]; ok {
+				fmt.Printf("删除与区块冲突的交易 %s\n", spender)
+				mp.removeWithDescendants(spender)
+			}
+		}
+	}
+
+	var accepted []*Transaction
+	for _, tx := range block.Transactions {
+		mp.removeOrphan(hex.EncodeToString(tx.ID))
+		accepted = append(accepted, mp.processOrphans(tx)...)
+	}
+
+	return accepted
+}
+
+// BlockDisconnected 切换分支断开区块、并把其中的普通交易放回交易池之后调用
+// 区块中没有回到交易池的交易（coinbase交易，以及与新分支冲突的交易）的输出已经不存在，删除花费这些输出的交易及其后代
+func (mp *Mempool) BlockDisconnected(block *Block) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	for _, tx := range block.Transactions {
+		if _, ok := mp.txs[hex.EncodeToString(tx.ID)]; ok {
+			continue
+		}
+		for i := range tx.Vout {
+			if spender, ok := mp.spent[outpoint(tx.ID, i)]; ok {
+				fmt.Printf("删除花费已断开区块中交易 %x 的输出的交易 %s\n", tx.ID, spender)
+				mp.removeWithDescendants(spender)
+			}
+		}
+	}
+}
+
+// Remove 从交易池中删除交易以及所有花费其输出的后代交易
+func (mp *Mempool) Remove(txID []byte) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	mp.removeWithDescendants(hex.EncodeToString(txID))
+}
+
+// Has 检查交易是否在交易池中
+func (mp *Mempool) Has(txID []byte) bool {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp.txs[hex.EncodeToString(txID)] != nil
+}
+
+// Get 根据交易ID从交易池中取得交易
+func (mp *Mempool) Get(txID []byte) (Transaction, bool) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	entry := mp.txs[hex.EncodeToString(txID)]
+	if entry == nil {
+		return Transaction{}, false
+	}
+
+	return *entry.tx, true
+}
+
+// Count 返回交易池中交易的数量
+func (mp *Mempool) Count() int {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return len(mp.txs)
+}
+
+// Transactions 返回交易池中的所有交易，按手续费率从高到低排列
+func (mp *Mempool) Transactions() []*Transaction {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	var txs []*Transaction
+	for i := len(mp.byFeeRate) - 1; i >= 0; i-- {
+		txs = append(txs, mp.byFeeRate[i].tx)
+	}
+
+	return txs
+}
+
+// Select 为新区块选择交易：按手续费率从高到低，直到交易总字节数达到maxSize
+// 交易只有在它在交易池中的父交易都已选中后才能选中，所以返回的交易中父交易总在子交易之前
+func (mp *Mempool) Select(maxSize int) []*Transaction {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	var txs []*Transaction
+	selected := make(map[string]bool)
+	size := 0
+	for progress := true; progress; { //选中父交易后，之前跳过的子交易可能可以选中了，再扫描一遍
+		progress = false
+		for i := len(mp.byFeeRate) - 1; i >= 0; i-- {
+			entry := mp.byFeeRate[i]
+			txID := hex.EncodeToString(entry.tx.ID)
+			if selected[txID] || size+entry.size > maxSize || !mp.parentsSelected(entry.tx, selected) {
+				continue
+			}
+			txs = append(txs, entry.tx)
+			selected[txID] = true
+			size += entry.size
+			progress = true
+		}
+	}
+
+	return txs
+}
+
+// parentsSelected 检查交易在交易池中的父交易是否都已选中，调用者需持有锁
+func (mp *Mempool) parentsSelected(tx *Transaction, selected map[string]bool) bool {
+	for _, vin := range tx.Vin {
+		key := hex.EncodeToString(vin.Txid)
+		if mp.txs[key] != nil && !selected[key] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sortedEntries 返回交易池中的所有记录，按进入交易池的时间排序，并保证父交易在子交易之前，调用者需持有锁
+// 从文件读取的交易进入交易池的时间只精确到秒，父子交易的时间可能相同，所以不能只按时间排序
+func (mp *Mempool) sortedEntries() []*mempoolEntry {
+	var entries []*mempoolEntry
+	for _, entry := range mp.txs {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].added.Before(entries[j].added)
+	})
+
+	var sorted []*mempoolEntry
+	visited := make(map[string]bool)
+	var visit func(entry *mempoolEntry)
+	visit = func(entry *mempoolEntry) {
+		txID := hex.EncodeToString(entry.tx.ID)
+		if visited[txID] {
+			return
+		}
+		visited[txID] = true
+		for _, vin := range entry.tx.Vin {
+			if parent := mp.txs[hex.EncodeToString(vin.Txid)]; parent != nil {
+				visit(parent)
+			}
+		}
+		sorted = append(sorted, entry)
+	}
+	for _, entry := range entries {
+		visit(entry)
+	}
+
+	return sorted
+}
+
+// Fee 返回交易池中交易的手续费，交易不在交易池中时返回0
+func (mp *Mempool) Fee(txID []byte) int {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	entry := mp.txs[hex.EncodeToString(txID)]
+	if entry == nil {
+		return 0
+	}
+
+	return entry.fee
+}
+
+// DescendantFee 返回交易及其所有后代交易的手续费总额，替换该交易的交易至少要支付这么多手续费
+func (mp *Mempool) DescendantFee(txID []byte) int {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	descendants := make(map[string]*mempoolEntry)
+	mp.collectDescendants(hex.EncodeToString(txID), descendants)
+
+	fee := 0
+	for _, entry := range descendants {
+		fee += entry.fee
+	}
+
+	return fee
+}
+
+//savedTx 保存到文件中的一条交易池记录
+type savedTx struct {
+	Transaction []byte
+	Added       int64 //进入交易池的时间（Unix时间戳）
+}
+
+// SaveToFile 将交易池中的交易保存到文件，节点关闭时调用
+//父交易总在子交易之前保存，这样重新读取时子交易可以花费父交易的输出
+func (mp *Mempool) SaveToFile(nodeID string) {
+	mp.mtx.RLock()
+	entries := mp.sortedEntries()
+	mp.mtx.RUnlock()
+
+	var saved []savedTx
+	for _, entry := range entries {
+		saved = append(saved, savedTx{entry.tx.Serialize(), entry.added.Unix()})
+	}
+
+	var content bytes.Buffer
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(saved)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf(mempoolFile, nodeID), content.Bytes(), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("已保存交易池中的%d个交易\n", len(saved))
+}
+
+// LoadFromFile 从文件读取节点上次关闭时的交易池，每个交易都重新验证
+//节点关闭期间连接的区块可能已经花费了交易的输入，这些交易会被丢弃
+func (mp *Mempool) LoadFromFile(nodeID string) error {
+	mempoolFile := fmt.Sprintf(mempoolFile, nodeID)
+	if _, err := os.Stat(mempoolFile); os.IsNotExist(err) {
+		return err
+	}
+
+	fileContent, err := ioutil.ReadFile(mempoolFile)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var saved []savedTx
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	err = decoder.Decode(&saved)
+	if err != nil {
+		return err
+	}
+
+	mp.mtx.Lock()
+	loaded := 0
+	for _, s := range saved {
+		tx := DeserializeTransaction(s.Transaction)
+		err := mp.add(&tx, time.Unix(s.Added, 0))
+		if err != nil {
+			fmt.Printf("丢弃交易 %x: %s\n", tx.ID, err)
+			continue
+		}
+		loaded++
+	}
+	mp.mtx.Unlock()
+
+	mp.Expire()
+	fmt.Printf("从文件中读取了%d个交易，%d个仍然有效\n", len(saved), loaded)
+
+	return nil
+}
+
+// run 定时删除过期的交易
+func (mp *Mempool) run() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		mp.Expire()
+	}
+}
@@ -0,0 +1,371 @@
+package blockchain7
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMempoolEviction(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	//A把创始区块的10个币拆成3个输出，B、D不付手续费，C付1个币
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 4, 3, 2)
+	pending := map[string]*Transaction{hex.EncodeToString(a.ID): a}
+	b := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 4)
+	c := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 1, nil, maxReplaceableSequence}}, 2)
+	d := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 2, nil, maxReplaceableSequence}}, 1, 1)
+
+	mp := NewMempool(bc, txSize(a)+txSize(b)+txSize(c)-1, 0, defaultMempoolExpiry)
+	assert.NoError(t, mp.Add(a))
+	assert.NoError(t, mp.Add(b))
+
+	//交易池已满，C的费率高于B，B被淘汰
+	assert.NoError(t, mp.Add(c))
+	assert.True(t, mp.Has(a.ID), "parent stays")
+	assert.False(t, mp.Has(b.ID), "lowest fee rate is evicted")
+	assert.True(t, mp.Has(c.ID), "higher fee rate is accepted")
+
+	//D的费率不高于交易池中最低的，被拒绝，交易池不变
+	assert.ErrorIs(t, mp.Add(d), ErrMempoolFull)
+	assert.Equal(t, 2, mp.Count())
+}
+
+func TestMempoolEvictionRestoresOnFailure(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	//A不付手续费，费率最低；C花费A的输出，淘汰A时C作为后代交易也被淘汰，这时A和B必须恢复
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 5, 5)
+	pending := map[string]*Transaction{hex.EncodeToString(a.ID): a}
+	b := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 4)
+	c := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 1, nil, maxReplaceableSequence}}, 4)
+
+	mp := NewMempool(bc, txSize(a)+txSize(b)+txSize(c)-1, 0, defaultMempoolExpiry)
+	assert.NoError(t, mp.Add(a))
+	assert.NoError(t, mp.Add(b))
+
+	assert.ErrorIs(t, mp.Add(c), ErrMempoolFull)
+	assert.True(t, mp.Has(a.ID), "evicted parent is restored")
+	assert.True(t, mp.Has(b.ID), "evicted sibling is restored")
+	assert.False(t, mp.Has(c.ID))
+}
+
+func TestMempoolAdmission(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+	input := []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}
+
+	cases := []struct {
+		name            string
+		values          []int
+		minRelayFeeRate int
+		err             error
+	}{
+		{"fee above min relay fee", []int{9}, 1, nil},
+		{"fee below min relay fee", []int{9}, 100000, ErrTxFeeTooLow},
+		{"outputs exceed inputs", []int{11}, 0, ErrTxInvalid},
+		{"zero value output", []int{10, 0}, 0, ErrTxInvalid},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mp := NewMempool(bc, defaultMaxMempoolSize, c.minRelayFeeRate, defaultMempoolExpiry)
+			tx := newTestTx(bc, wallet, nil, input, c.values...)
+
+			err := mp.Add(tx)
+			if c.err == nil {
+				assert.NoError(t, err)
+				assert.True(t, mp.Has(tx.ID))
+			} else {
+				assert.ErrorIs(t, err, c.err)
+				assert.Equal(t, 0, mp.Count())
+			}
+		})
+	}
+}
+
+func TestMempoolExpire(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 5, 4)
+	b := newTestTx(bc, wallet, map[string]*Transaction{hex.EncodeToString(a.ID): a}, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 4)
+
+	mp := NewMempool(bc, defaultMaxMempoolSize, 0, time.Hour)
+	assert.NoError(t, mp.add(a, time.Now().Add(-2*time.Hour)))
+	assert.NoError(t, mp.Add(b))
+
+	//A过期，花费A的输出的B一起删除
+	mp.Expire()
+	assert.Equal(t, 0, mp.Count())
+}
+
+func TestMempoolReplaceByFee(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	cases := []struct {
+		name     string
+		sequence uint32 //原交易输入的序号
+		original []int  //原交易的输出金额，输入为创始区块的10个币
+		replace  []int  //替换交易的输出金额
+		err      error
+	}{
+		{"higher fee replaces", maxReplaceableSequence, []int{9}, []int{7}, nil},
+		{"same fee rate is rejected", maxReplaceableSequence, []int{9}, []int{9}, ErrTxConflict},
+		{"lower fee is rejected", maxReplaceableSequence, []int{7}, []int{9}, ErrTxConflict},
+		{"original does not signal replacement", sequenceFinal, []int{9}, []int{5}, ErrTxConflict},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mp := NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+			original := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, c.sequence}}, c.original...)
+			replacement := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, c.replace...)
+			replacement.Timestamp++ //金额相同时也要与原交易不同
+			replacement.ID = replacement.Hash()
+			bc.signTransaction(replacement, wallet.PrivateKey, nil)
+			assert.NoError(t, mp.Add(original))
+
+			err := mp.Add(replacement)
+			if c.err == nil {
+				assert.NoError(t, err)
+				assert.False(t, mp.Has(original.ID), "original is replaced")
+				assert.True(t, mp.Has(replacement.ID))
+			} else {
+				assert.ErrorIs(t, err, c.err)
+				assert.True(t, mp.Has(original.ID), "original stays")
+				assert.False(t, mp.Has(replacement.ID))
+			}
+		})
+	}
+}
+
+func TestMempoolReplaceWithDescendants(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 9)
+	b := newTestTx(bc, wallet, map[string]*Transaction{hex.EncodeToString(a.ID): a}, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 8)
+
+	mp := NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+	assert.NoError(t, mp.Add(a))
+	assert.NoError(t, mp.Add(b))
+	assert.Equal(t, 2, mp.DescendantFee(a.ID))
+
+	//手续费必须高于被替换的A和B的手续费总额
+	low := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 8)
+	assert.ErrorIs(t, mp.Add(low), ErrTxConflict)
+	assert.Equal(t, 2, mp.Count())
+
+	high := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 7)
+	assert.NoError(t, mp.Add(high))
+	assert.False(t, mp.Has(a.ID))
+	assert.False(t, mp.Has(b.ID), "descendant of the replaced transaction is removed")
+	assert.Equal(t, 1, mp.Count())
+}
+
+func TestMempoolReplacementRestoresOnFailure(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	//替换交易的费率更高，但本身超过交易池的容量，被拒绝后原交易必须还在
+	original := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 9)
+	replacement := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 1, 1, 1, 1)
+
+	mp := NewMempool(bc, txSize(original)+10, 0, defaultMempoolExpiry)
+	assert.NoError(t, mp.Add(original))
+
+	assert.ErrorIs(t, mp.Add(replacement), ErrMempoolFull)
+	assert.True(t, mp.Has(original.ID), "replaced transaction is restored")
+	assert.False(t, mp.Has(replacement.ID))
+}
+
+func TestMempoolValidation(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+	input := []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}
+
+	cases := []struct {
+		name string
+		tx   func() *Transaction
+		err  error
+	}{
+		{"valid", func() *Transaction {
+			return newTestTx(bc, wallet, nil, input, 9)
+		}, nil},
+		{"id does not match the hash", func() *Transaction {
+			tx := newTestTx(bc, wallet, nil, input, 9)
+			tx.ID = cb.ID
+			return tx
+		}, ErrTxInvalid},
+		{"output changed after signing", func() *Transaction {
+			tx := newTestTx(bc, wallet, nil, input, 9)
+			tx.Vout[0].Value = 10
+			tx.ID = tx.Hash()
+			return tx
+		}, ErrTxInvalid},
+		{"signed by another wallet", func() *Transaction {
+			tx := newTestTx(bc, wallet, nil, input, 9)
+			other := newTestTx(bc, NewWallet(), nil, input, 9)
+			tx.Vin[0].ScriptSig = other.Vin[0].ScriptSig
+			return tx
+		}, ErrTxInvalid},
+		{"duplicate input", func() *Transaction {
+			return newTestTx(bc, wallet, nil, append(input, input...), 9)
+		}, ErrTxInvalid},
+		{"unknown input", func() *Transaction {
+			tx := &Transaction{nil, []TxInput{{[]byte("unknown"), 0, nil, maxReplaceableSequence}}, []TxOutput{*NewTxOutput(1, string(wallet.GetAddress()))}, time.Now().Unix(), 0}
+			tx.ID = tx.Hash()
+			return tx
+		}, ErrTxMissingInputs},
+		{"coinbase", func() *Transaction {
+			return NewCoinbaseTX(string(wallet.GetAddress()), "", 0)
+		}, ErrTxCoinbase},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mp := NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+			tx := c.tx()
+
+			err := mp.Add(tx)
+			if c.err == nil {
+				assert.NoError(t, err)
+				assert.True(t, mp.Has(tx.ID))
+				assert.ErrorIs(t, mp.Add(tx), ErrTxAlreadyKnown)
+			} else {
+				assert.ErrorIs(t, err, c.err)
+				assert.Equal(t, 0, mp.Count())
+			}
+		})
+	}
+}
+
+func TestMempoolDoubleSpend(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	//A不接受替换，花费同一个输出的B即使手续费更高也被拒绝
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, sequenceFinal}}, 9)
+	b := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, sequenceFinal}}, 5)
+
+	mp := NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+	assert.NoError(t, mp.Add(a))
+	assert.ErrorIs(t, mp.Add(b), ErrTxConflict)
+	assert.True(t, mp.Has(a.ID))
+	assert.False(t, mp.Has(b.ID))
+
+	//A删除后输出不再被交易池花费，B可以进入
+	mp.Remove(a.ID)
+	assert.NoError(t, mp.Add(b))
+}
+
+func TestMempoolBlockConnected(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	cb := genesis.Transactions[0]
+
+	//B、C分别花费A的两个输出，D花费B的输出
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, sequenceFinal}}, 5, 5)
+	pending := map[string]*Transaction{hex.EncodeToString(a.ID): a}
+	b := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 0, nil, sequenceFinal}}, 5)
+	c := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 1, nil, sequenceFinal}}, 5)
+	pending[hex.EncodeToString(b.ID)] = b
+	d := newTestTx(bc, wallet, pending, []TxInput{{b.ID, 0, nil, sequenceFinal}}, 5)
+	for _, tx := range []*Transaction{a, b, c, d} {
+		assert.NoError(t, mempool.Add(tx))
+	}
+
+	//上链的A从交易池中删除，花费A的输出的交易留在交易池中
+	b1 := newTestBlock(t, &genesis, wallet, "b1", a)
+	assert.NoError(t, connectTestBlocks(b1))
+	assert.False(t, mempool.Has(a.ID))
+	assert.Equal(t, 3, mempool.Count())
+
+	//区块中的E与B花费了同一个输出，B及其后代D被删除，C不受影响
+	e := newTestTx(bc, wallet, nil, []TxInput{{a.ID, 0, nil, sequenceFinal}}, 4)
+	b2 := newTestBlock(t, b1, wallet, "b2", e)
+	assert.NoError(t, connectTestBlocks(b2))
+	assert.False(t, mempool.Has(b.ID))
+	assert.False(t, mempool.Has(d.ID))
+	assert.True(t, mempool.Has(c.ID))
+	assert.Equal(t, 1, mempool.Count())
+}
+
+func TestMempoolEvictionKeepsFeeEstimatorTracking(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	//与TestMempoolEvictionRestoresOnFailure相同的交易，C被拒绝时A和B恢复
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 5, 5)
+	pending := map[string]*Transaction{hex.EncodeToString(a.ID): a}
+	b := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 4)
+	c := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 1, nil, maxReplaceableSequence}}, 4)
+
+	fe := NewFeeEstimator(0)
+	mp := NewMempool(bc, txSize(a)+txSize(b)+txSize(c)-1, 0, defaultMempoolExpiry)
+	mp.SetFeeEstimator(fe)
+	assert.NoError(t, mp.Add(a))
+	assert.NoError(t, mp.Add(b))
+	tracked := map[string]trackedTx{
+		hex.EncodeToString(a.ID): {0, bucketIndex(0)},
+		hex.EncodeToString(b.ID): {0, bucketIndex(1000 / txSize(b))},
+	}
+	assert.Equal(t, tracked, fe.stats.Tracked)
+
+	//恢复的交易仍然按原来进入交易池的高度跟踪，被拒绝的交易不跟踪
+	fe.processBlock(&Block{Height: 3})
+	assert.ErrorIs(t, mp.Add(c), ErrMempoolFull)
+	assert.Equal(t, tracked, fe.stats.Tracked)
+}
+
+func TestMempoolPersistence(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	cb := genesis.Transactions[0]
+
+	mp := NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+	assert.Error(t, mp.LoadFromFile("test"), "no mempool file yet")
+
+	//B花费A的输出，保存时A在B之前，读取时B才能找到父交易
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 5, 4)
+	b := newTestTx(bc, wallet, map[string]*Transaction{hex.EncodeToString(a.ID): a}, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 4)
+	added := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.NoError(t, mp.add(a, added))
+	assert.NoError(t, mp.Add(b))
+	mp.SaveToFile("test")
+
+	loaded := NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+	assert.NoError(t, loaded.LoadFromFile("test"))
+	assert.True(t, loaded.Has(a.ID))
+	assert.True(t, loaded.Has(b.ID))
+	assert.Equal(t, added, loaded.txs[hex.EncodeToString(a.ID)].added, "time of entering the mempool is kept")
+	assert.Equal(t, mp.Fee(b.ID), loaded.Fee(b.ID))
+
+	//节点关闭期间连接的区块中的交易花费了A的输入，A和花费A的输出的B都被丢弃
+	conflict := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 8)
+	assert.NoError(t, connectTestBlocks(newTestBlock(t, &genesis, wallet, "b1", conflict)))
+
+	reloaded := NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+	assert.NoError(t, reloaded.LoadFromFile("test"))
+	assert.Equal(t, 0, reloaded.Count())
+}
+
+func TestMempoolPersistenceDropsExpired(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 9)
+	mp := NewMempool(bc, defaultMaxMempoolSize, 0, time.Hour)
+	assert.NoError(t, mp.add(a, time.Now().Add(-2*time.Hour)))
+	mp.SaveToFile("test")
+
+	loaded := NewMempool(bc, defaultMaxMempoolSize, 0, time.Hour)
+	assert.NoError(t, loaded.LoadFromFile("test"))
+	assert.Equal(t, 0, loaded.Count())
+}
@@ -1,6 +1,9 @@
 package blockchain7
 
-import "crypto/sha256"
+import (
+	"bytes"
+	"crypto/sha256"
+)
 
 // MerkleTree Merkle树结构
 type MerkleTree struct {
@@ -28,8 +31,11 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		nodes = append(nodes, *node)
 	}
 
-	//以叶子节点为基础，建立merkle树
-	for i := 0; i < len(data)/2; i++ { //第一层循环，次数是data的长度的一半
+	//以叶子节点为基础，建立merkle树，直到只剩一个根节点
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 { //某一层的节点数为奇数时，重复最后一个节点
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
 		var newLevel []MerkleNode
 
 		for j := 0; j < len(nodes); j += 2 { //内存每循环一次，nodes的长度减半
@@ -67,3 +73,44 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 
 	return &mNode
 }
+
+// MerkleProof 第index个数据在Merkle树中的证明路径：从叶子节点到根节点，每一层兄弟节点的哈希
+// 节点的计算方式与NewMerkleTree相同，持有证明路径的人不需要其他数据就可以用VerifyMerkleProof验证
+func MerkleProof(data [][]byte, index int) [][]byte {
+	var hashes [][]byte
+	for _, datum := range data {
+		hashes = append(hashes, NewMerkleNode(nil, nil, datum).Data)
+	}
+
+	var proof [][]byte
+	for len(proof) == 0 || len(hashes) > 1 { //只有一个数据时叶子层也要重复一次
+		if len(hashes)%2 != 0 {
+			hashes = append(hashes, hashes[len(hashes)-1])
+		}
+		proof = append(proof, hashes[index^1])
+
+		var level [][]byte
+		for j := 0; j < len(hashes); j += 2 {
+			level = append(level, NewMerkleNode(&MerkleNode{Data: hashes[j]}, &MerkleNode{Data: hashes[j+1]}, nil).Data)
+		}
+		hashes = level
+		index /= 2
+	}
+
+	return proof
+}
+
+// VerifyMerkleProof 用证明路径从数据datum计算根节点的哈希，与root比较，index为数据的位置
+func VerifyMerkleProof(datum []byte, index int, proof [][]byte, root []byte) bool {
+	node := NewMerkleNode(nil, nil, datum)
+	for _, sibling := range proof {
+		if index%2 == 0 {
+			node = NewMerkleNode(node, &MerkleNode{Data: sibling}, nil)
+		} else {
+			node = NewMerkleNode(&MerkleNode{Data: sibling}, node, nil)
+		}
+		index /= 2
+	}
+
+	return bytes.Equal(node.Data, root)
+}
@@ -3,11 +3,12 @@ package blockchain7
 import (
 	"encoding/hex"
 	"fmt"
+	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestNewMerkleNode(t *assert.TestingT) {
+func TestNewMerkleNode(t *testing.T) {
 	data := [][]byte{
 		[]byte("node1"),
 		[]byte("node2"),
@@ -29,26 +30,26 @@ func TestNewMerkleNode(t *assert.TestingT) {
 	n7 := NewMerkleNode(n5, n6, nil)
 
 	assert.Equal(
-		*t,
+		t,
 		"64b04b718d8b7c5b6fd17f7ec221945c034cfce3be4118da33244966150c4bd4",
 		hex.EncodeToString(n5.Data),
 		"Level 1 hash 1 is correct",
 	)
 	assert.Equal(
-		*t,
+		t,
 		"08bd0d1426f87a78bfc2f0b13eccdf6f5b58dac6b37a7b9441c1a2fab415d76c",
 		hex.EncodeToString(n6.Data),
 		"Level 1 hash 2 is correct",
 	)
 	assert.Equal(
-		*t,
+		t,
 		"4e3e44e55926330ab6c31892f980f8bfd1a6e910ff1ebc3f778211377f35227e",
 		hex.EncodeToString(n7.Data),
 		"Root hash is correct",
 	)
 }
 
-func TestNewMerkleTree(t *assert.TestingT) {
+func TestNewMerkleTree(t *testing.T) {
 	data := [][]byte{
 		[]byte("node1"),
 		[]byte("node2"),
@@ -70,5 +71,24 @@ func TestNewMerkleTree(t *assert.TestingT) {
 	rootHash := fmt.Sprintf("%x", n7.Data)
 	mTree := NewMerkleTree(data)
 
-	assert.Equal(*t, rootHash, fmt.Sprintf("%x", mTree.RootNode.Data), "Merkle tree root hash is correct")
+	assert.Equal(t, rootHash, fmt.Sprintf("%x", mTree.RootNode.Data), "Merkle tree root hash is correct")
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		var data [][]byte
+		for i := 0; i < n; i++ {
+			data = append(data, []byte(fmt.Sprintf("node%d", i+1)))
+		}
+		root := NewMerkleTree(data).RootNode.Data
+
+		for i := 0; i < n; i++ {
+			proof := MerkleProof(data, i)
+			assert.True(t, VerifyMerkleProof(data[i], i, proof, root), "%d of %d", i, n)
+			assert.False(t, VerifyMerkleProof([]byte("other"), i, proof, root), "%d of %d: other datum", i, n)
+			if n > 1 && !(n%2 == 1 && i == n-1) { //奇数个数据时最后一个与它的复制品互为兄弟，位置无法区分
+				assert.False(t, VerifyMerkleProof(data[i], i^1, proof, root), "%d of %d: wrong index", i, n)
+			}
+		}
+	}
 }
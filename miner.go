@@ -0,0 +1,156 @@
+package blockchain7
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultBlockInterval = 10 * time.Second //默认的目标出块间隔
+
+const minerTemplateRefresh = 10 * time.Second //交易池有变化时，最多每隔这么久用新的交易重新开始挖矿
+
+var errStaleBlock = errors.New("挖矿期间区块链的tip已经改变，新区块已过时")
+
+// Miner 挖矿节点的挖矿服务
+// 设定了-miner的节点在单独的协程中持续挖矿：每次从交易池选择交易构建新区块（交易池为空时挖空区块），
+// 距离上一个区块达到目标出块间隔后才开始挖下一个区块，挖出的区块通过inv通知其他节点
+// 收到新区块（tip改变）时立即中断当前的挖矿任务，用新的tip重新开始；交易池内容变化时最多每隔minerTemplateRefresh
+// 才用新的交易重新开始，否则源源不断的新交易会使挖矿任务不停地重新开始，永远挖不出区块
+type Miner struct {
+	mtx      sync.Mutex
+	bc       *Blockchain
+	address  string             //接收挖矿奖励的地址
+	workers  int                //挖矿协程数量
+	interval time.Duration      //目标出块间隔
+	cancel   context.CancelFunc //中断当前的挖矿任务，没有任务时为nil
+	started  time.Time          //当前的挖矿任务开始的时间
+	dirty    bool               //当前的挖矿任务开始之后交易池发生了变化
+	wake     chan struct{}      //通知挖矿协程tip发生了变化
+}
+
+var miner *Miner
+
+// NewMiner 创建挖矿服务，workers为并行计算哈希的协程数量，interval为目标出块间隔
+func NewMiner(bc *Blockchain, address string, workers int, interval time.Duration) *Miner {
+	return &Miner{bc: bc, address: address, workers: workers, interval: interval, wake: make(chan struct{}, 1)}
+}
+
+// NotifyTx 交易池内容变化时调用：当前的挖矿任务已经进行了minerTemplateRefresh时中断它，用新的交易重新开始，
+// 否则等到任务进行了minerTemplateRefresh时再中断
+func (m *Miner) NotifyTx() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.dirty = true
+	if m.cancel != nil && time.Since(m.started) >= minerTemplateRefresh {
+		m.cancel()
+	}
+}
+
+// Notify 区块链的tip改变时调用：中断当前的挖矿任务，用新的数据重新开始
+func (m *Miner) Notify() {
+	m.mtx.Lock()
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.mtx.Unlock()
+
+	select {
+	case m.wake <- struct{}{}:
+	default: //已经有未处理的通知
+	}
+}
+
+// run 持续挖矿，挖出的区块连接到本地区块链并通知其他节点
+func (m *Miner) run() {
+	for {
+		m.waitForNextBlock()
+
+		block, err := m.mineBlock()
+		if err == errNotInTurn || err == errNoStake { //等其他节点出块，tip改变或进入下一个时隙后再试
+			select {
+			case <-m.wake:
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if err != nil {
+			fmt.Printf("%s，重新开始挖矿\n", err)
+			continue
+		}
+
+		fmt.Printf("新区块已挖出! 高度 %d，包含%d个交易\n", block.Height, len(block.Transactions))
+		relayBlock(block, "")
+	}
+}
+
+// waitForNextBlock 等到可以开始挖下一个区块：区块同步已经完成，并且距离tip区块的时间达到目标出块间隔
+// 等待期间tip改变时重新计算等待时间
+func (m *Miner) waitForNextBlock() {
+	for {
+		if syncer.isSyncing() { //正在同步区块，在过时的tip上挖矿只会产生分叉
+			select {
+			case <-m.wake:
+			case <-time.After(syncTickInterval):
+			}
+			continue
+		}
+
+		last := m.bc.GetLastBlock()
+		wait := time.Until(time.Unix(last.Timestamp, 0).Add(m.interval))
+		if wait <= 0 {
+			return
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			return
+		case <-m.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// mineBlock 用交易池中的交易挖出一个区块并连接到本地区块链，交易池为空时挖出只有coinbase交易的空区块
+func (m *Miner) mineBlock() (*Block, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mtx.Lock()
+	m.cancel = cancel
+	m.started = time.Now()
+	m.dirty = false
+	m.mtx.Unlock()
+
+	refresh := time.AfterFunc(minerTemplateRefresh, func() { //任务进行期间交易池有变化，到时用新的交易重新开始
+		m.mtx.Lock()
+		if m.dirty {
+			cancel()
+		}
+		m.mtx.Unlock()
+	})
+	defer refresh.Stop()
+	defer func() {
+		m.mtx.Lock()
+		m.cancel = nil
+		m.mtx.Unlock()
+		cancel()
+	}()
+
+	tmpl := NewBlockTemplate(m.bc, mempool)
+	cbTx := tmpl.Coinbase(m.address, nil)
+
+	block, err := MineNewBlock(ctx, tmpl.transactions(cbTx), tmpl.PrevBlockHash, tmpl.Height, m.workers)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syncer.connectMinedBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
@@ -0,0 +1,230 @@
+package blockchain7
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testEngine 在原来的共识引擎封装区块之前调用seal，seal返回错误时不封装
+type testEngine struct {
+	ConsensusEngine
+	seal func(ctx context.Context, block *Block) error
+}
+
+func (e testEngine) Seal(ctx context.Context, block *Block, workers int) error {
+	if err := e.seal(ctx, block); err != nil {
+		return err
+	}
+
+	return e.ConsensusEngine.Seal(ctx, block, workers)
+}
+
+// newTestMiner 为bc创建全局的挖矿服务，奖励给wallet，测试结束时恢复
+func newTestMiner(t *testing.T, bc *Blockchain, wallet *Wallet, interval time.Duration) *Miner {
+	oldMiner := miner
+	miner = NewMiner(bc, string(wallet.GetAddress()), 1, interval)
+	t.Cleanup(func() { miner = oldMiner })
+
+	return miner
+}
+
+// blockUntilCancelled 让挖矿任务一直进行到被中断，started在任务开始后关闭
+func blockUntilCancelled(started chan struct{}) func(ctx context.Context, block *Block) error {
+	return func(ctx context.Context, block *Block) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+}
+
+// mineInBackground 在单独的协程中执行mineBlock，返回接收结果的通道
+func mineInBackground(m *Miner) chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.mineBlock()
+		done <- err
+	}()
+
+	return done
+}
+
+// expectMined 等待mineBlock返回
+func expectMined(t *testing.T, done chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("挖矿任务没有结束")
+		return nil
+	}
+}
+
+func TestMinerRestartsOnNewTip(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	m := newTestMiner(t, bc, wallet, 0)
+	genesis := bc.GetLastBlock()
+	block := newTestBlock(t, &genesis, wallet, "other")
+	started := make(chan struct{})
+	engine := consensus
+	consensus = testEngine{engine, blockUntilCancelled(started)}
+	done := mineInBackground(m)
+	<-started
+	consensus = engine
+
+	//其他节点的区块先连接到tip，正在挖的区块已经过时
+	assert.NoError(t, connectTestBlocks(block))
+
+	assert.Equal(t, context.Canceled, expectMined(t, done))
+	assert.Nil(t, m.cancel)
+	select {
+	case <-m.wake:
+	default:
+		t.Error("mining loop is not woken")
+	}
+}
+
+func TestMinerRestartsOnMempoolChange(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	m := newTestMiner(t, bc, wallet, 0)
+	started := make(chan struct{})
+	consensus = testEngine{consensus, blockUntilCancelled(started)}
+	done := mineInBackground(m)
+	<-started
+
+	//任务刚开始，交易池的变化要等到minerTemplateRefresh才生效
+	m.NotifyTx()
+	select {
+	case err := <-done:
+		t.Fatalf("mining restarted immediately: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	m.mtx.Lock()
+	assert.True(t, m.dirty)
+	m.started = m.started.Add(-minerTemplateRefresh)
+	m.mtx.Unlock()
+
+	m.NotifyTx()
+	assert.Equal(t, context.Canceled, expectMined(t, done))
+}
+
+func TestMinerDiscardsStaleBlock(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	m := newTestMiner(t, bc, wallet, 0)
+	genesis := bc.GetLastBlock()
+	other := newTestBlock(t, &genesis, wallet, "other")
+
+	//封装期间其他区块连接到tip
+	engine := consensus
+	consensus = testEngine{engine, func(ctx context.Context, block *Block) error {
+		consensus = engine
+		return connectTestBlocks(other)
+	}}
+
+	block, err := m.mineBlock()
+	assert.Equal(t, errStaleBlock, err)
+	assert.Nil(t, block)
+	assert.Equal(t, other.Hash, bc.Tip)
+}
+
+func TestMinerMinesMempoolTransactions(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	m := newTestMiner(t, bc, wallet, 0)
+	cb := bc.GetLastBlock().Transactions[0]
+	tx := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 8)
+	assert.NoError(t, mempool.Add(tx))
+
+	block, err := m.mineBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, block.Height)
+	assert.Len(t, block.Transactions, 2)
+	assert.Equal(t, tx.ID, block.Transactions[0].ID)
+	assert.True(t, block.Transactions[1].IsCoinbase())
+	assert.Equal(t, block.Hash, bc.Tip)
+	assert.Zero(t, mempool.Count(), "mined transactions leave the mempool")
+
+	//交易池为空时挖出只有coinbase交易的空区块
+	block, err = m.mineBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, block.Height)
+	assert.Len(t, block.Transactions, 1)
+	assert.Equal(t, block.Hash, bc.Tip)
+}
+
+func TestMinerWaitsForItsTurn(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	m := newTestMiner(t, bc, wallet, 0)
+	consensus.(authorizer).Authorize(NewWallet()) //本节点不是验证者
+
+	block, err := m.mineBlock()
+	assert.Equal(t, errNotInTurn, err)
+	assert.Nil(t, block)
+	assert.Equal(t, 0, bc.GetLastBlock().Height)
+}
+
+// waitInBackground 在单独的协程中执行waitForNextBlock，返回时关闭返回的通道
+func waitInBackground(m *Miner) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		m.waitForNextBlock()
+		close(done)
+	}()
+
+	return done
+}
+
+// expectWaiting 检查waitForNextBlock在d之内没有返回
+func expectWaiting(t *testing.T, done chan struct{}, d time.Duration) {
+	t.Helper()
+
+	select {
+	case <-done:
+		t.Fatal("miner did not wait")
+	case <-time.After(d):
+	}
+}
+
+func TestMinerWaitsForBlockInterval(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	m := newTestMiner(t, bc, wallet, 2*time.Second)
+
+	//区块时间戳精确到秒，距离创始区块至少还要等1秒
+	started := time.Now()
+	done := waitInBackground(m)
+	expectWaiting(t, done, 500*time.Millisecond)
+	m.Notify() //tip没有变化，重新计算后继续等待
+	expectWaiting(t, done, 300*time.Millisecond)
+
+	select {
+	case <-done:
+		assert.GreaterOrEqual(t, time.Since(started), time.Second)
+	case <-time.After(5 * time.Second):
+		t.Fatal("miner is still waiting")
+	}
+}
+
+func TestMinerWaitsWhileSyncing(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	m := newTestMiner(t, bc, wallet, 0)
+	syncer.mtx.Lock()
+	syncer.pending = [][]byte{[]byte("header")}
+	syncer.mtx.Unlock()
+
+	done := waitInBackground(m)
+	expectWaiting(t, done, 300*time.Millisecond)
+
+	//同步完成后连接区块会通知挖矿协程
+	syncer.mtx.Lock()
+	syncer.pending = nil
+	syncer.mtx.Unlock()
+	m.Notify()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("miner is still waiting")
+	}
+}
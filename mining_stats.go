@@ -0,0 +1,153 @@
+package blockchain7
+
+import (
+	"math/big"
+	"sync"
+	"time"
+)
+
+const hashRateWindow = time.Minute //按最近一分钟的哈希次数计算算力
+const hashRateSampleInterval = 5 * time.Second
+const networkHashRateBlocks = 120 //按最近多少个区块估算全网算力
+
+// hashSample 某一时刻累计的哈希次数
+type hashSample struct {
+	time   time.Time
+	hashes int64
+}
+
+// MiningStats 本节点的挖矿统计：算力和每个区块的哈希次数
+type MiningStats struct {
+	mtx           sync.Mutex
+	hashes        int64        //累计计算的哈希次数
+	samples       []hashSample //最近hashRateWindow内的采样，用于计算算力
+	blocksMined   int          //挖出的区块数
+	lastAttempts  int64        //挖出最近一个区块计算的哈希次数
+	totalAttempts int64        //挖出的所有区块计算的哈希次数
+}
+
+var miningStats = &MiningStats{}
+
+// addHashes 累加哈希次数，每隔hashRateSampleInterval记录一次采样
+func (ms *MiningStats) addHashes(n int64) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	ms.hashes += n
+	now := time.Now()
+	if len(ms.samples) == 0 || now.Sub(ms.samples[len(ms.samples)-1].time) >= hashRateSampleInterval {
+		ms.samples = append(ms.samples, hashSample{now, ms.hashes})
+	}
+	ms.expire(now)
+}
+
+// expire 删除超出统计窗口的采样
+func (ms *MiningStats) expire(now time.Time) {
+	i := 0
+	for i < len(ms.samples) && now.Sub(ms.samples[i].time) > hashRateWindow {
+		i++
+	}
+	ms.samples = ms.samples[i:]
+}
+
+// blockMined 挖出一个区块，attempts为挖这个区块计算的哈希次数
+func (ms *MiningStats) blockMined(attempts int64) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	ms.blocksMined++
+	ms.lastAttempts = attempts
+	ms.totalAttempts += attempts
+}
+
+// HashRate 最近一分钟的算力（哈希/秒），没有在挖矿时为0
+func (ms *MiningStats) HashRate() float64 {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	now := time.Now()
+	ms.expire(now)
+	if len(ms.samples) == 0 {
+		return 0
+	}
+
+	elapsed := now.Sub(ms.samples[0].time).Seconds()
+	if elapsed < 1 {
+		return 0
+	}
+
+	return float64(ms.hashes-ms.samples[0].hashes) / elapsed
+}
+
+// MiningInfo getmininginfo命令返回的挖矿信息
+type MiningInfo struct {
+	Consensus           string //共识算法
+	Height              int
+	TargetBits          int
+	Difficulty          float64 //区块的权重：工作量证明为挖出一个区块平均需要计算的哈希次数
+	Mining              bool    //本节点是否在挖矿
+	Workers             int     //挖矿协程数量
+	HashRate            float64 //本节点最近一分钟的算力（哈希/秒）
+	BlocksMined         int     //本节点启动后挖出的区块数
+	LastAttempts        int64   //挖出最近一个区块计算的哈希次数
+	AvgAttempts         float64 //平均每个区块计算的哈希次数
+	NetworkHashRate     float64 //按最近的区块估算的全网算力（哈希/秒）
+	AvgBlockInterval    float64 //最近的区块的平均出块间隔（秒）
+	TargetBlockInterval float64 //目标出块间隔（秒），没有在挖矿时为0
+}
+
+// getMiningInfo 汇总本节点的挖矿统计和全网算力估算
+func getMiningInfo(bc *Blockchain) MiningInfo {
+	last := bc.GetLastBlock()
+	info := MiningInfo{
+		Height:     last.Height,
+		TargetBits: targetBits,
+		Consensus:  consensus.Name(),
+		Difficulty: blockWork(&last),
+		HashRate:   miningStats.HashRate(),
+	}
+	info.NetworkHashRate, info.AvgBlockInterval = estimateNetworkHashRate(bc, networkHashRateBlocks)
+	if miner != nil {
+		info.Mining = true
+		info.Workers = miner.workers
+		info.TargetBlockInterval = miner.interval.Seconds()
+	}
+
+	miningStats.mtx.Lock()
+	info.BlocksMined = miningStats.blocksMined
+	info.LastAttempts = miningStats.lastAttempts
+	if miningStats.blocksMined > 0 {
+		info.AvgAttempts = float64(miningStats.totalAttempts) / float64(miningStats.blocksMined)
+	}
+	miningStats.mtx.Unlock()
+
+	return info
+}
+
+// blockWork 共识引擎给出的区块权重
+func blockWork(block *Block) float64 {
+	header := block.Header()
+	weight, _ := new(big.Float).SetInt(consensus.Weight(&header)).Float64()
+
+	return weight
+}
+
+// estimateNetworkHashRate 按最近blocks个区块的时间戳估算全网算力和平均出块间隔
+// 这些区块的总工作量除以它们花费的时间即为全网算力，区块太少或时间跨度为0时返回0
+func estimateNetworkHashRate(bc *Blockchain, blocks int) (float64, float64) {
+	bci := bc.Iterator()
+	newest := bci.Next()
+	oldest := newest
+	count := 0
+	for count < blocks && len(oldest.PrevBlockHash) > 0 {
+		oldest = bci.Next()
+		count++
+	}
+
+	elapsed := float64(newest.Timestamp - oldest.Timestamp)
+	if count == 0 || elapsed <= 0 {
+		return 0, 0
+	}
+
+	return float64(count) * blockWork(newest) / elapsed, elapsed / float64(count)
+}
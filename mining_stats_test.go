@@ -0,0 +1,136 @@
+package blockchain7
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestMiningStats 测试期间使用新的全局挖矿统计
+func newTestMiningStats(t *testing.T) {
+	old := miningStats
+	miningStats = &MiningStats{}
+	t.Cleanup(func() { miningStats = old })
+}
+
+// newTimedTestBlocks 在tip之后连接时间戳依次为timestamps的区块
+func newTimedTestBlocks(t *testing.T, bc *Blockchain, wallet *Wallet, timestamps ...int64) {
+	for _, timestamp := range timestamps {
+		last := bc.GetLastBlock()
+		block := newTestBlock(t, &last, wallet, "timed")
+		block.Timestamp = timestamp
+		if err := consensus.Seal(context.Background(), block, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := connectTestBlocks(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHashRate(t *testing.T) {
+	ms := &MiningStats{}
+	assert.Zero(t, ms.HashRate(), "not mining")
+
+	//窗口内最早的采样之后计算了3000次哈希，用时约30秒
+	now := time.Now()
+	ms.hashes = 3000
+	ms.samples = []hashSample{{now.Add(-2 * hashRateWindow), 0}, {now.Add(-30 * time.Second), 0}}
+	assert.InDelta(t, 100, ms.HashRate(), 1)
+	assert.Len(t, ms.samples, 1, "samples outside the window are dropped")
+
+	//不足一秒的采样不计算算力
+	ms.samples = []hashSample{{now, 3000}}
+	assert.Zero(t, ms.HashRate())
+
+	//停止挖矿一个窗口之后算力为0
+	ms.samples = []hashSample{{now.Add(-2 * hashRateWindow), 0}}
+	assert.Zero(t, ms.HashRate())
+}
+
+func TestAddHashesSamplesAtInterval(t *testing.T) {
+	ms := &MiningStats{}
+	ms.addHashes(10)
+	ms.addHashes(20)
+	assert.Equal(t, int64(30), ms.hashes)
+	assert.Equal(t, []int64{10}, sampleHashes(ms), "second call is within the sample interval")
+
+	ms.samples[0].time = ms.samples[0].time.Add(-hashRateSampleInterval)
+	ms.addHashes(5)
+	assert.Equal(t, []int64{10, 35}, sampleHashes(ms))
+}
+
+// sampleHashes 各个采样累计的哈希次数
+func sampleHashes(ms *MiningStats) []int64 {
+	var hashes []int64
+	for _, s := range ms.samples {
+		hashes = append(hashes, s.hashes)
+	}
+
+	return hashes
+}
+
+func TestMinedBlocksAreCounted(t *testing.T) {
+	newTestMiningStats(t)
+	setMaxNonce(t, 2*(testPoWNonce-5000))
+
+	//挖矿协程计算的哈希次数计入节点的统计
+	pow := newTestPoW()
+	_, _, err := pow.search(context.Background(), 2, testMerkleRoot)
+	assert.NoError(t, err)
+	assert.Equal(t, pow.Attempts(), miningStats.hashes)
+
+	miningStats.blockMined(100)
+	miningStats.blockMined(300)
+	assert.Equal(t, 2, miningStats.blocksMined)
+	assert.Equal(t, int64(300), miningStats.lastAttempts)
+	assert.Equal(t, int64(400), miningStats.totalAttempts)
+}
+
+func TestEstimateNetworkHashRate(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	rate, interval := estimateNetworkHashRate(bc, networkHashRateBlocks)
+	assert.Zero(t, rate, "genesis only")
+	assert.Zero(t, interval)
+
+	genesis := bc.GetLastBlock().Timestamp
+	newTimedTestBlocks(t, bc, wallet, genesis+10, genesis+20, genesis+60)
+
+	//PoA区块的权重为1
+	rate, interval = estimateNetworkHashRate(bc, networkHashRateBlocks)
+	assert.InDelta(t, 3.0/60, rate, 1e-9)
+	assert.InDelta(t, 20, interval, 1e-9)
+
+	rate, interval = estimateNetworkHashRate(bc, 2)
+	assert.InDelta(t, 2.0/50, rate, 1e-9)
+	assert.InDelta(t, 25, interval, 1e-9)
+}
+
+func TestGetMiningInfo(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	newTestMiningStats(t)
+	genesis := bc.GetLastBlock().Timestamp
+	newTimedTestBlocks(t, bc, wallet, genesis+10, genesis+20)
+
+	info := getMiningInfo(bc)
+	assert.Equal(t, "PoA", info.Consensus)
+	assert.Equal(t, 2, info.Height)
+	assert.Equal(t, float64(1), info.Difficulty)
+	assert.InDelta(t, 10, info.AvgBlockInterval, 1e-9)
+	assert.False(t, info.Mining)
+	assert.Zero(t, info.BlocksMined)
+	assert.Zero(t, info.AvgAttempts)
+
+	newTestMiner(t, bc, wallet, 5*time.Second)
+	miningStats.blockMined(100)
+	miningStats.blockMined(300)
+	info = getMiningInfo(bc)
+	assert.True(t, info.Mining)
+	assert.Equal(t, 1, info.Workers)
+	assert.Equal(t, float64(5), info.TargetBlockInterval)
+	assert.Equal(t, 2, info.BlocksMined)
+	assert.Equal(t, int64(300), info.LastAttempts)
+	assert.Equal(t, float64(200), info.AvgAttempts)
+}
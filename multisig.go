@@ -0,0 +1,249 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const multisigVersion = byte(0x32) //多重签名地址的版本，地址中包含完整的锁定脚本
+const maxMultiSigKeys = 16         //多重签名地址的最大公钥数，M和N都用单字节的操作码表示
+
+var errNotMultiSigSigner = errors.New("钱包不是交易输入的多重签名者")
+
+// NewMultiSigScript 创建M-of-N多重签名锁定脚本：M <pubKey1> ... <pubKeyN> N OP_CHECKMULTISIG
+// 花费时需要其中任意M个公钥对应私钥的签名，签名按公钥的顺序排列
+func NewMultiSigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	n := len(pubKeys)
+	if n == 0 || n > maxMultiSigKeys {
+		return nil, fmt.Errorf("公钥数必须在1到%d之间", maxMultiSigKeys)
+	}
+	if m < 1 || m > n {
+		return nil, fmt.Errorf("签名数必须在1到%d之间", n)
+	}
+
+	b := &ScriptBuilder{}
+	b.AddInt(int64(m))
+	for _, pubKey := range pubKeys {
+		b.AddData(pubKey)
+	}
+	b.AddInt(int64(n)).AddOp(opCheckMultiSig)
+
+	return b.Script(), nil
+}
+
+// extractMultiSig 锁定脚本是多重签名脚本时返回需要的签名数和全部公钥，否则返回0和nil
+func extractMultiSig(script []byte) (int, [][]byte) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 || ops[len(ops)-1].Opcode != opCheckMultiSig {
+		return 0, nil
+	}
+
+	m := smallInt(ops[0].Opcode)
+	n := smallInt(ops[len(ops)-2].Opcode)
+	if m < 1 || n < m || len(ops) != n+3 {
+		return 0, nil
+	}
+
+	var pubKeys [][]byte
+	for _, op := range ops[1 : n+1] {
+		if op.Opcode == op0 || op.Opcode > opPushData2 {
+			return 0, nil
+		}
+		pubKeys = append(pubKeys, op.Data)
+	}
+
+	return m, pubKeys
+}
+
+// smallInt 返回op1到op16表示的数字，其他操作码返回0
+func smallInt(opcode byte) int {
+	if opcode < op1 || opcode > op16 {
+		return 0
+	}
+
+	return int(opcode-op1) + 1
+}
+
+// MultiSigAddress 多重签名地址：版本multisigVersion加上完整的锁定脚本，付款人从地址中得到锁定脚本
+func MultiSigAddress(script []byte) []byte {
+	versionedPayload := append([]byte{multisigVersion}, script...)
+	fullPayload := append(versionedPayload, checksum(versionedPayload)...)
+
+	return Base58Encode(fullPayload)
+}
+
+// PartiallySignedTx 尚未收集齐签名的多重签名交易，以文件形式在签名者之间传递
+// 签名者不一定运行同一个节点，PrevOuts中保存每个输入引用的输出，签名者不需要查找区块链就能计算签名哈希
+type PartiallySignedTx struct {
+	Tx            Transaction
+	PrevOuts      []TxOutput //与Tx.Vin一一对应
+	RedeemScripts [][]byte   //引用的输出是P2SH时为多重签名赎回脚本，否则为nil
+}
+
+// NewMultiSigTransaction 创建从script锁定的输出中转账的交易，找零返回原地址，输入尚未签名
+// script为多重签名锁定脚本，或者是P2SH锁定脚本，这时redeemScript为多重签名赎回脚本
+func NewMultiSigTransaction(script, redeemScript []byte, to string, amount, fee int, view *UTXOView) *PartiallySignedTx {
+	var inputs []TxInput
+	var prevOuts []TxOutput
+	var redeemScripts [][]byte
+	var outputs []TxOutput
+
+	acc := 0
+	for _, u := range view.FindScriptOutputs(script) {
+		if acc >= amount+fee {
+			break
+		}
+		acc += u.Output.Value
+		inputs = append(inputs, TxInput{u.TxID, u.Vout, nil, maxReplaceableSequence})
+		prevOuts = append(prevOuts, u.Output)
+		redeemScripts = append(redeemScripts, redeemScript)
+	}
+	if acc < amount+fee {
+		log.Panic("ERROR:没有足够的钱。")
+	}
+
+	outputs = append(outputs, *NewTxOutput(amount, to))
+	if acc > amount+fee {
+		outputs = append(outputs, TxOutput{acc - amount - fee, script}) //找零，退回多重签名地址
+	}
+
+	tx := Transaction{nil, inputs, outputs, time.Now().Unix(), 0}
+	tx.ID = tx.Hash()
+
+	return &PartiallySignedTx{tx, prevOuts, redeemScripts}
+}
+
+// Sign 用钱包的私钥对所有以钱包公钥为多重签名者之一的输入签名，返回新增的签名数
+// 解锁脚本中的签名按公钥的顺序排列，已经有M个签名的输入不再签名
+func (p *PartiallySignedTx) Sign(wallet *Wallet) (int, error) {
+	added := 0
+	for inID := range p.Tx.Vin {
+		script := p.signScript(inID)
+		m, pubKeys := extractMultiSig(script)
+		if m == 0 {
+			continue
+		}
+		signatures := p.signatures(inID, pubKeys)
+		if countSignatures(signatures) >= m {
+			continue
+		}
+
+		for i, pubKey := range pubKeys {
+			if bytes.Equal(pubKey, wallet.PublicKey) && signatures[i] == nil {
+				hash := p.Tx.signatureHash(inID, script)
+				signatures[i] = signHash(wallet.PrivateKey, hash)
+				added++
+			}
+		}
+
+		b := &ScriptBuilder{}
+		for _, signature := range signatures {
+			if signature != nil {
+				b.AddData(signature)
+			}
+		}
+		if !bytes.Equal(script, p.PrevOuts[inID].ScriptPubKey) { //P2SH：最后压入赎回脚本
+			b.AddData(script)
+		}
+		p.Tx.Vin[inID].ScriptSig = b.Script()
+	}
+	if added == 0 {
+		return 0, errNotMultiSigSigner
+	}
+
+	return added, nil
+}
+
+// signScript 第inID个输入签名时使用的脚本：引用的输出是P2SH并且赎回脚本的哈希匹配时为赎回脚本，否则为输出的锁定脚本
+func (p *PartiallySignedTx) signScript(inID int) []byte {
+	script := p.PrevOuts[inID].ScriptPubKey
+	if inID >= len(p.RedeemScripts) || p.RedeemScripts[inID] == nil {
+		return script
+	}
+	if !bytes.Equal(extractP2SH(script), HashPubKey(p.RedeemScripts[inID])) {
+		return script
+	}
+
+	return p.RedeemScripts[inID]
+}
+
+// signatures 取出输入解锁脚本中已有的签名，按对应公钥的位置排列，没有签名的位置为nil
+func (p *PartiallySignedTx) signatures(inID int, pubKeys [][]byte) [][]byte {
+	signatures := make([][]byte, len(pubKeys))
+	ops, err := parseScript(p.Tx.Vin[inID].ScriptSig)
+	if err != nil {
+		return signatures
+	}
+
+	hash := p.Tx.signatureHash(inID, p.signScript(inID))
+	for _, op := range ops {
+		for i, pubKey := range pubKeys {
+			if signatures[i] == nil && verifyHashSignature(pubKey, hash, op.Data) {
+				signatures[i] = op.Data
+				break
+			}
+		}
+	}
+
+	return signatures
+}
+
+// countSignatures 统计已有的签名数
+func countSignatures(signatures [][]byte) int {
+	n := 0
+	for _, signature := range signatures {
+		if signature != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Complete 所有输入的解锁脚本都能解锁引用的输出时，交易可以广播
+func (p *PartiallySignedTx) Complete() bool {
+	for inID, vin := range p.Tx.Vin {
+		if verifyScript(vin.ScriptSig, p.PrevOuts[inID].ScriptPubKey, &p.Tx, inID) != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Serialize 序列化为十六进制文本，便于复制或保存到文件
+func (p *PartiallySignedTx) Serialize() string {
+	var buff bytes.Buffer
+
+	err := gob.NewEncoder(&buff).Encode(p)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return hex.EncodeToString(buff.Bytes())
+}
+
+// DeserializePartiallySignedTx 反序列化十六进制文本表示的部分签名交易
+func DeserializePartiallySignedTx(data string) (*PartiallySignedTx, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var p PartiallySignedTx
+	err = gob.NewDecoder(bytes.NewReader(raw)).Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.PrevOuts) != len(p.Tx.Vin) {
+		return nil, errors.New("引用的输出与交易输入的数目不一致")
+	}
+
+	return &p, nil
+}
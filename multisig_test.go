@@ -0,0 +1,222 @@
+package blockchain7
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMultiSigScript(t *testing.T) {
+	keys := func(n int) [][]byte {
+		var pubKeys [][]byte
+		for i := 0; i < n; i++ {
+			pubKeys = append(pubKeys, bytes.Repeat([]byte{byte(i + 1)}, 64))
+		}
+		return pubKeys
+	}
+
+	cases := []struct {
+		name string
+		m    int
+		n    int
+		ok   bool
+	}{
+		{"1-of-1", 1, 1, true},
+		{"2-of-3", 2, 3, true},
+		{"16-of-16", maxMultiSigKeys, maxMultiSigKeys, true},
+		{"no keys", 1, 0, false},
+		{"too many keys", 1, maxMultiSigKeys + 1, false},
+		{"zero signatures", 0, 3, false},
+		{"more signatures than keys", 4, 3, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			script, err := NewMultiSigScript(c.m, keys(c.n))
+			if !c.ok {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			m, pubKeys := extractMultiSig(script)
+			assert.Equal(t, c.m, m)
+			assert.Equal(t, keys(c.n), pubKeys)
+		})
+	}
+}
+
+func TestExtractMultiSigRejectsOtherScripts(t *testing.T) {
+	pubKey := bytes.Repeat([]byte{1}, 64)
+	cases := []struct {
+		name   string
+		script []byte
+	}{
+		{"p2pkh", NewP2PKHScript(HashPubKey(pubKey))},
+		{"p2sh", NewP2SHScript(HashPubKey(pubKey))},
+		{"n does not match keys", (&ScriptBuilder{}).AddInt(1).AddData(pubKey).AddInt(2).AddOp(opCheckMultiSig).Script()},
+		{"m greater than n", (&ScriptBuilder{}).AddInt(2).AddData(pubKey).AddInt(1).AddOp(opCheckMultiSig).Script()},
+		{"empty key", (&ScriptBuilder{}).AddInt(1).AddData(nil).AddInt(1).AddOp(opCheckMultiSig).Script()},
+		{"truncated", []byte{op1, 64, 1, 2}},
+	}
+
+	for _, c := range cases {
+		m, pubKeys := extractMultiSig(c.script)
+		assert.Equal(t, 0, m, c.name)
+		assert.Nil(t, pubKeys, c.name)
+	}
+}
+
+func TestMultiSigAddress(t *testing.T) {
+	script, err := NewMultiSigScript(2, [][]byte{bytes.Repeat([]byte{1}, 64), bytes.Repeat([]byte{2}, 64), bytes.Repeat([]byte{3}, 64)})
+	assert.NoError(t, err)
+
+	//付款人从多重签名地址中直接得到锁定脚本
+	address := string(MultiSigAddress(script))
+	assert.True(t, ValidateAddress(address))
+	assert.Equal(t, script, NewTxOutput(1, address).ScriptPubKey)
+
+	//版本是多重签名地址，内容却不是多重签名脚本
+	notMultiSig := addressWithVersion(multisigVersion, NewP2PKHScript(bytes.Repeat([]byte{1}, 20)))
+	assert.False(t, ValidateAddress(notMultiSig))
+}
+
+func TestPartiallySignedTxSign(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	var pubKeys [][]byte
+	for _, w := range wallets {
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+	script, err := NewMultiSigScript(2, pubKeys)
+	assert.NoError(t, err)
+
+	//签名者的先后顺序任意，解锁脚本中的签名总是按公钥的顺序排列
+	cases := []struct {
+		name   string
+		order  []int
+		signed int
+	}{
+		{"in key order", []int{0, 1}, 2},
+		{"reverse key order", []int{2, 0}, 2},
+		{"last two keys", []int{2, 1}, 2},
+		{"threshold not met", []int{1}, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &PartiallySignedTx{*newSpendingTx(maxReplaceableSequence, 0), []TxOutput{{10, script}}, [][]byte{nil}}
+			for _, i := range c.order {
+				added, err := p.Sign(wallets[i])
+				assert.NoError(t, err)
+				assert.Equal(t, 1, added)
+
+				//在文件中传递给下一个签名者
+				p, err = DeserializePartiallySignedTx(p.Serialize())
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, c.signed, countSignatures(p.signatures(0, pubKeys)))
+			assert.Equal(t, c.signed >= 2, p.Complete())
+		})
+	}
+}
+
+func TestPartiallySignedTxSignRejects(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	script, err := NewMultiSigScript(2, [][]byte{wallets[0].PublicKey, wallets[1].PublicKey, wallets[2].PublicKey})
+	assert.NoError(t, err)
+	p := &PartiallySignedTx{*newSpendingTx(maxReplaceableSequence, 0), []TxOutput{{10, script}}, [][]byte{nil}}
+
+	_, err = p.Sign(NewWallet())
+	assert.ErrorIs(t, err, errNotMultiSigSigner, "outsider")
+
+	_, err = p.Sign(wallets[0])
+	assert.NoError(t, err)
+	_, err = p.Sign(wallets[0])
+	assert.ErrorIs(t, err, errNotMultiSigSigner, "signed twice")
+
+	_, err = p.Sign(wallets[1])
+	assert.NoError(t, err)
+	_, err = p.Sign(wallets[2])
+	assert.ErrorIs(t, err, errNotMultiSigSigner, "already complete")
+	assert.True(t, p.Complete())
+}
+
+func TestPartiallySignedTxP2SH(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	redeemScript, err := NewMultiSigScript(2, [][]byte{wallets[0].PublicKey, wallets[1].PublicKey, wallets[2].PublicKey})
+	assert.NoError(t, err)
+
+	//付款人只知道P2SH地址，输出锁定到赎回脚本的哈希
+	address := ScriptHashAddress(redeemScript)
+	assert.True(t, ValidateAddress(string(address)))
+	out := NewTxOutput(10, string(address))
+	assert.Equal(t, NewP2SHScript(HashPubKey(redeemScript)), out.ScriptPubKey)
+	assert.Nil(t, out.PubKeyHash())
+
+	otherScript, err := NewMultiSigScript(1, [][]byte{wallets[0].PublicKey, wallets[2].PublicKey})
+	assert.NoError(t, err)
+
+	cases := []struct {
+		name         string
+		redeemScript []byte
+		complete     bool
+	}{
+		{"matching redeem script", redeemScript, true},
+		{"unknown redeem script", nil, false},
+		{"redeem script of another address", otherScript, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &PartiallySignedTx{*newSpendingTx(maxReplaceableSequence, 0), []TxOutput{*out}, [][]byte{c.redeemScript}}
+			_, err0 := p.Sign(wallets[0])
+			_, err2 := p.Sign(wallets[2])
+			if !c.complete {
+				assert.ErrorIs(t, err0, errNotMultiSigSigner)
+				assert.ErrorIs(t, err2, errNotMultiSigSigner)
+				assert.False(t, p.Complete())
+				return
+			}
+			assert.NoError(t, err0)
+			assert.NoError(t, err2)
+			assert.True(t, p.Complete())
+
+			//解锁脚本最后压入赎回脚本
+			ops, err := parseScript(p.Tx.Vin[0].ScriptSig)
+			assert.NoError(t, err)
+			assert.Len(t, ops, 3)
+			assert.Equal(t, redeemScript, ops[2].Data)
+		})
+	}
+}
+
+func TestBumpedP2SHPaymentStaysRedeemable(t *testing.T) {
+	_, wallet := newTestChain(t)
+	peer := newTestPeer(t)
+	newTestBanManager(t, peer.addr)
+	signers := []*Wallet{NewWallet(), NewWallet()}
+	redeemScript, err := NewMultiSigScript(2, [][]byte{signers[0].PublicKey, signers[1].PublicKey})
+	assert.NoError(t, err)
+	p2sh := NewTxOutput(0, string(ScriptHashAddress(redeemScript))).ScriptPubKey
+
+	//提高手续费后P2SH输出仍然锁定到赎回脚本的哈希，而不是由地址推导出的P2PKH脚本
+	payments := []TxOutput{*NewTxOutput(6, string(ScriptHashAddress(redeemScript)))}
+	original := NewPaymentTransaction(wallet, payments, 1, 0, 0, mempool.View())
+	assert.NoError(t, mempool.Add(original))
+	replacement := replaceTx(mempool, original, wallet, replacementPayments(original, wallet), 2)
+	assert.Equal(t, p2sh, replacement.Vout[0].ScriptPubKey)
+	var msg tx
+	peer.expect(t, "tx", &msg)
+
+	//赎回脚本的签名者可以花费替换交易的P2SH输出
+	spend := NewMultiSigTransaction(p2sh, redeemScript, string(wallet.GetAddress()), 5, 1, mempool.View())
+	assert.Equal(t, replacement.ID, spend.Tx.Vin[0].Txid)
+	for _, signer := range signers {
+		_, err := spend.Sign(signer)
+		assert.NoError(t, err)
+	}
+	assert.True(t, spend.Complete())
+	assert.NoError(t, mempool.Add(&spend.Tx))
+}
@@ -0,0 +1,186 @@
+package blockchain7
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const maxOrphanTxs = 100                //孤儿交易池最多保存的交易数量
+const maxOrphanTxSize = 100 * 1024      //孤儿交易的最大字节数，更大的交易直接拒绝
+const orphanTxExpiry = 20 * time.Minute //孤儿交易在孤儿交易池中的最长停留时间
+
+// ErrTxOrphan 交易引用的父交易尚未收到，交易已放入孤儿交易池等待父交易
+var ErrTxOrphan = errors.New("交易的父交易尚未收到，已放入孤儿交易池")
+
+// orphanTx 孤儿交易：引用了本节点还不知道的交易的输出
+// 父交易和子交易在网络中可能乱序到达，先到的子交易暂存在这里，父交易进入交易池或区块后再重新处理
+type orphanTx struct {
+	tx      *Transaction
+	missing []string //缺失的父交易输出（outpoint）
+	added   time.Time
+}
+
+// ProcessTransaction 处理一个新交易：验证并加入交易池
+// 如果交易引用的父交易尚未收到，放入孤儿交易池并返回ErrTxOrphan
+// 返回因此而进入交易池的所有交易，包括等待该交易的孤儿交易，调用者应将它们转发给其他节点
+func (mp *Mempool) ProcessTransaction(tx *Transaction) ([]*Transaction, error) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	err := mp.add(tx, time.Now())
+	if errors.Is(err, ErrTxMissingInputs) {
+		return nil, mp.addOrphan(tx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	accepted := []*Transaction{tx}
+	accepted = append(accepted, mp.processOrphans(tx)...)
+
+	return accepted, nil
+}
+
+// MissingParents 返回孤儿交易缺失的父交易ID，可以据此向其他节点请求父交易
+func (mp *Mempool) MissingParents(tx *Transaction) [][]byte {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	var parents [][]byte
+	seen := make(map[string]bool)
+	for _, vin := range tx.Vin {
+		key := hex.EncodeToString(vin.Txid)
+		if seen[key] || mp.txs[key] != nil || !mp.isMissing(vin.Txid, vin.Vout) {
+			continue
+		}
+		seen[key] = true
+		parents = append(parents, vin.Txid)
+	}
+
+	return parents
+}
+
+// isMissing 检查一个输入引用的输出是否既不在UTXO集中，也不是交易池中交易的输出，调用者需持有锁
+func (mp *Mempool) isMissing(txID []byte, vout int) bool {
+	_, ok := mp.findOutput(txID, vout)
+
+	return !ok
+}
+
+// addOrphan 将交易放入孤儿交易池，调用者需持有锁
+// 如果父交易已经确认或在交易池中、只是被引用的输出不存在或已经花费，那么这不是孤儿交易，直接拒绝
+func (mp *Mempool) addOrphan(tx *Transaction) error {
+	txID := hex.EncodeToString(tx.ID)
+	if mp.orphans[txID] != nil {
+		return ErrTxAlreadyKnown
+	}
+	if len(tx.Serialize()) > maxOrphanTxSize {
+		return fmt.Errorf("孤儿交易 %s 过大", txID)
+	}
+
+	var missing []string
+	UTXOSet := UTXOSet{mp.bc}
+	for _, vin := range tx.Vin {
+		op := outpoint(vin.Txid, vin.Vout)
+		if !mp.isMissing(vin.Txid, vin.Vout) {
+			continue
+		}
+		if _, ok := UTXOSet.FindOutputs(vin.Txid); ok || mp.txs[hex.EncodeToString(vin.Txid)] != nil {
+			return fmt.Errorf("%w: %s 已经花费", ErrTxMissingInputs, op)
+		}
+		missing = append(missing, op)
+	}
+	if len(missing) == 0 {
+		return ErrTxMissingInputs
+	}
+
+	mp.orphans[txID] = &orphanTx{tx, missing, time.Now()}
+	for _, op := range missing {
+		if mp.orphansByPrev[op] == nil {
+			mp.orphansByPrev[op] = make(map[string]bool)
+		}
+		mp.orphansByPrev[op][txID] = true
+	}
+
+	//孤儿交易池已满时随机淘汰一个（map的迭代顺序是随机的）
+	for id := range mp.orphans {
+		if len(mp.orphans) <= maxOrphanTxs {
+			break
+		}
+		if id != txID {
+			mp.removeOrphan(id)
+		}
+	}
+
+	return ErrTxOrphan
+}
+
+// removeOrphan 从孤儿交易池中删除交易，调用者需持有锁
+func (mp *Mempool) removeOrphan(txID string) {
+	orphan := mp.orphans[txID]
+	if orphan == nil {
+		return
+	}
+
+	for _, op := range orphan.missing {
+		delete(mp.orphansByPrev[op], txID)
+		if len(mp.orphansByPrev[op]) == 0 {
+			delete(mp.orphansByPrev, op)
+		}
+	}
+	delete(mp.orphans, txID)
+}
+
+// processOrphans 父交易进入交易池或区块后，重新处理等待它的输出的孤儿交易，调用者需持有锁
+// 被接受的孤儿交易又可能是其他孤儿交易的父交易，所以逐层处理下去
+func (mp *Mempool) processOrphans(parent *Transaction) []*Transaction {
+	var accepted []*Transaction
+	queue := []*Transaction{parent}
+
+	for len(queue) > 0 {
+		tx := queue[0]
+		queue = queue[1:]
+
+		for i := range tx.Vout {
+			for orphanID := range mp.orphansByPrev[outpoint(tx.ID, i)] {
+				orphan := mp.orphans[orphanID]
+
+				err := mp.add(orphan.tx, time.Now())
+				if errors.Is(err, ErrTxMissingInputs) { //还缺少其他父交易，继续等待
+					continue
+				}
+
+				mp.removeOrphan(orphanID)
+				if err != nil {
+					fmt.Printf("丢弃孤儿交易 %s: %s\n", orphanID, err)
+					continue
+				}
+				fmt.Printf("孤儿交易 %s 进入交易池\n", orphanID)
+				accepted = append(accepted, orphan.tx)
+				queue = append(queue, orphan.tx)
+			}
+		}
+	}
+
+	return accepted
+}
+
+// expireOrphans 删除在孤儿交易池中停留过久的交易，调用者需持有锁
+func (mp *Mempool) expireOrphans() {
+	deadline := time.Now().Add(-orphanTxExpiry)
+	for txID, orphan := range mp.orphans {
+		if orphan.added.Before(deadline) {
+			mp.removeOrphan(txID)
+		}
+	}
+}
+
+// OrphanCount 返回孤儿交易池中交易的数量
+func (mp *Mempool) OrphanCount() int {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return len(mp.orphans)
+}
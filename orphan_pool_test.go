@@ -0,0 +1,107 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newOrphanTx 创建引用未知交易parent的输出的交易，交易缺少父交易，验证签名之前就会被当作孤儿交易
+func newOrphanTx(wallet *Wallet, parent []byte, data []byte) *Transaction {
+	tx := &Transaction{nil, []TxInput{{parent, 0, data, maxReplaceableSequence}}, []TxOutput{*NewTxOutput(1, string(wallet.GetAddress()))}, time.Now().Unix(), 0}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+func TestOrphanChainArrivesOutOfOrder(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	//A <- B <- C，按C、B、A的顺序到达
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 5, 4)
+	pending := map[string]*Transaction{hex.EncodeToString(a.ID): a}
+	b := newTestTx(bc, wallet, pending, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 4)
+	pending[hex.EncodeToString(b.ID)] = b
+	c := newTestTx(bc, wallet, pending, []TxInput{{b.ID, 0, nil, maxReplaceableSequence}}, 3)
+
+	mp := NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+	_, err := mp.ProcessTransaction(c)
+	assert.ErrorIs(t, err, ErrTxOrphan)
+	assert.Equal(t, [][]byte{b.ID}, mp.MissingParents(c))
+	_, err = mp.ProcessTransaction(b)
+	assert.ErrorIs(t, err, ErrTxOrphan)
+	assert.Equal(t, 2, mp.OrphanCount())
+	assert.Equal(t, 0, mp.Count())
+
+	//A到达后，等待它的B以及等待B的C依次进入交易池
+	accepted, err := mp.ProcessTransaction(a)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Transaction{a, b, c}, accepted)
+	assert.Equal(t, 3, mp.Count())
+	assert.Equal(t, 0, mp.OrphanCount())
+}
+
+func TestOrphanParentInBlock(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	cb := genesis.Transactions[0]
+
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 9)
+	b := newTestTx(bc, wallet, map[string]*Transaction{hex.EncodeToString(a.ID): a}, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 8)
+	_, err := mempool.ProcessTransaction(b)
+	assert.ErrorIs(t, err, ErrTxOrphan)
+
+	//父交易直接出现在区块中
+	assert.NoError(t, connectTestBlocks(newTestBlock(t, &genesis, wallet, "b1", a)))
+	assert.True(t, mempool.Has(b.ID))
+	assert.Equal(t, 0, mempool.OrphanCount())
+}
+
+func TestOrphanRejectsSpentOrMissingOutputOfKnownTx(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 9)
+	mp := NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+	assert.NoError(t, mp.Add(a))
+
+	//父交易已知，只是引用的输出不存在，不是孤儿交易
+	for _, parent := range []*Transaction{cb, a} {
+		tx := newOrphanTx(wallet, parent.ID, nil)
+		tx.Vin[0].Vout = 5
+		tx.ID = tx.Hash()
+		_, err := mp.ProcessTransaction(tx)
+		assert.ErrorIs(t, err, ErrTxMissingInputs)
+		assert.NotErrorIs(t, err, ErrTxOrphan)
+	}
+	assert.Equal(t, 0, mp.OrphanCount())
+}
+
+func TestOrphanPoolLimits(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	mp := NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+
+	for i := 0; i < maxOrphanTxs+10; i++ {
+		_, err := mp.ProcessTransaction(newOrphanTx(wallet, []byte(fmt.Sprintf("parent%d", i)), nil))
+		assert.ErrorIs(t, err, ErrTxOrphan)
+	}
+	assert.Equal(t, maxOrphanTxs, mp.OrphanCount(), "random orphans are evicted")
+
+	large := newOrphanTx(wallet, []byte("large"), bytes.Repeat([]byte{1}, maxOrphanTxSize))
+	_, err := mp.ProcessTransaction(large)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrTxOrphan)
+	assert.Nil(t, mp.orphans[hex.EncodeToString(large.ID)])
+
+	//停留过久的孤儿交易随交易池一起过期
+	for _, orphan := range mp.orphans {
+		orphan.added = time.Now().Add(-orphanTxExpiry - time.Second)
+	}
+	mp.Expire()
+	assert.Equal(t, 0, mp.OrphanCount())
+}
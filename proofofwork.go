@@ -2,16 +2,23 @@ package blockchain7
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	maxNonce = math.MaxInt64 //避免计数溢出，设定计数上限
 )
 
+const miningCheckInterval = 1 << 16 //挖矿协程每计算这么多次哈希检查一次是否需要停止
+
 //挖矿难度系数，哈希值前24个bit为0。
 //不同于比特币会动态调整挖矿难度系数，这里只将难度定义为一个全局的常量。
 const targetBits = 24
@@ -21,8 +28,9 @@ const targetBits = 24
 //但在确定好挖矿难度系数后，所有区块的pow的target是相同的，
 //除非挖矿系数随着时间推移，挖矿难度系数不断增加
 type ProofOfWork struct {
-	block  *Block   //指向区块的指针
-	target *big.Int //必要条件：哈希后的数据转为大整数后，小于target
+	block    *Block   //指向区块的指针
+	target   *big.Int //必要条件：哈希后的数据转为大整数后，小于target
+	attempts int64    //已经计算的哈希次数，多个协程并发累加
 }
 
 // NewProofOfWork 初始化创建一个POW的函数，以block指针为参数（将修改该block）
@@ -47,7 +55,7 @@ func NewProofOfWork(b *Block) *ProofOfWork {
 	//fmt.Printf("%64x",target)结果：0000010000000000000000000000000000000000000000000000000000000000
 	target.Lsh(target, uint(256-targetBits))
 
-	pow := &ProofOfWork{b, target} //初始化创建一个POW
+	pow := &ProofOfWork{block: b, target: target} //初始化创建一个POW
 
 	return pow
 }
@@ -55,11 +63,16 @@ func NewProofOfWork(b *Block) *ProofOfWork {
 // prepareData 准备进行哈希计算的数据，注意，
 //进行哈希的数据除了block结构的数据外，还增加了挖矿难度系数targetBits
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
+	return powData(pow.block.PrevBlockHash, pow.block.HashTransactions(), pow.block.Timestamp, nonce)
+}
+
+//powData 拼接进行哈希计算的数据，区块和区块头共用同一种拼接方式
+func powData(prevBlockHash, merkleRoot []byte, timestamp int64, nonce int) []byte {
 	data := bytes.Join(
 		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
-			IntToHex(pow.block.Timestamp),
+			prevBlockHash,
+			merkleRoot,
+			IntToHex(timestamp),
 			IntToHex(int64(targetBits)),
 			IntToHex(int64(nonce)),
 		},
@@ -69,33 +82,135 @@ func (pow *ProofOfWork) prepareData(nonce int) []byte {
 	return data
 }
 
+// HeaderHash 计算区块头的哈希，外部挖矿程序也用它计算工作量证明
+func HeaderHash(prevBlockHash, merkleRoot []byte, timestamp int64, nonce int) []byte {
+	hash := sha256.Sum256(powData(prevBlockHash, merkleRoot, timestamp, nonce))
+
+	return hash[:]
+}
+
 //Run POW挖矿核心算法实现，注意，这是一个方法，不是函数，
 //因为挖矿的完整描述是：挖出包含某个实际交易信息（或数据）的区块
 //挖矿是为交易上链提供服务，矿工拿到交易信息后进行挖矿，挖出的有效区块将包含交易信息
 //有可能挖不出符合条件的区块，所以将区块上链之前，需要对挖出的区块进行验证（验证是否符合条件）
+//Run是单线程、不可中断的挖矿，需要多线程或中断挖矿时使用Mine
 func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int //存储哈希转成的大数字
-	var hash [32]byte   //数组
-	nonce := 0          //计数器，初始化为0
+	nonce, hash, _ := pow.Mine(context.Background(), 1)
+
+	return nonce, hash
+}
+
+// Mine 用workers个协程并行挖矿，nonce空间平均分给各个协程，任一协程找到有效哈希后其余协程立即停止
+//ctx被取消时（如收到了新区块，正在挖的区块已经过时）停止挖矿，返回ctx.Err()
+//所有协程的nonce空间都用完仍未找到时，更新区块的时间戳，用新的区块头数据重新开始
+func (pow *ProofOfWork) Mine(ctx context.Context, workers int) (int, []byte, error) {
+	if workers < 1 {
+		workers = 1
+	}
 
 	fmt.Printf("正在挖出一个新区块...\n")
-	for nonce < maxNonce { //有可能挖不出符合条件的区块
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
-
-		//hash[:]为创建的一个切片，该切片包含hash数组的所有元素
-		//这里不是直接传递hash数组，而是传递切片（相当于数组的引用），
-		//是为节约内存开销，因为有可能要计算非常多的次数，如果每次都是拷贝数组（数组是值类型），
-		//那么这个算法的内存开销可能很大
-		hashInt.SetBytes(hash[:])
-		if hashInt.Cmp(pow.target) == -1 {
-			//hashInt<pow.target，则挖矿成功，返回区块和有效计数器，不必继续挖
-			break
-		} else {
-			nonce++
+	started := time.Now()
+	merkleRoot := pow.block.HashTransactions() //交易不变，Merkle根只需计算一次
+	for {
+		nonce, hash, err := pow.search(ctx, workers, merkleRoot)
+		if err == nil {
+			attempts, elapsed := pow.Attempts(), time.Since(started)
+			miningStats.blockMined(attempts)
+			fmt.Printf("计算了 %d 次哈希，用时 %s，%.0f 哈希/秒\n", attempts, elapsed.Round(time.Millisecond), float64(attempts)/elapsed.Seconds())
+		}
+		if err != errNonceExhausted {
+			return nonce, hash, err
 		}
+
+		timestamp := time.Now().Unix()
+		if timestamp <= pow.block.Timestamp {
+			timestamp = pow.block.Timestamp + 1
+		}
+		fmt.Printf("nonce已用完，更新时间戳为 %d\n", timestamp)
+		pow.block.Timestamp = timestamp
 	}
-	return nonce, hash[:] //返回切片而不是直接返回数组对象，可重复使用该数组内存。
+}
+
+var errNonceExhausted = errors.New("nonce空间已用完")
+
+//search 在当前的区块头数据下，用workers个协程搜索整个nonce空间
+func (pow *ProofOfWork) search(ctx context.Context, workers int, merkleRoot []byte) (int, []byte, error) {
+	type result struct {
+		nonce int
+		hash  []byte
+	}
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel() //返回时停止所有协程
+
+	found := make(chan result, workers)
+	var wg sync.WaitGroup
+	span := maxNonce / workers
+	for w := 0; w < workers; w++ {
+		start, end := w*span, (w+1)*span
+		if w == workers-1 {
+			end = maxNonce
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+
+			var hashInt big.Int //存储哈希转成的大数字
+			counted := start    //counted之前的nonce已经计入统计
+			for nonce := start; nonce < end; nonce++ {
+				if (nonce-start)%miningCheckInterval == 0 { //每计算一定次数检查一次是否需要停止，并更新统计
+					pow.addAttempts(int64(nonce - counted))
+					counted = nonce
+					select {
+					case <-workerCtx.Done():
+						return
+					default:
+					}
+				}
+
+				hash := sha256.Sum256(powData(pow.block.PrevBlockHash, merkleRoot, pow.block.Timestamp, nonce))
+				hashInt.SetBytes(hash[:])
+				if hashInt.Cmp(pow.target) == -1 { //hashInt<pow.target，则挖矿成功
+					pow.addAttempts(int64(nonce + 1 - counted))
+					found <- result{nonce, hash[:]}
+					cancel()
+					return
+				}
+			}
+			pow.addAttempts(int64(end - counted))
+		}(start, end)
+	}
+
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	r, ok := <-found
+	if ok {
+		cancel()
+		wg.Wait() //等其他协程停止，哈希次数统计完整
+		return r.nonce, r.hash, nil
+	}
+	if ctx.Err() != nil {
+		return 0, nil, ctx.Err()
+	}
+
+	return 0, nil, errNonceExhausted
+}
+
+// addAttempts 累加哈希次数，同时计入节点的挖矿统计
+func (pow *ProofOfWork) addAttempts(n int64) {
+	if n > 0 {
+		atomic.AddInt64(&pow.attempts, n)
+		miningStats.addHashes(n)
+	}
+}
+
+// Attempts 返回挖这个区块已经计算的哈希次数
+func (pow *ProofOfWork) Attempts() int64 {
+	return atomic.LoadInt64(&pow.attempts)
 }
 
 // Validate 验证工作量证明POW
@@ -110,3 +225,50 @@ func (pow *ProofOfWork) Validate() bool {
 
 	return isValid
 }
+
+// PoWEngine 工作量证明共识引擎
+type PoWEngine struct{}
+
+// Name 共识算法的名称
+func (e *PoWEngine) Name() string {
+	return "PoW"
+}
+
+// Seal 挖矿：用workers个协程计算满足难度要求的哈希
+func (e *PoWEngine) Seal(ctx context.Context, block *Block, workers int) error {
+	pow := NewProofOfWork(block)               //注意传递block指针作为参数
+	nonce, hash, err := pow.Mine(ctx, workers) //nonce用完时会更新block的时间戳
+	if err != nil {
+		return err
+	}
+
+	//设置block的计数器和哈希
+	block.Nonce = nonce
+	block.Hash = hash[:]
+
+	return nil
+}
+
+// VerifyHeader 仅根据区块头验证工作量证明
+//除了哈希满足难度要求外，还要求区块头中的Hash与计算结果一致
+func (e *PoWEngine) VerifyHeader(h *BlockHeader) bool {
+	var hashInt big.Int
+
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-targetBits))
+
+	hash := sha256.Sum256(powData(h.PrevBlockHash, h.MerkleRoot, h.Timestamp, h.Nonce))
+	if bytes.Compare(hash[:], h.Hash) != 0 {
+		return false
+	}
+	hashInt.SetBytes(hash[:])
+
+	return hashInt.Cmp(target) == -1
+}
+
+// Weight 挖出一个区块平均需要计算的哈希次数：哈希小于目标值的概率为2^-targetBits
+func (e *PoWEngine) Weight(h *BlockHeader) *big.Int {
+	weight := big.NewInt(1)
+
+	return weight.Lsh(weight, targetBits)
+}
@@ -0,0 +1,73 @@
+package blockchain7
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 交易的序列化结果与进程中gob注册类型的顺序有关，这里直接使用固定的Merkle根
+// 前一个区块的哈希为"prev"、时间戳为1时，第一个满足难度要求的nonce为testPoWNonce
+var testMerkleRoot = []byte("root")
+
+const testPoWNonce = 403108
+
+// newTestPoW 区块头数据固定的工作量证明
+func newTestPoW() *ProofOfWork {
+	return NewProofOfWork(&Block{Timestamp: 1, PrevBlockHash: []byte("prev")})
+}
+
+// setMaxNonce 测试期间修改nonce空间的大小
+func setMaxNonce(t *testing.T, n int) {
+	old := maxNonce
+	maxNonce = n
+	t.Cleanup(func() { maxNonce = old })
+}
+
+func TestSearchSplitsNonceSpaceAmongWorkers(t *testing.T) {
+	//第二个协程从testPoWNonce之前不远处开始搜索，很快就能找到，第一个协程随即停止
+	setMaxNonce(t, 2*(testPoWNonce-5000))
+	pow := newTestPoW()
+
+	nonce, hash, err := pow.search(context.Background(), 2, testMerkleRoot)
+	assert.NoError(t, err)
+	assert.Equal(t, testPoWNonce, nonce)
+	assert.GreaterOrEqual(t, pow.Attempts(), int64(5001), "hashes of every worker are counted")
+	assert.Less(t, pow.Attempts(), int64(testPoWNonce), "first worker stops early")
+
+	header := BlockHeader{Timestamp: 1, PrevBlockHash: []byte("prev"), MerkleRoot: testMerkleRoot, Nonce: nonce, Hash: hash}
+	assert.True(t, (&PoWEngine{}).VerifyHeader(&header))
+}
+
+func TestSearchExhaustsNonceSpace(t *testing.T) {
+	setMaxNonce(t, 1000)
+	pow := newTestPoW()
+
+	_, _, err := pow.search(context.Background(), 3, testMerkleRoot)
+	assert.Equal(t, errNonceExhausted, err)
+	assert.Equal(t, int64(1000), pow.Attempts(), "every nonce is tried exactly once")
+}
+
+func TestSearchStopsWhenCancelled(t *testing.T) {
+	setMaxNonce(t, testPoWNonce) //nonce空间中没有满足难度要求的nonce
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	pow := newTestPoW()
+
+	_, _, err := pow.search(ctx, 2, testMerkleRoot)
+	assert.Equal(t, context.Canceled, err)
+	assert.Less(t, pow.Attempts(), int64(testPoWNonce))
+}
+
+func TestMineReturnsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() //协程在计算第一个哈希之前就会停止
+	tx := &Transaction{ID: []byte("tx"), Vout: []TxOutput{{Value: 10}}, Timestamp: 1}
+	block := &Block{Timestamp: 1, Transactions: []*Transaction{tx}, PrevBlockHash: []byte("prev")}
+
+	err := (&PoWEngine{}).Seal(ctx, block, 4)
+	assert.Equal(t, context.Canceled, err)
+	assert.Empty(t, block.Hash)
+}
@@ -0,0 +1,643 @@
+package blockchain7
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 脚本操作码，取值与比特币相同
+const (
+	op0                   = 0x00 //压入空字节数组（false）
+	opPushData1           = 0x4c //后面1个字节为数据长度
+	opPushData2           = 0x4d //后面2个字节（小端）为数据长度
+	op1                   = 0x51 //压入数字1，op1到op16依次压入1到16
+	op16                  = 0x60
+	opIf                  = 0x63
+	opElse                = 0x67
+	opEndIf               = 0x68
+	opVerify              = 0x69
+	opReturn              = 0x6a
+	opDrop                = 0x75
+	opDup                 = 0x76
+	opEqual               = 0x87
+	opEqualVerify         = 0x88
+	opSha256              = 0xa8
+	opHash160             = 0xa9
+	opCheckSig            = 0xac
+	opCheckMultiSig       = 0xae
+	opCheckLockTimeVerify = 0xb1
+)
+
+const maxScriptSize = 10000         //脚本的最大长度
+const maxScriptElementSize = 520    //压入栈的数据的最大长度
+const maxStackSize = 1000           //栈的最大深度
+const maxPubKeysPerMultiSig = 20    //多重签名的最大公钥数
+const lockTimeThreshold = 500000000 //LockTime小于该值时表示区块高度，否则表示Unix时间戳
+
+var opNames = map[byte]string{
+	op0:                   "0",
+	opIf:                  "OP_IF",
+	opElse:                "OP_ELSE",
+	opEndIf:               "OP_ENDIF",
+	opVerify:              "OP_VERIFY",
+	opReturn:              "OP_RETURN",
+	opDrop:                "OP_DROP",
+	opDup:                 "OP_DUP",
+	opEqual:               "OP_EQUAL",
+	opEqualVerify:         "OP_EQUALVERIFY",
+	opSha256:              "OP_SHA256",
+	opHash160:             "OP_HASH160",
+	opCheckSig:            "OP_CHECKSIG",
+	opCheckMultiSig:       "OP_CHECKMULTISIG",
+	opCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+}
+
+// ErrScriptFailed 脚本执行失败：解锁脚本不能解锁输出的锁定脚本
+var ErrScriptFailed = errors.New("脚本执行失败")
+
+// scriptOp 解析后的一条脚本指令，压入数据的指令Data为压入的数据
+type scriptOp struct {
+	Opcode byte
+	Data   []byte
+}
+
+// isPush 指令是否只是压入数据
+func (op scriptOp) isPush() bool {
+	return op.Opcode <= opPushData2 || (op.Opcode >= op1 && op.Opcode <= op16)
+}
+
+// ScriptBuilder 拼接脚本
+type ScriptBuilder struct {
+	script []byte
+}
+
+// AddOp 添加操作码
+func (b *ScriptBuilder) AddOp(opcode byte) *ScriptBuilder {
+	b.script = append(b.script, opcode)
+	return b
+}
+
+// AddData 添加压入数据的指令，按数据长度选择最短的编码
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	switch n := len(data); {
+	case n == 0:
+		b.script = append(b.script, op0)
+	case n < opPushData1:
+		b.script = append(b.script, byte(n))
+	case n <= 0xff:
+		b.script = append(b.script, opPushData1, byte(n))
+	default:
+		b.script = append(b.script, opPushData2, byte(n), byte(n>>8))
+	}
+	b.script = append(b.script, data...)
+
+	return b
+}
+
+// AddInt 添加压入数字的指令，0到16使用单字节的操作码
+func (b *ScriptBuilder) AddInt(n int64) *ScriptBuilder {
+	if n == 0 {
+		return b.AddOp(op0)
+	}
+	if n >= 1 && n <= 16 {
+		return b.AddOp(byte(op1 - 1 + n))
+	}
+
+	return b.AddData(scriptNum(n))
+}
+
+// Script 返回拼接好的脚本
+func (b *ScriptBuilder) Script() []byte {
+	return b.script
+}
+
+// NewP2PKHScript 创建默认的支付到公钥哈希（P2PKH）锁定脚本：
+// OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func NewP2PKHScript(pubKeyHash []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddOp(opDup).AddOp(opHash160).AddData(pubKeyHash).AddOp(opEqualVerify).AddOp(opCheckSig)
+
+	return b.Script()
+}
+
+// NewP2PKHScriptSig 创建花费P2PKH输出的解锁脚本：<signature> <pubKey>
+func NewP2PKHScriptSig(signature, pubKey []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddData(signature).AddData(pubKey)
+
+	return b.Script()
+}
+
+// NewP2SHScript 创建支付到脚本哈希（P2SH）的锁定脚本：OP_HASH160 <scriptHash> OP_EQUAL
+// 花费时解锁脚本的最后一项必须是哈希为scriptHash的赎回脚本，赎回脚本再用解锁脚本的其余数据执行
+func NewP2SHScript(scriptHash []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddOp(opHash160).AddData(scriptHash).AddOp(opEqual)
+
+	return b.Script()
+}
+
+// extractP2SH 锁定脚本是P2SH时返回其中的赎回脚本哈希，否则返回nil
+func extractP2SH(script []byte) []byte {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 3 {
+		return nil
+	}
+	if ops[0].Opcode != opHash160 || len(ops[1].Data) != 20 || ops[2].Opcode != opEqual {
+		return nil
+	}
+
+	return ops[1].Data
+}
+
+// extractP2PKH 锁定脚本是P2PKH时返回其中的公钥哈希，否则返回nil
+func extractP2PKH(script []byte) []byte {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 5 {
+		return nil
+	}
+	if ops[0].Opcode != opDup || ops[1].Opcode != opHash160 || len(ops[2].Data) != 20 ||
+		ops[3].Opcode != opEqualVerify || ops[4].Opcode != opCheckSig {
+		return nil
+	}
+
+	return ops[2].Data
+}
+
+// parseScript 把脚本解析为指令序列
+func parseScript(script []byte) ([]scriptOp, error) {
+	if len(script) > maxScriptSize {
+		return nil, fmt.Errorf("%w: 脚本长度%d超过上限", ErrScriptFailed, len(script))
+	}
+
+	var ops []scriptOp
+	for i := 0; i < len(script); {
+		opcode := script[i]
+		i++
+
+		n := 0
+		switch {
+		case opcode > op0 && opcode < opPushData1:
+			n = int(opcode)
+		case opcode == opPushData1:
+			if i+1 > len(script) {
+				return nil, fmt.Errorf("%w: 脚本被截断", ErrScriptFailed)
+			}
+			n = int(script[i])
+			i++
+		case opcode == opPushData2:
+			if i+2 > len(script) {
+				return nil, fmt.Errorf("%w: 脚本被截断", ErrScriptFailed)
+			}
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		}
+		if i+n > len(script) {
+			return nil, fmt.Errorf("%w: 脚本被截断", ErrScriptFailed)
+		}
+
+		op := scriptOp{Opcode: opcode}
+		if opcode <= opPushData2 {
+			op.Data = script[i : i+n]
+		}
+		ops = append(ops, op)
+		i += n
+	}
+
+	return ops, nil
+}
+
+// DisasmScript 把脚本转为人可读的文本，数据显示为十六进制
+func DisasmScript(script []byte) string {
+	ops, err := parseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[非法脚本 %x]", script)
+	}
+
+	var words []string
+	for _, op := range ops {
+		switch {
+		case op.Opcode >= op1 && op.Opcode <= op16:
+			words = append(words, fmt.Sprintf("%d", op.Opcode-op1+1))
+		case op.Opcode > op0 && op.Opcode <= opPushData2:
+			words = append(words, hex.EncodeToString(op.Data))
+		case opNames[op.Opcode] != "":
+			words = append(words, opNames[op.Opcode])
+		default:
+			words = append(words, fmt.Sprintf("OP_UNKNOWN%d", op.Opcode))
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// scriptNum 把数字编码为脚本中的数字：小端，最高字节的最高位为符号位
+func scriptNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	var result []byte
+	for n > 0 {
+		result = append(result, byte(n&0xff))
+		n >>= 8
+	}
+	if result[len(result)-1]&0x80 != 0 {
+		if negative {
+			result = append(result, 0x80)
+		} else {
+			result = append(result, 0)
+		}
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+// parseScriptNum 解析脚本中的数字，maxLen为允许的最大字节数
+func parseScriptNum(data []byte, maxLen int) (int64, error) {
+	if len(data) > maxLen {
+		return 0, fmt.Errorf("%w: 数字超过%d个字节", ErrScriptFailed, maxLen)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	for i, b := range data {
+		n |= int64(b) << uint(8*i)
+	}
+	if data[len(data)-1]&0x80 != 0 {
+		n &^= int64(0x80) << uint(8*(len(data)-1))
+		return -n, nil
+	}
+
+	return n, nil
+}
+
+// castToBool 栈中的数据作为布尔值：全0（包括负0）为false
+func castToBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+
+	return false
+}
+
+// scriptEngine 执行脚本的栈式虚拟机
+type scriptEngine struct {
+	stack [][]byte
+	tx    *Transaction //花费输出的交易
+	inID  int          //交易中正在验证的输入
+}
+
+func (vm *scriptEngine) push(data []byte) error {
+	if len(vm.stack) >= maxStackSize {
+		return fmt.Errorf("%w: 栈溢出", ErrScriptFailed)
+	}
+	vm.stack = append(vm.stack, data)
+
+	return nil
+}
+
+func (vm *scriptEngine) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, fmt.Errorf("%w: 栈为空", ErrScriptFailed)
+	}
+	data := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+
+	return data, nil
+}
+
+func (vm *scriptEngine) popInt() (int64, error) {
+	data, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	return parseScriptNum(data, 4)
+}
+
+func (vm *scriptEngine) pushBool(v bool) error {
+	if v {
+		return vm.push([]byte{1})
+	}
+
+	return vm.push(nil)
+}
+
+// execute 执行一段脚本，script为锁定脚本时用于计算签名哈希
+// OP_IF/OP_ELSE/OP_ENDIF可以嵌套，conds记录每一层的条件，只有所有层的条件都为true时才执行指令
+func (vm *scriptEngine) execute(script []byte) error {
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	var conds []bool
+	for _, op := range ops {
+		if len(op.Data) > maxScriptElementSize {
+			return fmt.Errorf("%w: 压入的数据超过%d个字节", ErrScriptFailed, maxScriptElementSize)
+		}
+
+		executing := true
+		for _, cond := range conds {
+			executing = executing && cond
+		}
+
+		switch op.Opcode {
+		case opIf:
+			cond := false
+			if executing {
+				data, err := vm.pop()
+				if err != nil {
+					return err
+				}
+				cond = castToBool(data)
+			}
+			conds = append(conds, cond)
+			continue
+		case opElse:
+			if len(conds) == 0 {
+				return fmt.Errorf("%w: OP_ELSE没有对应的OP_IF", ErrScriptFailed)
+			}
+			conds[len(conds)-1] = !conds[len(conds)-1]
+			continue
+		case opEndIf:
+			if len(conds) == 0 {
+				return fmt.Errorf("%w: OP_ENDIF没有对应的OP_IF", ErrScriptFailed)
+			}
+			conds = conds[:len(conds)-1]
+			continue
+		}
+
+		if !executing {
+			continue
+		}
+		if err := vm.step(op, script); err != nil {
+			return err
+		}
+	}
+	if len(conds) != 0 {
+		return fmt.Errorf("%w: OP_IF没有对应的OP_ENDIF", ErrScriptFailed)
+	}
+
+	return nil
+}
+
+// step 执行一条指令
+func (vm *scriptEngine) step(op scriptOp, script []byte) error {
+	switch {
+	case op.Opcode == op0:
+		return vm.push(nil)
+	case op.Opcode <= opPushData2:
+		return vm.push(op.Data)
+	case op.Opcode >= op1 && op.Opcode <= op16:
+		return vm.push(scriptNum(int64(op.Opcode - op1 + 1)))
+	}
+
+	switch op.Opcode {
+	case opVerify:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if !castToBool(data) {
+			return fmt.Errorf("%w: OP_VERIFY", ErrScriptFailed)
+		}
+
+	case opReturn:
+		return fmt.Errorf("%w: OP_RETURN", ErrScriptFailed)
+
+	case opDrop:
+		_, err := vm.pop()
+		return err
+
+	case opDup:
+		if len(vm.stack) == 0 {
+			return fmt.Errorf("%w: 栈为空", ErrScriptFailed)
+		}
+		return vm.push(vm.stack[len(vm.stack)-1])
+
+	case opEqual, opEqualVerify:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if op.Opcode == opEqualVerify {
+			if !bytes.Equal(a, b) {
+				return fmt.Errorf("%w: OP_EQUALVERIFY", ErrScriptFailed)
+			}
+			return nil
+		}
+		return vm.pushBool(bytes.Equal(a, b))
+
+	case opSha256:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		return vm.push(hash[:])
+
+	case opHash160:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		return vm.push(HashPubKey(data))
+
+	case opCheckSig:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if err := checkPubKey(pubKey); err != nil {
+			return err
+		}
+		signature, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		hash := vm.tx.signatureHash(vm.inID, script)
+		return vm.pushBool(verifyHashSignature(pubKey, hash, signature))
+
+	case opCheckMultiSig:
+		ok, err := vm.checkMultiSig(script)
+		if err != nil {
+			return err
+		}
+		return vm.pushBool(ok)
+
+	case opCheckLockTimeVerify:
+		return vm.checkLockTime()
+
+	default:
+		return fmt.Errorf("%w: 不支持的操作码0x%02x", ErrScriptFailed, op.Opcode)
+	}
+
+	return nil
+}
+
+// checkMultiSig 执行OP_CHECKMULTISIG：<sig1> ... <sigM> M <pubKey1> ... <pubKeyN> N
+// 签名必须按公钥的顺序排列，每个签名只与它之后的公钥匹配
+func (vm *scriptEngine) checkMultiSig(script []byte) (bool, error) {
+	n, err := vm.popInt()
+	if err != nil {
+		return false, err
+	}
+	if n < 1 || n > maxPubKeysPerMultiSig {
+		return false, fmt.Errorf("%w: 公钥数%d非法", ErrScriptFailed, n)
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = vm.pop(); err != nil {
+			return false, err
+		}
+		if err := checkPubKey(pubKeys[i]); err != nil {
+			return false, err
+		}
+	}
+
+	m, err := vm.popInt()
+	if err != nil {
+		return false, err
+	}
+	if m < 1 || m > n {
+		return false, fmt.Errorf("%w: 签名数%d非法", ErrScriptFailed, m)
+	}
+	signatures := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if signatures[i], err = vm.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	hash := vm.tx.signatureHash(vm.inID, script)
+	k := 0
+	for _, signature := range signatures {
+		for k < len(pubKeys) && !verifyHashSignature(pubKeys[k], hash, signature) {
+			k++
+		}
+		if k == len(pubKeys) {
+			return false, nil
+		}
+		k++
+	}
+
+	return true, nil
+}
+
+// checkPubKey 签名检查使用的公钥必须是pubKeyLen个字节的原生态公钥，长度不对的公钥无法拆分出坐标，直接使脚本失败
+func checkPubKey(pubKey []byte) error {
+	if len(pubKey) != pubKeyLen {
+		return fmt.Errorf("%w: 公钥长度为%d个字节，应为%d个字节", ErrScriptFailed, len(pubKey), pubKeyLen)
+	}
+
+	return nil
+}
+
+// checkLockTime 执行OP_CHECKLOCKTIMEVERIFY：栈顶的锁定时间不能晚于交易的LockTime，不出栈
+// 二者必须同为区块高度或同为时间戳，并且输入的序号不能是sequenceFinal，否则交易的LockTime不生效
+func (vm *scriptEngine) checkLockTime() error {
+	if len(vm.stack) == 0 {
+		return fmt.Errorf("%w: 栈为空", ErrScriptFailed)
+	}
+	lockTime, err := parseScriptNum(vm.stack[len(vm.stack)-1], 5)
+	if err != nil {
+		return err
+	}
+	if lockTime < 0 {
+		return fmt.Errorf("%w: 锁定时间为负数", ErrScriptFailed)
+	}
+
+	txLockTime := vm.tx.LockTime
+	if (lockTime < lockTimeThreshold) != (txLockTime < lockTimeThreshold) {
+		return fmt.Errorf("%w: 锁定时间的类型与交易不一致", ErrScriptFailed)
+	}
+	if lockTime > txLockTime {
+		return fmt.Errorf("%w: 未到锁定时间%d", ErrScriptFailed, lockTime)
+	}
+	if vm.tx.Vin[vm.inID].Sequence == sequenceFinal {
+		return fmt.Errorf("%w: 输入的序号为sequenceFinal", ErrScriptFailed)
+	}
+
+	return nil
+}
+
+// verifyScript 验证交易tx的第inID个输入：先执行解锁脚本，再在同一个栈上执行所引用输出的锁定脚本，
+// 执行成功并且栈顶为true时输出被解锁
+// 解锁脚本只能压入数据，防止它改变锁定脚本的执行逻辑
+// 锁定脚本是P2SH时，解锁脚本压入的最后一项是赎回脚本，锁定脚本只检查它的哈希，之后还要用解锁脚本的其余数据执行赎回脚本
+func verifyScript(scriptSig, scriptPubKey []byte, tx *Transaction, inID int) error {
+	ops, err := parseScript(scriptSig)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if !op.isPush() {
+			return fmt.Errorf("%w: 解锁脚本只能压入数据", ErrScriptFailed)
+		}
+	}
+
+	vm := &scriptEngine{tx: tx, inID: inID}
+	if err := vm.execute(scriptSig); err != nil {
+		return err
+	}
+	stack := append([][]byte{}, vm.stack...) //解锁脚本执行后的栈，P2SH用它执行赎回脚本
+	if err := vm.execute(scriptPubKey); err != nil {
+		return err
+	}
+	if err := vm.succeeded(); err != nil {
+		return err
+	}
+	if extractP2SH(scriptPubKey) == nil {
+		return nil
+	}
+
+	redeemScript := stack[len(stack)-1]
+	vm.stack = stack[:len(stack)-1]
+	if err := vm.execute(redeemScript); err != nil {
+		return err
+	}
+
+	return vm.succeeded()
+}
+
+// succeeded 脚本执行结束后栈顶为true时执行成功
+func (vm *scriptEngine) succeeded() error {
+	if len(vm.stack) == 0 || !castToBool(vm.stack[len(vm.stack)-1]) {
+		return fmt.Errorf("%w: 栈顶为false", ErrScriptFailed)
+	}
+
+	return nil
+}
+
+// signatureHash 计算交易第inID个输入的签名哈希
+// 交易副本中所有输入的解锁脚本都置空，第inID个输入的解锁脚本替换为所引用输出的锁定脚本，
+// 签名覆盖全部输入、输出、序号和LockTime
+func (tx *Transaction) signatureHash(inID int, prevScript []byte) []byte {
+	txCopy := tx.TrimmedCopy()
+	txCopy.ID = nil
+	txCopy.Vin[inID].ScriptSig = prevScript
+	hash := sha256.Sum256(txCopy.Serialize())
+
+	return hash[:]
+}
@@ -0,0 +1,341 @@
+package blockchain7
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newSpendingTx 创建花费一个输出的交易，用于计算签名哈希，输入的解锁脚本由测试设置
+func newSpendingTx(sequence uint32, lockTime int64) *Transaction {
+	tx := &Transaction{
+		nil,
+		[]TxInput{{[]byte("prev"), 0, nil, sequence}},
+		[]TxOutput{{5, NewP2PKHScript(make([]byte, 20))}},
+		1600000000,
+		lockTime,
+	}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+func TestParseScript(t *testing.T) {
+	long := bytes.Repeat([]byte{0xab}, 300)
+
+	cases := []struct {
+		name   string
+		script []byte
+		ops    []scriptOp
+		err    bool
+	}{
+		{"empty", nil, nil, false},
+		{"op0 pushes nothing", []byte{op0}, []scriptOp{{op0, []byte{}}}, false},
+		{"direct push", []byte{2, 0xaa, 0xbb}, []scriptOp{{2, []byte{0xaa, 0xbb}}}, false},
+		{"pushdata1", append([]byte{opPushData1, 3}, 1, 2, 3), []scriptOp{{opPushData1, []byte{1, 2, 3}}}, false},
+		{"pushdata2", append([]byte{opPushData2, 0x2c, 0x01}, long...), []scriptOp{{opPushData2, long}}, false},
+		{"opcodes", []byte{opDup, op1, op16}, []scriptOp{{opDup, nil}, {op1, nil}, {op16, nil}}, false},
+		{"direct push truncated", []byte{3, 0xaa}, nil, true},
+		{"pushdata1 missing length", []byte{opPushData1}, nil, true},
+		{"pushdata1 truncated", []byte{opPushData1, 2, 0xaa}, nil, true},
+		{"pushdata2 missing length", []byte{opPushData2, 0x01}, nil, true},
+		{"too long", make([]byte, maxScriptSize+1), nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ops, err := parseScript(c.script)
+			if c.err {
+				assert.ErrorIs(t, err, ErrScriptFailed)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.ops, ops)
+		})
+	}
+}
+
+func TestScriptNum(t *testing.T) {
+	cases := []struct {
+		n       int64
+		encoded []byte
+	}{
+		{0, nil},
+		{1, []byte{0x01}},
+		{-1, []byte{0x81}},
+		{16, []byte{0x10}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{-128, []byte{0x80, 0x80}},
+		{255, []byte{0xff, 0x00}},
+		{256, []byte{0x00, 0x01}},
+		{-256, []byte{0x00, 0x81}},
+		{32767, []byte{0xff, 0x7f}},
+		{32768, []byte{0x00, 0x80, 0x00}},
+		{0x7fffffff, []byte{0xff, 0xff, 0xff, 0x7f}},
+		{-0x7fffffff, []byte{0xff, 0xff, 0xff, 0xff}},
+		{lockTimeThreshold, []byte{0x00, 0x65, 0xcd, 0x1d}},
+		{0xffffffff, []byte{0xff, 0xff, 0xff, 0xff, 0x00}},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.encoded, scriptNum(c.n), "encode %d", c.n)
+
+		n, err := parseScriptNum(c.encoded, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, c.n, n, "decode %x", c.encoded)
+	}
+}
+
+func TestParseScriptNum(t *testing.T) {
+	cases := []struct {
+		name   string
+		data   []byte
+		maxLen int
+		n      int64
+		err    bool
+	}{
+		{"empty is zero", nil, 4, 0, false},
+		{"negative zero", []byte{0x80}, 4, 0, false},
+		{"non-minimal zero", []byte{0x00, 0x00}, 4, 0, false},
+		{"four bytes", []byte{0xff, 0xff, 0xff, 0x7f}, 4, 0x7fffffff, false},
+		{"five bytes over limit", []byte{0xff, 0xff, 0xff, 0xff, 0x00}, 4, 0, true},
+		{"five bytes allowed", []byte{0xff, 0xff, 0xff, 0xff, 0x00}, 5, 0xffffffff, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n, err := parseScriptNum(c.data, c.maxLen)
+			if c.err {
+				assert.ErrorIs(t, err, ErrScriptFailed)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.n, n)
+		})
+	}
+}
+
+func TestCastToBool(t *testing.T) {
+	cases := []struct {
+		data []byte
+		v    bool
+	}{
+		{nil, false},
+		{[]byte{0x00}, false},
+		{[]byte{0x00, 0x00}, false},
+		{[]byte{0x80}, false},
+		{[]byte{0x00, 0x80}, false},
+		{[]byte{0x01}, true},
+		{[]byte{0x80, 0x00}, true},
+		{[]byte{0x00, 0x01}, true},
+		{[]byte{0x81}, true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.v, castToBool(c.data), "%x", c.data)
+	}
+}
+
+func TestVerifyScriptP2PKH(t *testing.T) {
+	wallet := NewWallet()
+	other := NewWallet()
+	scriptPubKey := NewP2PKHScript(HashPubKey(wallet.PublicKey))
+
+	tx := newSpendingTx(sequenceFinal, 0)
+	signature := signHash(wallet.PrivateKey, tx.signatureHash(0, scriptPubKey))
+	otherSignature := signHash(other.PrivateKey, tx.signatureHash(0, scriptPubKey))
+	otherTx := newSpendingTx(sequenceFinal, 1)
+	otherTxSignature := signHash(wallet.PrivateKey, otherTx.signatureHash(0, scriptPubKey))
+
+	cases := []struct {
+		name      string
+		scriptSig []byte
+		ok        bool
+	}{
+		{"valid", NewP2PKHScriptSig(signature, wallet.PublicKey), true},
+		{"wrong public key", NewP2PKHScriptSig(otherSignature, other.PublicKey), false},
+		{"signature by another key", NewP2PKHScriptSig(otherSignature, wallet.PublicKey), false},
+		{"signature for another transaction", NewP2PKHScriptSig(otherTxSignature, wallet.PublicKey), false},
+		{"missing public key", (&ScriptBuilder{}).AddData(signature).Script(), false},
+		{"empty", nil, false},
+		{"non-push scriptSig", append(NewP2PKHScriptSig(signature, wallet.PublicKey), opDup), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := verifyScript(c.scriptSig, scriptPubKey, tx, 0)
+			if c.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrScriptFailed)
+			}
+		})
+	}
+}
+
+func TestCheckMultiSig(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet(), NewWallet()}
+	outsider := NewWallet()
+	var pubKeys [][]byte
+	for _, w := range wallets {
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+	scriptPubKey, err := NewMultiSigScript(2, pubKeys)
+	assert.NoError(t, err)
+
+	tx := newSpendingTx(sequenceFinal, 0)
+	hash := tx.signatureHash(0, scriptPubKey)
+	sig := func(w *Wallet) []byte { return signHash(w.PrivateKey, hash) }
+	scriptSig := func(signatures ...[]byte) []byte {
+		b := &ScriptBuilder{}
+		for _, signature := range signatures {
+			b.AddData(signature)
+		}
+		return b.Script()
+	}
+
+	cases := []struct {
+		name      string
+		scriptSig []byte
+		ok        bool
+	}{
+		{"keys 1 and 2", scriptSig(sig(wallets[0]), sig(wallets[1])), true},
+		{"keys 1 and 3", scriptSig(sig(wallets[0]), sig(wallets[2])), true},
+		{"keys 2 and 3", scriptSig(sig(wallets[1]), sig(wallets[2])), true},
+		{"out of order", scriptSig(sig(wallets[1]), sig(wallets[0])), false},
+		{"same key twice", scriptSig(sig(wallets[0]), sig(wallets[0])), false},
+		{"outsider signature", scriptSig(sig(wallets[0]), sig(outsider)), false},
+		{"below threshold", scriptSig(sig(wallets[0])), false},
+		{"no signatures", nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := verifyScript(c.scriptSig, scriptPubKey, tx, 0)
+			if c.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrScriptFailed)
+			}
+		})
+	}
+}
+
+func TestCheckLockTimeVerify(t *testing.T) {
+	lockScript := func(lockTime int64) []byte {
+		b := &ScriptBuilder{}
+		b.AddInt(lockTime).AddOp(opCheckLockTimeVerify).AddOp(opDrop).AddInt(1)
+		return b.Script()
+	}
+
+	cases := []struct {
+		name       string
+		lockTime   int64 //脚本中的锁定时间
+		txLockTime int64
+		sequence   uint32
+		ok         bool
+	}{
+		{"height reached", 100, 100, maxReplaceableSequence, true},
+		{"height passed", 100, 150, maxReplaceableSequence, true},
+		{"height not reached", 100, 99, maxReplaceableSequence, false},
+		{"small height", 5, 5, maxReplaceableSequence, true},
+		{"time reached", lockTimeThreshold + 10, lockTimeThreshold + 10, maxReplaceableSequence, true},
+		{"time not reached", lockTimeThreshold + 10, lockTimeThreshold + 9, maxReplaceableSequence, false},
+		{"height against time", 100, lockTimeThreshold + 10, maxReplaceableSequence, false},
+		{"time against height", lockTimeThreshold + 10, 100, maxReplaceableSequence, false},
+		{"final sequence disables lock time", 100, 100, sequenceFinal, false},
+		{"negative lock time", -1, 100, maxReplaceableSequence, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tx := newSpendingTx(c.sequence, c.txLockTime)
+			err := verifyScript(nil, lockScript(c.lockTime), tx, 0)
+			if c.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrScriptFailed)
+			}
+		})
+	}
+}
+
+func TestExecuteConditionals(t *testing.T) {
+	cases := []struct {
+		name   string
+		script []byte
+		ok     bool
+	}{
+		{"if true", []byte{op1, opIf, op1, opElse, op0, opEndIf}, true},
+		{"if false takes else", []byte{op0, opIf, op0, opElse, op1, opEndIf}, true},
+		{"nested skipped branch", []byte{op0, opIf, op1, opIf, op0, opEndIf, opElse, op1, opEndIf}, true},
+		{"unbalanced if", []byte{op1, opIf, op1}, false},
+		{"else without if", []byte{op1, opElse}, false},
+		{"endif without if", []byte{op1, opEndIf}, false},
+		{"return fails", []byte{op1, opReturn}, false},
+		{"unknown opcode", []byte{op1, 0xff}, false},
+		{"verify false", []byte{op0, opVerify, op1}, false},
+		{"false on top", []byte{op1, op0}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := verifyScript(nil, c.script, newSpendingTx(sequenceFinal, 0), 0)
+			if c.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrScriptFailed)
+			}
+		})
+	}
+}
+
+func TestVerifyScriptP2SH(t *testing.T) {
+	wallets := []*Wallet{NewWallet(), NewWallet()}
+	redeemScript, err := NewMultiSigScript(2, [][]byte{wallets[0].PublicKey, wallets[1].PublicKey})
+	assert.NoError(t, err)
+	scriptPubKey := NewP2SHScript(HashPubKey(redeemScript))
+
+	tx := newSpendingTx(sequenceFinal, 0)
+	hash := tx.signatureHash(0, redeemScript) //P2SH输入对赎回脚本签名
+	sig0 := signHash(wallets[0].PrivateKey, hash)
+	sig1 := signHash(wallets[1].PrivateKey, hash)
+	outerHash := tx.signatureHash(0, scriptPubKey)
+	push := func(data ...[]byte) []byte {
+		b := &ScriptBuilder{}
+		for _, d := range data {
+			b.AddData(d)
+		}
+		return b.Script()
+	}
+
+	cases := []struct {
+		name         string
+		scriptSig    []byte
+		scriptPubKey []byte
+		ok           bool
+	}{
+		{"multisig redeem script", push(sig0, sig1, redeemScript), scriptPubKey, true},
+		{"below threshold", push(sig0, redeemScript), scriptPubKey, false},
+		{"signatures over the locking script", push(signHash(wallets[0].PrivateKey, outerHash), signHash(wallets[1].PrivateKey, outerHash), redeemScript), scriptPubKey, false},
+		{"missing redeem script", push(sig0, sig1), scriptPubKey, false},
+		{"redeem script hash mismatch", push(sig0, sig1, append(redeemScript, opDrop)), scriptPubKey, false},
+		//锁定脚本只检查哈希，成功与否取决于赎回脚本的执行结果
+		{"true redeem script", push([]byte{op1}), NewP2SHScript(HashPubKey([]byte{op1})), true},
+		{"false redeem script", push([]byte{op0}), NewP2SHScript(HashPubKey([]byte{op0})), false},
+		{"empty scriptSig", nil, scriptPubKey, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := verifyScript(c.scriptSig, c.scriptPubKey, tx, 0)
+			if c.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrScriptFailed)
+			}
+		})
+	}
+}
@@ -3,23 +3,51 @@ package blockchain7
 import (
 	"bytes"
 	"encoding/gob"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
 )
 
-const protocol = "tcp"   //通信协议
-const nodeVersion = 1    //节点版本
-const commandLength = 12 //命令长度：12个字节
+const protocol = "tcp"           //通信协议
+const nodeVersion = 1            //节点版本
+const commandLength = 12         //命令长度：12个字节
+const maxInvPerMsg = 500         //回复getblocks时，一个inv消息最多包含的区块哈希数量
+const maxBlockSize = 1024 * 1024 //区块中交易的最大总字节数
+
+const callTimeout = 30 * time.Second //需要回复的请求的超时时间
 
 var nodeAddress string                      //当前节点地址
 var miningAddress string                    //挖矿节点地址
-var knownNodes = []string{"localhost:3000"} //初始化为中心节点
-var blocksInTransit = [][]byte{}            //待下载的区块，用于跟踪下载区块
-var mempool = make(map[string]Transaction)
+var knownNodes = []string{"localhost:3000"} //初始化为中心节点，通过getKnownNodes等函数加锁访问
+var knownNodesMtx sync.Mutex                //多个handleConnection协程同时读写knownNodes
+
+var errPeerBanned = errors.New("节点已被封禁")
+
+// ServerOptions 节点的可配置参数
+type ServerOptions struct {
+	MaxMempoolSize  int           //交易池最大容量（字节）
+	MinRelayFeeRate int           //最低转发费率（每千字节的手续费）
+	MempoolExpiry   time.Duration //交易在交易池中的最长停留时间
+	MinerWorkers    int           //挖矿协程数量
+	BlockInterval   time.Duration //目标出块间隔
+	StratumAddress  string        //矿池服务器的监听地址，为空时不启用矿池
+	PoolAddress     string        //矿池接收区块奖励的钱包地址
+	ShareBits       int           //矿池的share难度系数
+}
+
+// DefaultServerOptions 返回默认的节点参数
+func DefaultServerOptions() ServerOptions {
+	return ServerOptions{defaultMaxMempoolSize, defaultMinRelayFeeRate, defaultMempoolExpiry, runtime.NumCPU(), defaultBlockInterval, "", "", defaultShareBits}
+}
 
 // addr 服务器列表
 type addr struct {
@@ -35,6 +63,21 @@ type block struct {
 //getblocks getblocks命令的消息结构
 type getblocks struct {
 	AddrFrom string
+	Locator  [][]byte //区块定位器：从tip开始按指数间隔选取的区块哈希，对方据此找到分叉点
+	StopHash []byte   //返回到该区块为止，为空则返回到最大数量为止
+}
+
+//getheaders getheaders命令的消息结构
+type getheaders struct {
+	AddrFrom string
+	Locator  [][]byte //区块定位器，对方据此找到分叉点
+	StopHash []byte   //返回到该区块为止，为空则返回到最大数量为止
+}
+
+//headers 回复getheaders请求的消息结构
+type headers struct {
+	AddrFrom string
+	Headers  []BlockHeader
 }
 
 //getdata getdata命令的消息结构
@@ -66,6 +109,41 @@ type verzion struct {
 	AddrFrom   string //发送此命令者的地址
 }
 
+//gettemplate 外部挖矿程序请求区块模板的消息结构，节点在同一个连接上回复template
+type gettemplate struct {
+	AddrFrom string
+}
+
+//template 回复gettemplate请求的消息结构
+type template struct {
+	AddrFrom string
+	Template BlockTemplate
+}
+
+//submitblock 外部挖矿程序提交区块的消息结构，节点在同一个连接上回复submitresult
+type submitblock struct {
+	AddrFrom string
+	Block    []byte
+}
+
+//submitresult 回复submitblock请求的消息结构，Error为空表示区块已被接受
+type submitresult struct {
+	AddrFrom string
+	Hash     []byte
+	Error    string
+}
+
+//getmininginfo 请求节点挖矿信息的消息结构，节点在同一个连接上回复mininginfo
+type getmininginfo struct {
+	AddrFrom string
+}
+
+//mininginfo 回复getmininginfo请求的消息结构
+type mininginfo struct {
+	AddrFrom string
+	Info     MiningInfo
+}
+
 //commandToBytes 将命令字符串转为byte字节
 //直接将字符串中的每一个字符强制转换为byte类型
 func commandToBytes(command string) []byte {
@@ -96,17 +174,19 @@ func extractCommand(request []byte) []byte {
 	return request[:commandLength]
 }
 
-//requestBlocks 请求区块结构，本案例未用到
+//requestBlocks 向所有已知节点请求区块头，开始同步
 func requestBlocks() {
-	for _, node := range knownNodes { //向多个节点发送区块请求消息
-		sendGetBlocks(node)
+	for _, node := range getKnownNodes() { //向多个节点发送区块头请求消息
+		if node != nodeAddress {
+			syncer.startSync(node)
+		}
 	}
 }
 
 //sendAddr 发送可用服务节点信息
 //这个函数在本案例中没有用到
 func sendAddr(address string) {
-	nodes := addr{knownNodes}
+	nodes := addr{getKnownNodes()}
 	nodes.AddrList = append(nodes.AddrList, nodeAddress)
 	payload := gobEncode(nodes)
 	request := append(commandToBytes("addr"), payload...) //命令：addr
@@ -128,15 +208,7 @@ func sendData(addr string, data []byte) {
 	conn, err := net.Dial(protocol, addr) //连接到服务器
 	if err != nil {
 		fmt.Printf("%s is not available\n", addr)
-		var updatedNodes []string
-
-		for _, node := range knownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node) //将本地节点添加到已知节点列表，公开
-			}
-		}
-
-		knownNodes = updatedNodes
+		removeNode(addr)
 
 		return
 	}
@@ -148,6 +220,35 @@ func sendData(addr string, data []byte) {
 	}
 }
 
+//callNode 向节点发送请求，并在同一个连接上读取回复
+//大部分消息都是单向的，对方通过新的连接回复到AddrFrom；外部挖矿程序等客户端没有监听地址，需要在同一个连接上得到回复
+func callNode(addr, command string, request, reply interface{}) error {
+	conn, err := net.DialTimeout(protocol, addr, callTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	_, err = conn.Write(append(commandToBytes(command), gobEncode(request)...))
+	if err != nil {
+		return err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite() //对方读到EOF后才开始处理请求
+	}
+
+	return gob.NewDecoder(conn).Decode(reply)
+}
+
+//sendReply 在请求的连接上回复
+func sendReply(conn net.Conn, data interface{}) {
+	_, err := conn.Write(gobEncode(data))
+	if err != nil {
+		fmt.Printf("回复 %s 失败: %s\n", conn.RemoteAddr(), err)
+	}
+}
+
 //sendInv 发送Inv请求：告诉我你有什么区块或者交易
 func sendInv(address, kind string, items [][]byte) {
 	inventory := inv{nodeAddress, kind, items} //kind为消息类型
@@ -157,10 +258,18 @@ func sendInv(address, kind string, items [][]byte) {
 	sendData(address, request)
 }
 
-//sendGetBlocks 发送getblocks请求
-func sendGetBlocks(address string) {
-	payload := gobEncode(getblocks{nodeAddress})
-	request := append(commandToBytes("getblocks"), payload...) //命令：getblocks
+//sendGetHeaders 发送getheaders请求
+func sendGetHeaders(address string, locator [][]byte, stopHash []byte) {
+	payload := gobEncode(getheaders{nodeAddress, locator, stopHash})
+	request := append(commandToBytes("getheaders"), payload...) //命令：getheaders
+
+	sendData(address, request)
+}
+
+//sendHeaders 发送区块头
+func sendHeaders(address string, hs []BlockHeader) {
+	payload := gobEncode(headers{nodeAddress, hs})
+	request := append(commandToBytes("headers"), payload...) //命令：headers
 
 	sendData(address, request)
 }
@@ -194,224 +303,218 @@ func sendVersion(addr string, bc *Blockchain) {
 }
 
 //handleAddr：处理addr命令回复，本案例中未用到
-func handleAddr(request []byte) {
-	var buff bytes.Buffer
+func handleAddr(request []byte) error {
 	var payload addr
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	knownNodes = append(knownNodes, payload.AddrList...)
-	fmt.Printf("There are %d known nodes now!\n", len(knownNodes))
+	addKnownNodes(payload.AddrList...)
+	fmt.Printf("There are %d known nodes now!\n", len(getKnownNodes()))
 	requestBlocks()
+
+	return nil
 }
 
 //handleBlock 处理block命令回复
-func handleBlock(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
+func handleBlock(request []byte, bc *Blockchain, peer string) error {
 	var payload block
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	blockData := payload.Block
 	block := DeserializeBlock(blockData)
 
-	fmt.Println("接收到一个新区块!")
-	bc.AddBlock(block)
-
-	fmt.Printf("添加到区块： %x\n", block.Hash)
+	fmt.Printf("接收到区块 %x\n", block.Hash)
 
-	if len(blocksInTransit) > 0 { //如果还有待下载的区块，继续请求下载，每次只请求一个
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
-
-		//执行UTXO集更新
-		UTXOSet := UTXOSet{bc}
-		UTXOSet.Update(block)
-
-		blocksInTransit = blocksInTransit[1:] //待下载区块更新，删除原来的第0个
+	//区块由同步管理器校验并按高度顺序连接，连接时会更新UTXO集
+	solicited, err := syncer.processBlock(payload.AddrFrom, block)
+	if err != nil {
+		banman.misbehaving(peer, scoreInvalidBlock, fmt.Sprintf("区块 %x 非法: %s", block.Hash, err))
+		return nil
 	}
+	if !solicited {
+		banman.misbehaving(peer, scoreUnsolicited, fmt.Sprintf("未请求的区块 %x", block.Hash))
+	}
+
+	return nil
 }
 
 //handleInv 处理inv命令回复，执行sendGetdata命令
 //无论请求的是多少数量的block或者tx，handleInv执行只请求一个block或者一个tx
-func handleInv(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
+func handleInv(request []byte, bc *Blockchain) error {
 	var payload inv
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
-	if payload.Type == "block" {
-		blocksInTransit = payload.Items //从Inv获得的正是对方发来的全部block哈希列表
-
-		blockHash := payload.Items[0]                     //没有判断区块是否在本地已经存在，而是放在addblock中进行处理
-		sendGetData(payload.AddrFrom, "block", blockHash) //向对方发送getdata命令，请求缺失的一个区块
+	if len(payload.Items) == 0 { //回复getblocks时，对方的定位器已经到了tip，没有需要发送的区块
+		return nil
+	}
 
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 { //删除即将请求的区块
-				newInTransit = append(newInTransit, b) //更新本地的区块信息
+	if payload.Type == "block" {
+		//新区块的通知：先向对方请求区块头，区块头验证通过后再下载区块
+		for _, blockHash := range payload.Items {
+			if !bc.HasBlock(blockHash) {
+				syncer.startSync(payload.AddrFrom)
+				break
 			}
 		}
-		blocksInTransit = newInTransit
 	}
 
 	if payload.Type == "tx" {
 		txID := payload.Items[0] //本案例中，不会存在传送多个tx的情形
 
-		if mempool[hex.EncodeToString(txID)].ID == nil {
+		if !mempool.Has(txID) {
 			sendGetData(payload.AddrFrom, "tx", txID) //向对方请求某条交易信息
 		}
 	}
+
+	return nil
 }
 
 //handleGetBlocks 处理getblocks命令，发送Inv命令
-//将本地block哈希列表发给远程节点
-func handleGetBlocks(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
+//根据对方的区块定位器找到分叉点，只将其后的一批区块哈希发给远程节点
+func handleGetBlocks(request []byte, bc *Blockchain) error {
 	var payload getblocks
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	blocks := bc.GetBlockHashes()
-	sendInv(payload.AddrFrom, "block", blocks) //将本地所有区块的哈希数组发给对方
+	blocks := bc.GetBlockHashesAfter(payload.Locator, payload.StopHash, maxInvPerMsg)
+	sendInv(payload.AddrFrom, "block", blocks) //对方缺失的区块哈希，最多maxInvPerMsg个
+
+	return nil
+}
+
+//handleGetHeaders 处理getheaders命令，根据对方的区块定位器找到分叉点，发送其后的区块头
+func handleGetHeaders(request []byte, bc *Blockchain) error {
+	var payload getheaders
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	hs := bc.GetHeadersAfter(payload.Locator, payload.StopHash, maxHeadersPerMsg)
+	sendHeaders(payload.AddrFrom, hs)
+
+	return nil
+}
+
+//handleHeaders 处理headers命令，验证区块头并开始并行下载区块
+func handleHeaders(request []byte, bc *Blockchain, peer string) error {
+	var payload headers
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recevied %d headers\n", len(payload.Headers))
+
+	err = syncer.processHeaders(payload.AddrFrom, payload.Headers)
+	if errors.Is(err, errUnconnectedHeader) {
+		banman.misbehaving(peer, scoreUnconnectedHeader, err.Error())
+	} else if err != nil {
+		banman.misbehaving(peer, scoreInvalidHeader, err.Error())
+	}
+
+	return nil
 }
 
 //handleGetData 处理getdata命令，发送所需的某个具体block或者tx
-func handleGetData(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
+func handleGetData(request []byte, bc *Blockchain) error {
 	var payload getdata
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	if payload.Type == "block" {
 		block, err := bc.GetBlock([]byte(payload.ID))
 		if err != nil {
-			return
+			return nil
 		}
 
 		sendBlock(payload.AddrFrom, &block)
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
+		tx, ok := mempool.Get(payload.ID)
+		if !ok { //交易可能已经上链
+			return nil
+		}
 
 		sendTx(payload.AddrFrom, &tx)
 		// delete(mempool, txID)
 	}
+
+	return nil
 }
 
 //handleTx 矿工处理请求tx的回复消息
-func handleTx(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
+func handleTx(request []byte, bc *Blockchain, peer string) error {
 	var payload tx
 
-	buff.Write(request[commandLength:]) //request消息，前面12个字节是命令，后面是payload
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	txData := payload.Transaction
 	tx := DeserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)] = tx //将交易丢到待上链的交易池中
-
-	if nodeAddress == knownNodes[0] { //当前节点为中心节点，中心节点收到新交易
-		for _, node := range knownNodes {
-			if node != nodeAddress && node != payload.AddFrom {
-				//将当前交易ID通过inv命令发送给既非当前节点也非交易发起者节点之外的所有其它节点
-				sendInv(node, "tx", [][]byte{tx.ID})
-			}
+	accepted, err := mempool.ProcessTransaction(&tx) //验证通过后，将交易丢到待上链的交易池中
+	//父交易还没有收到，向发来交易的节点请求父交易
+	if err == ErrTxOrphan {
+		fmt.Printf("交易 %x 是孤儿交易\n", tx.ID)
+		for _, parentID := range mempool.MissingParents(&tx) {
+			sendGetData(payload.AddFrom, "tx", parentID)
 		}
-	} else { //当前节点为非中心节点
-		if len(mempool) >= 2 && len(miningAddress) > 0 { //如果当前是挖矿节点，打包发来的交易进行挖矿处理：minerAddress不为空值
-		MineTransactions:
-			var txs []*Transaction
-
-			for id := range mempool {
-				tx := mempool[id]
-				fmt.Printf("%s to be veryfied...\n", hex.EncodeToString(tx.ID))
-
-				if bc.VerifyTransaction(&tx) == true {
-					txs = append(txs, &tx)
-					fmt.Printf("%s veryfied true.\n", hex.EncodeToString(tx.ID))
-				} else {
-					fmt.Printf("%s veryfied false.\n", hex.EncodeToString(tx.ID))
-				}
-			}
-
-			if len(txs) == 0 {
-				fmt.Println("所有的新交易均非法! 等待新的交易...")
-				return
-			}
-
-			cbTx := NewCoinbaseTX(miningAddress, "")
-			txs = append(txs, cbTx)
-
-			newBlock := bc.MineBlock(txs)
-			UTXOSet := UTXOSet{bc}
-			UTXOSet.Update(newBlock)
-
-			fmt.Println("新区块已挖出!")
-
-			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID)
-				delete(mempool, txID) //从交易池中删除当前已经上链的全部交易
-			}
-
-			for _, node := range knownNodes {
-				if node != nodeAddress {
-					//将新的模块哈希通过inv命令发送给除本地节点之外的其他节点，通知对方进行本地区块链更新
-					sendInv(node, "block", [][]byte{newBlock.Hash})
-				}
-			}
+		return nil
+	}
+	if errors.Is(err, ErrTxInvalid) || errors.Is(err, ErrTxCoinbase) {
+		banman.misbehaving(peer, scoreInvalidTx, fmt.Sprintf("交易 %x: %s", tx.ID, err))
+		return nil
+	}
+	if err != nil { //已知交易、冲突交易或引用的输出不存在，对方未必有恶意，只是拒绝该交易
+		fmt.Printf("拒绝交易 %x: %s\n", tx.ID, err)
+		return nil
+	}
 
-			if len(mempool) > 0 {
-				goto MineTransactions
-			}
-		}
+	if nodeAddress == centralNode() { //当前节点为中心节点，中心节点收到新交易
+		relayTransactions(accepted, payload.AddFrom)
+	}
+	if miner != nil { //当前是挖矿节点，交易池内容变化，通知挖矿协程稍后用新的交易重新开始挖矿
+		miner.NotifyTx()
+	}
+	if stratum != nil { //矿池稍后下发包含新交易的任务
+		stratum.Notify()
 	}
+
+	return nil
 }
 
 // handleVersion 处理版本请求回复消息
-func handleVersion(request []byte, bc *Blockchain) {
+func handleVersion(request []byte, bc *Blockchain) error {
 	fmt.Printf("handleVersion...")
-	var buff bytes.Buffer
 	var payload verzion
 
-	buff.Write(request[commandLength:]) //request消息，前面12个字节（commandLength）为命令，后面是payload
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	myBestHeight := bc.GetBestHeight()
@@ -419,53 +522,236 @@ func handleVersion(request []byte, bc *Blockchain) {
 
 	foreignerBestHeight := payload.BestHeight
 	fmt.Printf("foreignerBestHeight is %d\n", foreignerBestHeight)
+	syncer.updatePeer(payload.AddrFrom, foreignerBestHeight)
 
-	if myBestHeight < foreignerBestHeight { //如果本地区块height小，请求缺失区块
-		sendGetBlocks(payload.AddrFrom)
+	if myBestHeight < foreignerBestHeight { //如果本地区块height小，先请求缺失的区块头
+		syncer.startSync(payload.AddrFrom)
 	} else if myBestHeight > foreignerBestHeight { //如果本地区块height大，发送本地最新版本信息给到对方，对方可以据此更新
 		sendVersion(payload.AddrFrom, bc)
 	}
 
 	// sendAddr(payload.AddrFrom)
-	if !nodeIsKnown(payload.AddrFrom) {
-		knownNodes = append(knownNodes, payload.AddrFrom)
-	}
+	addKnownNodes(payload.AddrFrom)
+
+	return nil
 }
 
 //handleConnection 处理中心，根据命令执行命令处理函数
+//对方发来的任何数据都不应使本节点崩溃：无法解码的消息和处理中出现的panic都计入对方的不良行为分值
+//不良行为分值按peerKey返回的稳定标识记录，同一节点的每条消息都使用新的连接，不能按连接的端口计分
 func handleConnection(conn net.Conn, bc *Blockchain) {
+	defer conn.Close()
+
 	request, err := ioutil.ReadAll(conn)
 	if err != nil {
-		log.Panic(err)
+		fmt.Printf("读取来自 %s 的消息失败: %s\n", conn.RemoteAddr(), err)
+		return
+	}
+	if len(request) < commandLength {
+		banman.misbehaving(peerKey(conn, "", request), scoreMalformedMessage, "消息长度不足")
+		return
 	}
 	command := bytesToCommand(request[:commandLength])
+	peer := peerKey(conn, command, request)
+	if banman.isBanned(peer) {
+		return
+	}
 	fmt.Printf("Received %s command\n", command)
 
+	defer func() {
+		if r := recover(); r != nil {
+			banman.misbehaving(peer, scoreMalformedMessage, fmt.Sprintf("处理%s命令出错: %v", command, r))
+		}
+	}()
+
 	switch command {
 	case "addr": //请求可用的节点，暂时没有用到
-		handleAddr(request)
+		err = handleAddr(request)
 	case "block":
-		handleBlock(request, bc)
+		err = handleBlock(request, bc, peer)
 	case "inv": //向其他节点展示当前节点有什么块或交易
-		handleInv(request, bc)
+		err = handleInv(request, bc)
 	case "getblocks": //给我看看你有什么区块
-		handleGetBlocks(request, bc)
+		err = handleGetBlocks(request, bc)
+	case "getheaders": //给我看看你有什么区块头
+		err = handleGetHeaders(request, bc)
+	case "headers":
+		err = handleHeaders(request, bc, peer)
 	case "getdata":
-		handleGetData(request, bc)
+		err = handleGetData(request, bc)
 	case "tx":
-		handleTx(request, bc)
+		err = handleTx(request, bc, peer)
 	case "version":
-		handleVersion(request, bc)
+		err = handleVersion(request, bc)
+	case "gettemplate": //外部挖矿程序请求区块模板
+		err = handleGetTemplate(request, bc, conn)
+	case "submitblock": //外部挖矿程序提交区块
+		err = handleSubmitBlock(request, bc, conn, peer)
+	case "getmininginfo":
+		err = handleGetMiningInfo(request, bc, conn)
 	default:
 		fmt.Println("Unknown command!")
+		banman.misbehaving(peer, scoreMalformedMessage, fmt.Sprintf("未知命令%q", command))
 	}
 
-	conn.Close()
+	if err != nil && err != errPeerBanned {
+		banman.misbehaving(peer, scoreMalformedMessage, fmt.Sprintf("无法解码%s消息: %s", command, err))
+	}
+}
+
+//peerKey 不良行为分值和封禁使用的键
+//一般为连接的IP，同一主机的所有连接共享分值，消息中自报的发送者地址可以伪造，不用于计分；
+//环回地址上的所有本地节点共享同一个IP，按IP封禁会误伤全部本地节点，而连接的端口每条消息都不同，
+//这时使用消息中的发送者地址，该地址的主机也必须是环回地址，即只有本机上的节点才能以本机节点的身份计分
+func peerKey(conn net.Conn, command string, request []byte) string {
+	address := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return host
+	}
+
+	sender := messageSender(command, request)
+	if senderHost, _, err := net.SplitHostPort(sender); err == nil && isLoopbackHost(senderHost) {
+		return sender
+	}
+
+	return host
+}
+
+//isLoopbackHost 检查主机名是否为环回地址
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+//messageSender 解码消息，返回其中的发送者地址，未知命令或无法解码时返回空字符串
+func messageSender(command string, request []byte) string {
+	var payload interface{}
+
+	switch command {
+	case "block":
+		payload = &block{}
+	case "inv":
+		payload = &inv{}
+	case "getblocks":
+		payload = &getblocks{}
+	case "getheaders":
+		payload = &getheaders{}
+	case "headers":
+		payload = &headers{}
+	case "getdata":
+		payload = &getdata{}
+	case "tx":
+		payload = &tx{}
+	case "version":
+		payload = &verzion{}
+	case "gettemplate":
+		payload = &gettemplate{}
+	case "submitblock":
+		payload = &submitblock{}
+	case "getmininginfo":
+		payload = &getmininginfo{}
+	default:
+		return ""
+	}
+
+	err := gob.NewDecoder(bytes.NewReader(request[commandLength:])).Decode(payload)
+	if err != nil {
+		return ""
+	}
+
+	return payloadSender(payload)
+}
+
+//handleGetTemplate 处理gettemplate命令，在同一个连接上回复区块模板
+func handleGetTemplate(request []byte, bc *Blockchain, conn net.Conn) error {
+	var payload gettemplate
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	sendReply(conn, template{nodeAddress, *NewBlockTemplate(bc, mempool)})
+
+	return nil
+}
+
+//handleGetMiningInfo 处理getmininginfo命令，在同一个连接上回复挖矿信息
+func handleGetMiningInfo(request []byte, bc *Blockchain, conn net.Conn) error {
+	var payload getmininginfo
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	sendReply(conn, mininginfo{nodeAddress, getMiningInfo(bc)})
+
+	return nil
+}
+
+//handleSubmitBlock 处理submitblock命令，验证并连接外部挖矿程序挖出的区块，在同一个连接上回复结果
+func handleSubmitBlock(request []byte, bc *Blockchain, conn net.Conn, peer string) error {
+	var payload submitblock
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	block := DeserializeBlock(payload.Block)
+	result := submitresult{nodeAddress, block.Hash, ""}
+
+	err = submitBlock(bc, block)
+	if err != nil {
+		fmt.Printf("拒绝提交的区块 %x: %s\n", block.Hash, err)
+		result.Error = err.Error()
+		if err != errStaleBlock { //过时的区块只是晚了一步，不算不良行为
+			banman.misbehaving(peer, scoreInvalidBlock, fmt.Sprintf("提交的区块 %x 非法: %s", block.Hash, err))
+		}
+	}
+	sendReply(conn, result)
+
+	return nil
+}
+
+//relayTransactions 中心节点将新进入交易池的交易转发出去
+//将交易ID通过inv命令发送给既非当前节点也非交易发起者节点之外的所有其它节点
+func relayTransactions(txs []*Transaction, from string) {
+	if nodeAddress != centralNode() {
+		return
+	}
+
+	nodes := getKnownNodes()
+	for _, tx := range txs {
+		for _, node := range nodes {
+			if node != nodeAddress && node != from {
+				sendInv(node, "tx", [][]byte{tx.ID})
+			}
+		}
+	}
+}
+
+//relayBlock 将新区块通过inv命令通知给除当前节点和区块来源节点之外的所有其它节点，对方收到后按headers-first的方式下载
+func relayBlock(block *Block, from string) {
+	for _, node := range getKnownNodes() {
+		if node != nodeAddress && node != from {
+			sendInv(node, "block", [][]byte{block.Hash})
+		}
+	}
 }
 
 // StartServer 启动一个节点
 //minerAddress若是控制，为非挖矿节点，不为空值，为挖矿节点
-func StartServer(nodeID, minerAddress string) {
+func StartServer(nodeID, minerAddress string, options ServerOptions) {
 	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
 	//如果当前是挖矿节点，那么miningAddress的长度不会为空，否则miningAddress是空值
 	miningAddress = minerAddress
@@ -477,15 +763,62 @@ func StartServer(nodeID, minerAddress string) {
 	defer ln.Close()
 
 	bc := NewBlockchain(nodeID)
+	defer bc.Db.Close()
+	banman = newBanManager(nodeID)
+	go banman.run() //清除过期的封禁记录
+	estimator := NewFeeEstimator(bc.GetBestHeight())
+	estimator.LoadFromFile(nodeID) //读取上次关闭时的手续费统计
+	go estimator.run(nodeID)       //定时保存手续费统计
+	mempool = NewMempool(bc, options.MaxMempoolSize, options.MinRelayFeeRate, options.MempoolExpiry)
+	mempool.SetFeeEstimator(estimator)
+	mempool.LoadFromFile(nodeID) //读取上次关闭时的交易池
+	go mempool.run()             //删除过期的交易
+	syncer = newSyncManager(bc)
+	go syncer.run() //处理超时的区块下载请求
+	if len(miningAddress) > 0 {
+		if a, ok := consensus.(authorizer); ok { //需要用挖矿地址的私钥出块
+			a.Authorize(loadWallet(miningAddress, nodeID))
+		}
+		miner = NewMiner(bc, miningAddress, options.MinerWorkers, options.BlockInterval)
+		go miner.run() //在单独的协程中持续挖矿
+	}
+	if len(options.StratumAddress) > 0 {
+		if _, ok := consensus.(*PoWEngine); !ok {
+			log.Panic("矿池只支持工作量证明")
+		}
+		stratum = NewStratumServer(bc, options.PoolAddress, options.ShareBits, nodeID)
+		go stratum.ListenAndServe(options.StratumAddress) //矿机连接到单独的端口
+	}
 
-	if nodeAddress != knownNodes[0] { //如果不是中心节点，发送Version命令，从网络（中心节点）请求缺失区块
-		sendVersion(knownNodes[0], bc) //服务器启动后，非中心节点要干的第一件事，就是下载缺失区块
+	if central := centralNode(); nodeAddress != central { //如果不是中心节点，发送Version命令，从网络（中心节点）请求缺失区块
+		sendVersion(central, bc) //服务器启动后，非中心节点要干的第一件事，就是下载缺失区块
 	}
 
+	//收到中断信号时关闭监听，退出下面的循环，保存交易池和手续费统计后关闭节点
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	stopping := make(chan struct{})
+	go func() {
+		<-shutdown
+		fmt.Println("正在关闭节点...")
+		close(stopping)
+		ln.Close()
+	}()
+
 	for {
 		conn, err := ln.Accept() //阻塞，等待客户端连接
 		if err != nil {
-			log.Panic(err)
+			select {
+			case <-stopping:
+				mempool.SaveToFile(nodeID)
+				estimator.SaveToFile(nodeID)
+				if stratum != nil {
+					stratum.SaveToFile()
+				}
+				return
+			default:
+				log.Panic(err)
+			}
 		}
 		//并发模式，接收来自客户端的连接请求,对每一个到来的客户端连接创建一个处理连接的并发任务
 		//一旦有连接，前面的阻塞解除，程序将执行到下面的协程
@@ -493,6 +826,55 @@ func StartServer(nodeID, minerAddress string) {
 	}
 }
 
+//decodePayload 解码消息中命令之后的payload
+//如果消息的发送者已被封禁，返回errPeerBanned，调用者应直接丢弃该消息
+func decodePayload(request []byte, payload interface{}) error {
+	var buff bytes.Buffer
+
+	buff.Write(request[commandLength:]) //request消息，前面12个字节是命令，后面是payload
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(payload)
+	if err != nil {
+		return err
+	}
+
+	if from := payloadSender(payload); from != "" && banman.isBanned(from) {
+		return errPeerBanned
+	}
+
+	return nil
+}
+
+//payloadSender 返回消息中的发送者地址，addr消息没有发送者地址
+func payloadSender(payload interface{}) string {
+	switch p := payload.(type) {
+	case *block:
+		return p.AddrFrom
+	case *getblocks:
+		return p.AddrFrom
+	case *getheaders:
+		return p.AddrFrom
+	case *headers:
+		return p.AddrFrom
+	case *getdata:
+		return p.AddrFrom
+	case *inv:
+		return p.AddrFrom
+	case *tx:
+		return p.AddFrom
+	case *verzion:
+		return p.AddrFrom
+	case *gettemplate:
+		return p.AddrFrom
+	case *submitblock:
+		return p.AddrFrom
+	case *getmininginfo:
+		return p.AddrFrom
+	}
+
+	return ""
+}
+
 func gobEncode(data interface{}) []byte {
 	var buff bytes.Buffer
 
@@ -505,7 +887,55 @@ func gobEncode(data interface{}) []byte {
 	return buff.Bytes()
 }
 
-// nodeIsKnown 节点地址是否在遗址节点列表中
+// getKnownNodes 返回已知节点列表的副本，遍历时其他协程可以修改列表
+func getKnownNodes() []string {
+	knownNodesMtx.Lock()
+	defer knownNodesMtx.Unlock()
+
+	return append([]string(nil), knownNodes...)
+}
+
+// centralNode 中心节点，即已知节点列表中的第一个节点
+func centralNode() string {
+	knownNodesMtx.Lock()
+	defer knownNodesMtx.Unlock()
+
+	if len(knownNodes) == 0 {
+		return ""
+	}
+
+	return knownNodes[0]
+}
+
+// addKnownNodes 把还不在已知节点列表中的节点加入列表
+func addKnownNodes(addrs ...string) {
+	knownNodesMtx.Lock()
+	defer knownNodesMtx.Unlock()
+
+	for _, addr := range addrs {
+		if !nodeIsKnown(addr) {
+			knownNodes = append(knownNodes, addr)
+		}
+	}
+}
+
+// removeNode 从已知节点列表中删除一个节点
+func removeNode(addr string) {
+	knownNodesMtx.Lock()
+	defer knownNodesMtx.Unlock()
+
+	var updatedNodes []string
+
+	for _, node := range knownNodes {
+		if node != addr {
+			updatedNodes = append(updatedNodes, node)
+		}
+	}
+
+	knownNodes = updatedNodes
+}
+
+// nodeIsKnown 节点地址是否在遗址节点列表中，调用者须持有knownNodesMtx
 func nodeIsKnown(addr string) bool {
 	for _, node := range knownNodes {
 		if node == addr {
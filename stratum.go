@@ -0,0 +1,576 @@
+package blockchain7
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const poolSharesFile = "pool_shares_%s.dat"
+
+const defaultShareBits = 16                //默认的share难度：哈希前16位为0，比区块难度低得多，小矿机也能频繁提交share
+const stratumJobRefresh = 30 * time.Second //交易池有变化时，最多每隔这么久下发一次新任务
+const stratumMaxJobs = 8                   //保留最近的任务数量，矿机提交稍旧任务的share仍然有效
+const stratumIdleTimeout = 10 * time.Minute
+const stratumMaxTimeDrift = 10 * time.Minute //share的ntime最多比当前时间晚这么久
+const stratumMaxLine = 64 * 1024
+
+// Stratum协议的错误码
+const (
+	stratumErrOther         = 20
+	stratumErrJobNotFound   = 21
+	stratumErrDuplicate     = 22
+	stratumErrLowDiff       = 23
+	stratumErrUnauthorized  = 24
+	stratumErrNotSubscribed = 25
+)
+
+// WorkerShares 一个矿工提交的share统计，按Shares的比例分配矿池收入
+type WorkerShares struct {
+	Shares    int   //有效的share数
+	Rejected  int   //被拒绝的share数
+	Blocks    int   //找到的区块数
+	LastShare int64 //最后一次提交有效share的时间（Unix时间戳）
+}
+
+// PoolShares 矿池的share统计，保存在文件中，节点运行时poolshares命令也能读到
+type PoolShares struct {
+	Workers map[string]*WorkerShares
+}
+
+// NewPoolShares 从文件读取share统计，文件不存在时返回空统计
+func NewPoolShares(nodeID string) *PoolShares {
+	shares := PoolShares{make(map[string]*WorkerShares)}
+	shares.LoadFromFile(nodeID)
+
+	return &shares
+}
+
+// LoadFromFile 从文件读取share统计
+func (ps *PoolShares) LoadFromFile(nodeID string) error {
+	poolSharesFile := fmt.Sprintf(poolSharesFile, nodeID)
+	if _, err := os.Stat(poolSharesFile); os.IsNotExist(err) {
+		return err
+	}
+
+	fileContent, err := ioutil.ReadFile(poolSharesFile)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var shares PoolShares
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	err = decoder.Decode(&shares)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	ps.Workers = shares.Workers
+	if ps.Workers == nil {
+		ps.Workers = make(map[string]*WorkerShares)
+	}
+
+	return nil
+}
+
+// SaveToFile 保存share统计到文件
+func (ps PoolShares) SaveToFile(nodeID string) {
+	var content bytes.Buffer
+
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(ps)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = ioutil.WriteFile(fmt.Sprintf(poolSharesFile, nodeID), content.Bytes(), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// worker 返回矿工的统计，不存在时创建
+func (ps *PoolShares) worker(name string) *WorkerShares {
+	w, ok := ps.Workers[name]
+	if !ok {
+		w = &WorkerShares{}
+		ps.Workers[name] = w
+	}
+
+	return w
+}
+
+// stratumJob 下发给矿机的挖矿任务
+type stratumJob struct {
+	ID   string
+	tmpl *BlockTemplate
+}
+
+// stratumConn 一个矿机连接
+// 每个连接有自己的extranonce，写入coinbase数据，所以同一个任务在不同连接上的Merkle根不同，矿机之间不会重复计算
+type stratumConn struct {
+	mtx        sync.Mutex
+	conn       net.Conn
+	encoder    *json.Encoder
+	extraNonce []byte
+	subscribed bool
+	workers    map[string]bool            //已授权的矿工名
+	coinbases  map[string]*Transaction    //任务ID->该连接的coinbase交易
+	seen       map[string]map[string]bool //任务ID->已提交的share（ntime:nonce），防止重复提交
+}
+
+// stratumRequest 矿机发来的请求
+type stratumRequest struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// stratumResponse 对请求的回复，Error为[错误码, 错误信息, null]
+type stratumResponse struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result"`
+	Error  interface{} `json:"error"`
+}
+
+// stratumNotification 服务器主动下发的通知，ID为null
+type stratumNotification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// stratumError 请求处理失败的原因
+type stratumError struct {
+	Code    int
+	Message string
+}
+
+func (e *stratumError) Error() string {
+	return e.Message
+}
+
+// result 转为Stratum协议的错误格式
+func (e *stratumError) result() []interface{} {
+	return []interface{}{e.Code, e.Message, nil}
+}
+
+// StratumServer 兼容Stratum v1协议（JSON over TCP，每行一个消息）的矿池服务器
+// 区块奖励支付给矿池地址，矿工按提交的share数分配收入
+//
+// 由于交易是gob编码的，矿机无法像比特币那样自己拼接coinbase交易，因此与标准协议有以下不同：
+//   - mining.subscribe返回的extranonce2长度为0，矿机只需要遍历nonce和ntime
+//   - mining.notify的参数为[任务ID, 父区块哈希, Merkle根, 区块高度, ntime, 是否丢弃旧任务]，
+//     Merkle根已经按该连接的extranonce计算好，矿机用HeaderHash计算区块头哈希
+//   - mining.set_difficulty的参数为share难度系数：哈希前N位为0
+//   - mining.submit的参数为[矿工名, 任务ID, extranonce2, ntime, nonce]，ntime和nonce为16进制
+type StratumServer struct {
+	mtx        sync.Mutex
+	bc         *Blockchain
+	address    string //接收区块奖励的矿池地址
+	shareBits  int
+	nodeID     string
+	jobs       []*stratumJob //最近的任务，最后一个是当前任务
+	jobSeq     int
+	conns      map[*stratumConn]bool
+	extraNonce uint32
+	shares     *PoolShares
+	changed    bool //上次保存之后share统计是否有变化
+	dirty      bool //交易池有变化，需要下发新任务
+	wake       chan struct{}
+}
+
+// stratum 矿池服务器，未启用时为nil
+var stratum *StratumServer
+
+// NewStratumServer 创建矿池服务器，区块奖励支付给address，share难度为shareBits
+func NewStratumServer(bc *Blockchain, address string, shareBits int, nodeID string) *StratumServer {
+	return &StratumServer{
+		bc:        bc,
+		address:   address,
+		shareBits: shareBits,
+		nodeID:    nodeID,
+		conns:     make(map[*stratumConn]bool),
+		shares:    NewPoolShares(nodeID),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Notify 通知矿池tip或交易池发生了变化
+func (s *StratumServer) Notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default: //已经有未处理的通知
+	}
+}
+
+// ListenAndServe 在addr监听矿机连接
+func (s *StratumServer) ListenAndServe(addr string) {
+	ln, err := net.Listen(protocol, addr)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer ln.Close()
+	fmt.Printf("矿池服务器监听 %s，share难度 %d\n", addr, s.shareBits)
+
+	s.newJob(true)
+	go s.run()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			fmt.Printf("矿池服务器接受连接失败: %s\n", err)
+			continue
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// run 根据tip和交易池的变化下发新任务，并定时保存share统计
+func (s *StratumServer) run() {
+	ticker := time.NewTicker(stratumJobRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.wake:
+			s.mtx.Lock()
+			current := s.jobs[len(s.jobs)-1]
+			s.mtx.Unlock()
+
+			last := s.bc.GetLastBlock()
+			if !bytes.Equal(current.tmpl.PrevBlockHash, last.Hash) {
+				s.newJob(true) //tip改变了，旧任务已经过时
+			} else {
+				s.mtx.Lock()
+				s.dirty = true
+				s.mtx.Unlock()
+			}
+		case <-ticker.C:
+			s.mtx.Lock()
+			dirty, changed := s.dirty, s.changed
+			s.mtx.Unlock()
+
+			if dirty {
+				s.newJob(false)
+			}
+			if changed {
+				s.SaveToFile()
+			}
+		}
+	}
+}
+
+// newJob 用新的区块模板创建任务，并下发给所有已订阅的矿机
+// clean为true时，之前的任务都已过时，矿机应当立即放弃
+func (s *StratumServer) newJob(clean bool) {
+	tmpl := NewBlockTemplate(s.bc, mempool)
+
+	s.mtx.Lock()
+	s.jobSeq++
+	job := &stratumJob{strconv.FormatInt(int64(s.jobSeq), 16), tmpl}
+	if clean {
+		s.jobs = nil
+	}
+	s.jobs = append(s.jobs, job)
+	if len(s.jobs) > stratumMaxJobs {
+		s.jobs = s.jobs[len(s.jobs)-stratumMaxJobs:]
+	}
+	s.dirty = false
+	conns := make([]*stratumConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mtx.Unlock()
+
+	for _, c := range conns {
+		s.sendJob(c, job, clean)
+	}
+}
+
+// findJob 查找最近的任务，任务已经过时时返回nil
+func (s *StratumServer) findJob(id string) *stratumJob {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, job := range s.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+
+	return nil
+}
+
+// sendJob 按连接的extranonce计算coinbase交易和Merkle根，把任务下发给矿机
+func (s *StratumServer) sendJob(c *stratumConn, job *stratumJob, clean bool) {
+	c.mtx.Lock()
+	if !c.subscribed {
+		c.mtx.Unlock()
+		return
+	}
+	if clean {
+		c.coinbases = make(map[string]*Transaction)
+		c.seen = make(map[string]map[string]bool)
+	}
+	coinbase := job.tmpl.Coinbase(s.address, c.extraNonce)
+	c.coinbases[job.ID] = coinbase
+	for id := range c.coinbases { //删除已经过时的任务和已提交的share
+		if s.findJob(id) == nil {
+			delete(c.coinbases, id)
+			delete(c.seen, id)
+		}
+	}
+	c.mtx.Unlock()
+
+	c.send(stratumNotification{nil, "mining.notify", []interface{}{
+		job.ID,
+		hex.EncodeToString(job.tmpl.PrevBlockHash),
+		hex.EncodeToString(job.tmpl.MerkleRoot(coinbase)),
+		job.tmpl.Height,
+		strconv.FormatInt(job.tmpl.Timestamp, 16),
+		clean,
+	}})
+}
+
+// send 向矿机发送一个JSON消息
+func (c *stratumConn) send(msg interface{}) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	err := c.encoder.Encode(msg)
+	if err != nil {
+		fmt.Printf("向矿机 %s 发送消息失败: %s\n", c.conn.RemoteAddr(), err)
+		c.conn.Close()
+	}
+}
+
+// handleConn 处理一个矿机连接，每行一个JSON请求
+func (s *StratumServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	s.mtx.Lock()
+	s.extraNonce++
+	extraNonce := make([]byte, 4)
+	extraNonce[0] = byte(s.extraNonce >> 24)
+	extraNonce[1] = byte(s.extraNonce >> 16)
+	extraNonce[2] = byte(s.extraNonce >> 8)
+	extraNonce[3] = byte(s.extraNonce)
+	c := &stratumConn{
+		conn:       conn,
+		encoder:    json.NewEncoder(conn),
+		extraNonce: extraNonce,
+		workers:    make(map[string]bool),
+		coinbases:  make(map[string]*Transaction),
+		seen:       make(map[string]map[string]bool),
+	}
+	s.conns[c] = true
+	s.mtx.Unlock()
+
+	defer func() {
+		s.mtx.Lock()
+		delete(s.conns, c)
+		s.mtx.Unlock()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), stratumMaxLine)
+	for {
+		conn.SetReadDeadline(time.Now().Add(stratumIdleTimeout))
+		if !scanner.Scan() {
+			return
+		}
+
+		var request stratumRequest
+		err := json.Unmarshal(scanner.Bytes(), &request)
+		if err != nil {
+			fmt.Printf("矿机 %s 发送了无法解析的消息: %s\n", conn.RemoteAddr(), err)
+			return
+		}
+
+		result, err := s.handleRequest(c, &request)
+		response := stratumResponse{ID: request.ID, Result: result}
+		if err != nil {
+			e, ok := err.(*stratumError)
+			if !ok {
+				e = &stratumError{stratumErrOther, err.Error()}
+			}
+			response.Error = e.result()
+		}
+		c.send(response)
+
+		if request.Method == "mining.subscribe" && err == nil { //订阅成功后立即下发share难度和当前任务
+			c.send(stratumNotification{nil, "mining.set_difficulty", []interface{}{s.shareBits}})
+			s.mtx.Lock()
+			job := s.jobs[len(s.jobs)-1]
+			s.mtx.Unlock()
+			s.sendJob(c, job, true)
+		}
+	}
+}
+
+// handleRequest 处理一个请求，返回回复的result
+func (s *StratumServer) handleRequest(c *stratumConn, request *stratumRequest) (interface{}, error) {
+	var params []string
+	for _, raw := range request.Params {
+		var param string
+		if json.Unmarshal(raw, &param) != nil { //非字符串参数（例如数字）保留原样
+			param = string(raw)
+		}
+		params = append(params, param)
+	}
+
+	switch request.Method {
+	case "mining.subscribe":
+		c.mtx.Lock()
+		c.subscribed = true
+		c.mtx.Unlock()
+
+		subscription := hex.EncodeToString(c.extraNonce)
+		return []interface{}{
+			[][]string{{"mining.set_difficulty", subscription}, {"mining.notify", subscription}},
+			hex.EncodeToString(c.extraNonce),
+			0, //extranonce2长度
+		}, nil
+	case "mining.authorize":
+		if len(params) < 1 || params[0] == "" {
+			return false, &stratumError{stratumErrOther, "缺少矿工名"}
+		}
+		c.mtx.Lock()
+		c.workers[params[0]] = true
+		c.mtx.Unlock()
+
+		return true, nil
+	case "mining.submit":
+		if len(params) < 5 {
+			return false, &stratumError{stratumErrOther, "参数应为[矿工名, 任务ID, extranonce2, ntime, nonce]"}
+		}
+		err := s.submitShare(c, params[0], params[1], params[3], params[4])
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	default:
+		return nil, &stratumError{stratumErrOther, fmt.Sprintf("不支持的方法%q", request.Method)}
+	}
+}
+
+// submitShare 验证矿机提交的share，满足区块难度时组装区块并提交到区块链
+func (s *StratumServer) submitShare(c *stratumConn, worker, jobID, ntime, nonce string) error {
+	c.mtx.Lock()
+	authorized, subscribed := c.workers[worker], c.subscribed
+	c.mtx.Unlock()
+	if !subscribed {
+		return &stratumError{stratumErrNotSubscribed, "尚未订阅"}
+	}
+	if !authorized {
+		return &stratumError{stratumErrUnauthorized, "矿工未授权"}
+	}
+
+	err := s.checkShare(c, worker, jobID, ntime, nonce)
+
+	s.mtx.Lock()
+	w := s.shares.worker(worker)
+	if err == nil {
+		w.Shares++
+		w.LastShare = time.Now().Unix()
+	} else {
+		w.Rejected++
+	}
+	s.changed = true
+	s.mtx.Unlock()
+
+	return err
+}
+
+// checkShare 检查share的难度，满足区块难度时提交区块
+func (s *StratumServer) checkShare(c *stratumConn, worker, jobID, ntimeHex, nonceHex string) error {
+	job := s.findJob(jobID)
+	c.mtx.Lock()
+	coinbase := c.coinbases[jobID]
+	c.mtx.Unlock()
+	if job == nil || coinbase == nil {
+		return &stratumError{stratumErrJobNotFound, "任务不存在或已经过时"}
+	}
+
+	ntime, err := strconv.ParseInt(ntimeHex, 16, 64)
+	if err != nil {
+		return &stratumError{stratumErrOther, "ntime格式错误"}
+	}
+	if ntime < job.tmpl.Timestamp || ntime > time.Now().Add(stratumMaxTimeDrift).Unix() {
+		return &stratumError{stratumErrOther, "ntime超出范围"}
+	}
+	nonce, err := strconv.ParseInt(nonceHex, 16, 64)
+	if err != nil || nonce < 0 {
+		return &stratumError{stratumErrOther, "nonce格式错误"}
+	}
+
+	key := fmt.Sprintf("%x:%x", ntime, nonce)
+	c.mtx.Lock()
+	_, current := c.coinbases[jobID] //检查期间任务可能已经过时，不再记录它的share
+	duplicate := c.seen[jobID][key]
+	if current && !duplicate {
+		if c.seen[jobID] == nil {
+			c.seen[jobID] = make(map[string]bool)
+		}
+		c.seen[jobID][key] = true
+	}
+	c.mtx.Unlock()
+	if !current {
+		return &stratumError{stratumErrJobNotFound, "任务不存在或已经过时"}
+	}
+	if duplicate {
+		return &stratumError{stratumErrDuplicate, "重复的share"}
+	}
+
+	hash := HeaderHash(job.tmpl.PrevBlockHash, job.tmpl.MerkleRoot(coinbase), ntime, int(nonce))
+	if !checkShareBits(hash, s.shareBits) {
+		return &stratumError{stratumErrLowDiff, "share难度不足"}
+	}
+
+	if job.tmpl.CheckHash(hash) {
+		block := job.tmpl.Block(coinbase, ntime, int(nonce))
+		err := submitBlock(s.bc, block)
+		if err != nil {
+			fmt.Printf("矿工 %s 找到的区块 %x 被拒绝: %s\n", worker, block.Hash, err)
+		} else {
+			fmt.Printf("矿工 %s 找到区块 %x，高度 %d\n", worker, block.Hash, block.Height)
+			s.mtx.Lock()
+			s.shares.worker(worker).Blocks++
+			s.mtx.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// checkShareBits 检查哈希前bits位是否为0
+func checkShareBits(hash []byte, bits int) bool {
+	for i := 0; i < bits; i++ {
+		if hash[i/8]&(0x80>>uint(i%8)) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SaveToFile 保存share统计到文件
+func (s *StratumServer) SaveToFile() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.shares.SaveToFile(s.nodeID)
+	s.changed = false
+}
@@ -0,0 +1,255 @@
+package blockchain7
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stratumMessage 矿机收到的消息：回复或通知
+type stratumMessage struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  []interface{}     `json:"error"`
+}
+
+// testMiner 通过TCP连接矿池的矿机
+type testMiner struct {
+	t             *testing.T
+	conn          net.Conn
+	scanner       *bufio.Scanner
+	seq           int
+	notifications []stratumMessage
+}
+
+// newTestStratum 创建share难度为shareBits的矿池服务器并下发第一个任务，连接一个矿机
+func newTestStratum(t *testing.T, bc *Blockchain, address string, shareBits int) (*StratumServer, *testMiner) {
+	s := NewStratumServer(bc, address, shareBits, "test")
+	s.newJob(true)
+
+	ln, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			s.handleConn(conn)
+		}
+	}()
+
+	conn, err := net.Dial(protocol, ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return s, &testMiner{t: t, conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+// call 发送请求并等待回复，期间收到的通知保存在notifications中
+func (m *testMiner) call(method string, params ...interface{}) stratumMessage {
+	m.t.Helper()
+
+	m.seq++
+	request, _ := json.Marshal(map[string]interface{}{"id": m.seq, "method": method, "params": params})
+	if _, err := m.conn.Write(append(request, '\n')); err != nil {
+		m.t.Fatal(err)
+	}
+
+	for {
+		msg := m.read()
+		if msg.ID == nil {
+			m.notifications = append(m.notifications, msg)
+			continue
+		}
+		assert.Equal(m.t, float64(m.seq), msg.ID)
+		return msg
+	}
+}
+
+// read 读取下一个消息
+func (m *testMiner) read() stratumMessage {
+	m.t.Helper()
+
+	if !m.scanner.Scan() {
+		m.t.Fatalf("连接已关闭: %v", m.scanner.Err())
+	}
+	var msg stratumMessage
+	if err := json.Unmarshal(m.scanner.Bytes(), &msg); err != nil {
+		m.t.Fatal(err)
+	}
+
+	return msg
+}
+
+// job 收到的最后一个mining.notify任务：任务ID、父区块哈希、Merkle根和ntime
+func (m *testMiner) job() (string, []byte, []byte, int64) {
+	m.t.Helper()
+
+	for i := len(m.notifications) - 1; i >= 0; i-- {
+		n := m.notifications[i]
+		if n.Method != "mining.notify" {
+			continue
+		}
+		var id, prev, root, ntime string
+		json.Unmarshal(n.Params[0], &id)
+		json.Unmarshal(n.Params[1], &prev)
+		json.Unmarshal(n.Params[2], &root)
+		json.Unmarshal(n.Params[4], &ntime)
+		prevHash, _ := hex.DecodeString(prev)
+		merkleRoot, _ := hex.DecodeString(root)
+		timestamp, _ := strconv.ParseInt(ntime, 16, 64)
+		return id, prevHash, merkleRoot, timestamp
+	}
+	m.t.Fatal("没有收到任务")
+
+	return "", nil, nil, 0
+}
+
+// findNonce 返回第一个满足条件的nonce：enough为true时哈希满足bits位难度（但不满足区块难度），否则不满足
+func findNonce(prev, root []byte, ntime int64, bits int, enough bool) int {
+	for nonce := 0; ; nonce++ {
+		hash := HeaderHash(prev, root, ntime, nonce)
+		if checkShareBits(hash, bits) == enough && !checkShareBits(hash, targetBits) {
+			return nonce
+		}
+	}
+}
+
+// errorCode 回复中的错误码，没有错误时为0
+func errorCode(msg stratumMessage) int {
+	if len(msg.Error) == 0 {
+		return 0
+	}
+
+	return int(msg.Error[0].(float64))
+}
+
+func TestStratumShares(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	address := string(wallet.GetAddress())
+	s, m := newTestStratum(t, bc, address, 8)
+
+	//订阅之前不能提交share
+	m.call("mining.authorize", "alice", "x")
+	assert.Equal(t, stratumErrNotSubscribed, errorCode(m.call("mining.submit", "alice", "1", "", "0", "0")))
+
+	//订阅后收到share难度和任务，任务的Merkle根按该连接的extranonce计算
+	subscribed := m.call("mining.subscribe")
+	assert.Equal(t, 0, errorCode(subscribed))
+	var result []json.RawMessage
+	assert.NoError(t, json.Unmarshal(subscribed.Result, &result))
+	var extraNonce string
+	json.Unmarshal(result[1], &extraNonce)
+	m.call("mining.authorize", "alice", "x")
+	assert.Equal(t, "mining.set_difficulty", m.notifications[0].Method)
+	assert.JSONEq(t, "8", string(m.notifications[0].Params[0]))
+
+	jobID, prev, root, ntime := m.job()
+	extra, _ := hex.DecodeString(extraNonce)
+	tmpl := s.findJob(jobID).tmpl
+	assert.Equal(t, bc.Tip, prev)
+	assert.Equal(t, tmpl.MerkleRoot(tmpl.Coinbase(address, extra)), root)
+
+	good := strconv.FormatInt(int64(findNonce(prev, root, ntime, 8, true)), 16)
+	low := strconv.FormatInt(int64(findNonce(prev, root, ntime, 8, false)), 16)
+	ntimeHex := strconv.FormatInt(ntime, 16)
+
+	cases := []struct {
+		name   string
+		params []interface{}
+		code   int
+	}{
+		{"valid share", []interface{}{"alice", jobID, "", ntimeHex, good}, 0},
+		{"duplicate share", []interface{}{"alice", jobID, "", ntimeHex, good}, stratumErrDuplicate},
+		{"low difficulty", []interface{}{"alice", jobID, "", ntimeHex, low}, stratumErrLowDiff},
+		{"unknown job", []interface{}{"alice", "ff", "", ntimeHex, good}, stratumErrJobNotFound},
+		{"ntime before template", []interface{}{"alice", jobID, "", strconv.FormatInt(ntime-1, 16), good}, stratumErrOther},
+		{"malformed nonce", []interface{}{"alice", jobID, "", ntimeHex, "xyz"}, stratumErrOther},
+		{"unauthorized worker", []interface{}{"bob", jobID, "", ntimeHex, good}, stratumErrUnauthorized},
+		{"too few params", []interface{}{"alice", jobID}, stratumErrOther},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.code, errorCode(m.call("mining.submit", c.params...)))
+		})
+	}
+
+	//被拒绝的share也计入矿工的统计，未授权的矿工没有统计
+	s.mtx.Lock()
+	assert.Equal(t, 1, s.shares.Workers["alice"].Shares)
+	assert.Equal(t, 5, s.shares.Workers["alice"].Rejected)
+	assert.Equal(t, 0, s.shares.Workers["alice"].Blocks)
+	assert.NotContains(t, s.shares.Workers, "bob")
+	s.mtx.Unlock()
+
+	//share统计保存在文件中
+	s.SaveToFile()
+	saved := NewPoolShares("test")
+	assert.Equal(t, 1, saved.Workers["alice"].Shares)
+	assert.Equal(t, 5, saved.Workers["alice"].Rejected)
+}
+
+func TestStratumCleanJobMakesOldSharesStale(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	s, m := newTestStratum(t, bc, string(wallet.GetAddress()), 8)
+	m.call("mining.subscribe")
+	m.call("mining.authorize", "alice", "x")
+	jobID, prev, root, ntime := m.job()
+	nonce := strconv.FormatInt(int64(findNonce(prev, root, ntime, 8, true)), 16)
+
+	//tip改变后下发新任务，矿机收到要求丢弃旧任务的通知
+	genesis := bc.GetLastBlock()
+	assert.NoError(t, connectTestBlocks(newTestBlock(t, &genesis, wallet, "tip")))
+	s.newJob(true)
+	notify := m.read()
+	assert.Equal(t, "mining.notify", notify.Method)
+	assert.JSONEq(t, "true", string(notify.Params[5]))
+	m.notifications = append(m.notifications, notify)
+	newJobID, newPrev, _, _ := m.job()
+	assert.NotEqual(t, jobID, newJobID)
+	assert.Equal(t, bc.Tip, newPrev)
+
+	assert.Equal(t, stratumErrJobNotFound, errorCode(m.call("mining.submit", "alice", jobID, "", strconv.FormatInt(ntime, 16), nonce)))
+}
+
+func TestStratumExpiredJobsForgetShares(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	s, m := newTestStratum(t, bc, string(wallet.GetAddress()), 8)
+	m.call("mining.subscribe")
+	m.call("mining.authorize", "alice", "x")
+	jobID, prev, root, ntime := m.job()
+	nonce := strconv.FormatInt(int64(findNonce(prev, root, ntime, 8, true)), 16)
+	assert.Equal(t, 0, errorCode(m.call("mining.submit", "alice", jobID, "", strconv.FormatInt(ntime, 16), nonce)))
+
+	var c *stratumConn
+	s.mtx.Lock()
+	for conn := range s.conns {
+		c = conn
+	}
+	s.mtx.Unlock()
+	c.mtx.Lock()
+	assert.Len(t, c.seen[jobID], 1)
+	c.mtx.Unlock()
+
+	//不要求丢弃旧任务的新任务使最早的任务过时，过时任务的share记录一起删除
+	for i := 0; i < stratumMaxJobs; i++ {
+		s.newJob(false)
+		assert.Equal(t, "mining.notify", m.read().Method)
+	}
+	assert.Nil(t, s.findJob(jobID))
+	c.mtx.Lock()
+	assert.NotContains(t, c.seen, jobID)
+	assert.NotContains(t, c.coinbases, jobID)
+	assert.Len(t, c.coinbases, stratumMaxJobs)
+	c.mtx.Unlock()
+}
@@ -0,0 +1,476 @@
+package blockchain7
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const maxHeadersPerMsg = 500                  //一个headers消息最多包含的区块头数量
+const blockDownloadWindow = 64                //下载滑动窗口：只请求待连接队列中最靠前的这些区块
+const maxBlocksInFlightPerPeer = 8            //每个节点同时最多下载的区块数量
+const blockDownloadTimeout = 20 * time.Second //区块请求超时后，改向其他节点请求
+const syncTickInterval = time.Second          //检查超时请求的间隔
+
+// blockRequest 一个正在下载中的区块请求
+type blockRequest struct {
+	peer      string    //向哪个节点请求的
+	requested time.Time //请求发出的时间
+}
+
+// syncManager 负责headers-first的初始区块下载
+// 先通过getheaders/headers下载并验证区块头，然后在滑动窗口内向多个节点并行请求区块，
+// 乱序到达的区块暂存起来，按高度顺序连接到本地区块链，并更新UTXO集
+type syncManager struct {
+	mtx         sync.Mutex
+	bc          *Blockchain
+	peerHeights map[string]int          //已知节点的最佳高度
+	headers     map[string]*BlockHeader //已验证、但区块尚未连接的区块头
+	pending     [][]byte                //待连接区块的哈希，按高度从低到高排列
+	inFlight    map[string]*blockRequest
+	received    map[string]*Block //已收到但还不能连接的区块（父区块尚未连接）
+	stalled     map[string]string //区块哈希->请求超时的节点，重新请求时尽量避开
+	accepted    []*Transaction    //连接区块后进入交易池、尚未转发的交易
+	tipChanged  bool              //tip改变后尚未通知挖矿协程和矿池
+}
+
+var syncer *syncManager
+
+var errUnconnectedHeader = errors.New("区块头的父区块未知")
+
+// newSyncManager 创建同步管理器
+func newSyncManager(bc *Blockchain) *syncManager {
+	return &syncManager{
+		bc:          bc,
+		peerHeights: make(map[string]int),
+		headers:     make(map[string]*BlockHeader),
+		inFlight:    make(map[string]*blockRequest),
+		received:    make(map[string]*Block),
+		stalled:     make(map[string]string),
+	}
+}
+
+// updatePeer 记录某个节点的最佳高度，只会增大
+func (s *syncManager) updatePeer(addr string, height int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if current, ok := s.peerHeights[addr]; !ok || height > current {
+		s.peerHeights[addr] = height
+	}
+}
+
+// startSync 向某个节点请求区块头
+// 定位器以已下载的最新区块头开头，这样对方会从我们已知的最新区块头之后继续发送
+func (s *syncManager) startSync(addr string) {
+	s.mtx.Lock()
+	var locator [][]byte
+	if len(s.pending) > 0 {
+		locator = append(locator, s.pending[len(s.pending)-1])
+	}
+	s.mtx.Unlock()
+
+	locator = append(locator, s.bc.GetBlockLocator()...)
+	sendGetHeaders(addr, locator, nil)
+}
+
+// isKnown 区块头是否已经下载过，或者区块已经在本地区块链中
+func (s *syncManager) isKnown(hash []byte) bool {
+	if _, ok := s.headers[hex.EncodeToString(hash)]; ok {
+		return true
+	}
+
+	return s.bc.HasBlock(hash)
+}
+
+// parentHeight 返回父区块的高度，父区块可以是已下载的区块头，也可以是本地区块
+func (s *syncManager) parentHeight(prevHash []byte) (int, bool) {
+	if h, ok := s.headers[hex.EncodeToString(prevHash)]; ok {
+		return h.Height, true
+	}
+
+	block, err := s.bc.GetBlock(prevHash)
+	if err != nil {
+		return 0, false
+	}
+
+	return block.Height, true
+}
+
+// processHeaders 验证从addr收到的区块头，并把新的区块头加入下载队列
+// 区块头必须首尾相连、高度连续且满足工作量证明，遇到非法区块头时停止处理并返回错误
+func (s *syncManager) processHeaders(addr string, headers []BlockHeader) error {
+	s.mtx.Lock()
+
+	var err error
+	for i := range headers {
+		h := headers[i]
+		if s.isKnown(h.Hash) {
+			continue
+		}
+
+		height, ok := s.parentHeight(h.PrevBlockHash)
+		if !ok {
+			err = fmt.Errorf("%w: %x", errUnconnectedHeader, h.Hash)
+			break
+		}
+		if h.Height != height+1 {
+			err = fmt.Errorf("区块头 %x 的高度不正确", h.Hash)
+			break
+		}
+		if !consensus.VerifyHeader(&h) {
+			err = fmt.Errorf("区块头 %x 不符合%s共识规则", h.Hash, consensus.Name())
+			break
+		}
+
+		s.headers[hex.EncodeToString(h.Hash)] = &h
+		s.pending = append(s.pending, h.Hash)
+	}
+
+	if len(headers) > 0 && headers[len(headers)-1].Height > s.peerHeights[addr] {
+		s.peerHeights[addr] = headers[len(headers)-1].Height //对方既然发来这些区块头，它至少拥有这么高的区块
+	}
+	s.mtx.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if len(headers) == maxHeadersPerMsg { //对方可能还有更多区块头
+		s.startSync(addr)
+	}
+	s.fillWindow()
+
+	return nil
+}
+
+// pickPeer 为高度为height的区块选择一个下载节点：拥有该区块、未满负荷且正在下载的区块最少
+// 如果有其他选择，避开该区块上次请求超时的节点
+func (s *syncManager) pickPeer(height int, avoid string, load map[string]int) string {
+	best := ""
+	for peer, peerHeight := range s.peerHeights {
+		if peerHeight < height || load[peer] >= maxBlocksInFlightPerPeer {
+			continue
+		}
+		if peer == avoid && len(s.peerHeights) > 1 {
+			continue
+		}
+		if best == "" || load[peer] < load[best] {
+			best = peer
+		}
+	}
+
+	return best
+}
+
+// fillWindow 在滑动窗口内为尚未请求的区块分配下载节点并发出getdata请求
+func (s *syncManager) fillWindow() {
+	type request struct {
+		peer string
+		hash []byte
+	}
+	var requests []request
+
+	s.mtx.Lock()
+	load := make(map[string]int)
+	for _, r := range s.inFlight {
+		load[r.peer]++
+	}
+
+	window := s.pending
+	if len(window) > blockDownloadWindow {
+		window = window[:blockDownloadWindow]
+	}
+
+	for _, hash := range window {
+		key := hex.EncodeToString(hash)
+		if s.inFlight[key] != nil || s.received[key] != nil {
+			continue
+		}
+
+		peer := s.pickPeer(s.headers[key].Height, s.stalled[key], load)
+		if peer == "" {
+			break
+		}
+		load[peer]++
+		s.inFlight[key] = &blockRequest{peer, time.Now()}
+		requests = append(requests, request{peer, hash})
+	}
+	s.mtx.Unlock()
+
+	//网络请求不持有锁
+	for _, r := range requests {
+		sendGetData(r.peer, "block", r.hash)
+	}
+}
+
+// processBlock 处理从addr收到的区块
+// 如果区块不是同步过程中请求的，返回false，由调用者按新区块广播处理
+func (s *syncManager) processBlock(addr string, block *Block) (bool, error) {
+	solicited, remaining, err := s.receiveBlock(addr, block)
+	s.announce()
+	if !solicited {
+		return false, nil
+	}
+
+	if err == nil && remaining == 0 {
+		fmt.Printf("区块同步完成，当前高度 %d\n", s.bc.GetBestHeight())
+	}
+	s.fillWindow()
+
+	return true, err
+}
+
+// receiveBlock 校验区块与已下载的区块头一致，然后按高度顺序连接所有父区块已经连接的区块
+// 返回区块是否是同步过程中请求的，以及还有多少区块等待连接
+func (s *syncManager) receiveBlock(addr string, block *Block) (bool, int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	key := hex.EncodeToString(block.Hash)
+	header := s.headers[key]
+	if header == nil || s.received[key] != nil {
+		return false, 0, nil
+	}
+	delete(s.inFlight, key)
+
+	if bytes.Compare(block.HashTransactions(), header.MerkleRoot) != 0 ||
+		bytes.Compare(block.PrevBlockHash, header.PrevBlockHash) != 0 ||
+		block.Timestamp != header.Timestamp || block.Nonce != header.Nonce || block.Height != header.Height ||
+		bytes.Compare(block.Signer, header.Signer) != 0 || bytes.Compare(block.Signature, header.Signature) != 0 {
+		s.stalled[key] = addr //从其他节点重新请求
+		return true, len(s.pending), errors.New("区块与已验证的区块头不一致")
+	}
+
+	s.received[key] = block
+	delete(s.stalled, key)
+
+	for len(s.pending) > 0 {
+		next := hex.EncodeToString(s.pending[0])
+		b := s.received[next]
+		if b == nil {
+			break
+		}
+
+		err := s.connectBlock(b)
+		if err != nil { //后面的区块都以它为祖先，全部放弃
+			s.headers = make(map[string]*BlockHeader)
+			s.pending = nil
+			s.inFlight = make(map[string]*blockRequest)
+			s.received = make(map[string]*Block)
+			s.stalled = make(map[string]string)
+			return true, 0, fmt.Errorf("区块 %x 不符合%s共识规则: %s", b.Hash, consensus.Name(), err)
+		}
+		delete(s.received, next)
+		delete(s.headers, next)
+		s.pending = s.pending[1:]
+	}
+
+	return true, len(s.pending), nil
+}
+
+// connectBlock 将区块加入本地区块链并更新UTXO集和交易池，调用者需持有锁
+// 区块延伸当前tip时先用checkBlock完整验证（交易、锁定时间、签名、coinbase金额和共识规则），不合法时不连接；
+// 分支上的区块先只保存，分叉点之后分支的累计权重超过当前链时由reorganize逐个验证后切换，tip永远不会指向未经验证的区块
+// 需要网络通信的通知由announce在释放锁之后完成
+func (s *syncManager) connectBlock(block *Block) error {
+	var connected, disconnected []*Block
+	if bytes.Equal(block.PrevBlockHash, s.bc.Tip) {
+		err := checkBlock(s.bc, block)
+		if err != nil {
+			return err
+		}
+		s.bc.saveBlock(block)
+		s.bc.setTip(block.Hash)
+		UTXOSet{s.bc}.Update(block)
+		connected = append(connected, block)
+	} else {
+		s.bc.saveBlock(block)
+		var err error
+		connected, disconnected, err = s.reorganize(block)
+		if err != nil {
+			return err
+		}
+		if connected == nil { //分支的累计权重没有超过主链
+			return nil
+		}
+	}
+
+	for _, b := range connected {
+		//删除已上链的交易和与之冲突的交易，父交易上链的孤儿交易进入交易池
+		s.accepted = append(s.accepted, mempool.BlockConnected(b)...)
+	}
+	//断开的区块中的交易放回交易池，已经在新分支上或与新分支冲突的交易会被拒绝
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			if err := mempool.Add(tx); err == nil {
+				s.accepted = append(s.accepted, tx)
+			}
+		}
+	}
+	for _, b := range disconnected { //全部放回之后，删除花费已不存在的输出的交易
+		mempool.BlockDisconnected(b)
+	}
+	s.tipChanged = true
+
+	fmt.Printf("连接区块 %x，高度 %d\n", block.Hash, block.Height)
+
+	return nil
+}
+
+// announce 连接区块之后，在不持有锁的情况下转发进入交易池的交易，并通知挖矿协程和矿池tip已经改变
+func (s *syncManager) announce() {
+	s.mtx.Lock()
+	accepted, tipChanged := s.accepted, s.tipChanged
+	s.accepted, s.tipChanged = nil, false
+	s.mtx.Unlock()
+
+	relayTransactions(accepted, "")
+	if !tipChanged {
+		return
+	}
+	if miner != nil { //tip改变了，正在挖的区块已经过时
+		miner.Notify()
+	}
+	if stratum != nil { //矿机正在做的任务已经过时
+		stratum.Notify()
+	}
+}
+
+// reorganize 以block结尾的分支的累计权重超过当前链时切换到该分支，返回连接的区块（按高度从低到高）和断开的原主链区块（按高度从高到低），不切换时都返回nil
+// 从两条链的tip同时向前回溯找到分叉点，比较分叉点之后两条链的累计权重（见ConsensusEngine.Weight），相同时按forkChooser选择，
+// 只处理分叉点之后的区块：先用UTXOSet.Disconnect逐个撤销原主链区块对UTXO集的修改，
+// 再按高度顺序用checkBlock验证并连接分支上的每个区块，这样每个区块都按父区块之后的状态（包括权益证明的权益分布）验证；
+// 任何一个区块不合法时，从数据库删除它和分支上以它为祖先的区块，恢复原来的主链并返回错误
+func (s *syncManager) reorganize(block *Block) ([]*Block, []*Block, error) {
+	var branch []*Block   //分叉点之后的分支区块，按高度从高到低
+	var detached []*Block //分叉点之后的原主链区块，按高度从高到低
+	tip := s.bc.GetLastBlock()
+	main, side := &tip, block
+	for !bytes.Equal(main.Hash, side.Hash) {
+		if side.Height >= main.Height {
+			branch = append(branch, side)
+			parent, err := s.bc.GetBlock(side.PrevBlockHash)
+			if err != nil {
+				return nil, nil, err
+			}
+			side = &parent
+		} else {
+			detached = append(detached, main)
+			parent, err := s.bc.GetBlock(main.PrevBlockHash)
+			if err != nil {
+				return nil, nil, err
+			}
+			main = &parent
+		}
+	}
+
+	cmp := chainWork(branch).Cmp(chainWork(detached))
+	if cmp < 0 || cmp == 0 && !preferBranch(branch, detached) { //权重相同时由共识引擎决定，默认保留先收到的链
+		return nil, nil, nil
+	}
+
+	UTXOSet := UTXOSet{s.bc}
+	for _, b := range detached {
+		UTXOSet.Disconnect(b)
+		s.bc.setTip(b.PrevBlockHash)
+	}
+
+	var connected []*Block
+	for i := len(branch) - 1; i >= 0; i-- {
+		b := branch[i]
+		err := checkBlock(s.bc, b)
+		if err != nil {
+			for _, invalid := range branch[:i+1] {
+				s.bc.deleteBlock(invalid.Hash)
+			}
+			for j := len(connected) - 1; j >= 0; j-- {
+				UTXOSet.Disconnect(connected[j])
+				s.bc.setTip(connected[j].PrevBlockHash)
+			}
+			for j := len(detached) - 1; j >= 0; j-- {
+				s.bc.setTip(detached[j].Hash)
+				UTXOSet.Update(detached[j])
+			}
+			return nil, nil, fmt.Errorf("分支上的区块 %x: %w", b.Hash, err)
+		}
+		s.bc.setTip(b.Hash)
+		UTXOSet.Update(b)
+		connected = append(connected, b)
+	}
+	fmt.Printf("切换到分支，分叉点 %x，断开 %d 个区块，连接 %d 个区块\n", main.Hash, len(detached), len(connected))
+
+	return connected, detached, nil
+}
+
+// connectMinedBlock 连接本节点挖出或外部挖矿程序提交的区块，与同步下载的区块共用同一把锁，保证区块按顺序连接
+// 如果挖矿期间tip已经改变，区块已经过时，不连接并返回errStaleBlock；区块不合法时返回checkBlock的错误
+func (s *syncManager) connectMinedBlock(block *Block) error {
+	defer s.announce() //在释放锁之后执行
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if bytes.Compare(block.PrevBlockHash, s.bc.Tip) != 0 {
+		return errStaleBlock
+	}
+
+	return s.connectBlock(block)
+}
+
+// isSyncing 是否还有已下载区块头、但区块尚未连接的区块，同步期间挖矿节点暂停挖矿
+func (s *syncManager) isSyncing() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return len(s.pending) > 0
+}
+
+// removePeer 不再从该节点下载区块，它正在下载的区块交给其他节点
+func (s *syncManager) removePeer(addr string) {
+	s.mtx.Lock()
+	delete(s.peerHeights, addr)
+	for key, r := range s.inFlight {
+		if r.peer == addr {
+			delete(s.inFlight, key)
+		}
+	}
+	s.mtx.Unlock()
+
+	s.fillWindow()
+}
+
+// checkTimeouts 将超时的区块请求放回队列，交给其他节点下载
+func (s *syncManager) checkTimeouts() {
+	s.mtx.Lock()
+	now := time.Now()
+	timedOut := 0
+	for key, r := range s.inFlight {
+		if now.Sub(r.requested) > blockDownloadTimeout {
+			fmt.Printf("区块 %s 从 %s 下载超时\n", key, r.peer)
+			s.stalled[key] = r.peer
+			delete(s.inFlight, key)
+			timedOut++
+		}
+	}
+	s.mtx.Unlock()
+
+	if timedOut > 0 {
+		s.fillWindow()
+	}
+}
+
+// run 定时检查超时的区块请求
+func (s *syncManager) run() {
+	ticker := time.NewTicker(syncTickInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.checkTimeouts()
+	}
+}
@@ -0,0 +1,241 @@
+package blockchain7
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncHeadersFirst(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	b1 := newTestBlock(t, &genesis, wallet, "b1")
+	b2 := newTestBlock(t, b1, wallet, "b2")
+	b3 := newTestBlock(t, b2, wallet, "b3")
+	headers := []BlockHeader{b1.Header(), b2.Header(), b3.Header()}
+
+	peer := newTestPeer(t)
+	syncer.updatePeer(peer.addr, 3)
+	assert.NoError(t, syncer.processHeaders(peer.addr, headers))
+	assert.True(t, syncer.isSyncing())
+
+	//三个区块同时向节点请求
+	requested := make(map[string]bool)
+	for range headers {
+		var payload getdata
+		peer.expect(t, "getdata", &payload)
+		assert.Equal(t, "block", payload.Type)
+		requested[string(payload.ID)] = true
+	}
+	peer.expectNone(t)
+	for _, b := range []*Block{b1, b2, b3} {
+		assert.True(t, requested[string(b.Hash)])
+	}
+
+	//乱序到达的区块等父区块连接后才连接
+	solicited, err := syncer.processBlock(peer.addr, b3)
+	assert.True(t, solicited)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, bc.GetBestHeight())
+
+	_, err = syncer.processBlock(peer.addr, b1)
+	assert.NoError(t, err)
+	assert.Equal(t, b1.Hash, bc.Tip)
+
+	_, err = syncer.processBlock(peer.addr, b2)
+	assert.NoError(t, err)
+	assert.Equal(t, b3.Hash, bc.Tip)
+	assert.False(t, syncer.isSyncing())
+	utxo := utxoContents(t, bc)
+	UTXOSet{bc}.Reindex()
+	assert.Equal(t, utxo, utxoContents(t, bc), "UTXO set is updated for every block including the last")
+
+	//已经连接的区块不是请求的区块
+	solicited, err = syncer.processBlock(peer.addr, b3)
+	assert.False(t, solicited)
+	assert.NoError(t, err)
+}
+
+func TestSyncRejectsInvalidHeaders(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	b1 := newTestBlock(t, &genesis, wallet, "b1")
+	b2 := newTestBlock(t, b1, wallet, "b2")
+
+	wrongHeight := b1.Header()
+	wrongHeight.Height = 2
+	badSeal := b1.Header()
+	badSeal.Timestamp++
+	outsider := NewWallet()
+	notInTurn := *b1
+	notInTurn.Signer = outsider.PublicKey
+	notInTurn.Hash = sealHash(notInTurn.PrevBlockHash, notInTurn.HashTransactions(), notInTurn.Timestamp, notInTurn.Height, notInTurn.Signer)
+	notInTurn.Signature = signHash(outsider.PrivateKey, notInTurn.Hash)
+
+	cases := []struct {
+		name    string
+		headers []BlockHeader
+		err     error
+	}{
+		{"unconnected", []BlockHeader{b2.Header()}, errUnconnectedHeader},
+		{"wrong height", []BlockHeader{wrongHeight}, nil},
+		{"hash does not match", []BlockHeader{badSeal}, nil},
+		{"signer not in turn", []BlockHeader{notInTurn.Header()}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := syncer.processHeaders("peer", c.headers)
+			assert.Error(t, err)
+			if c.err != nil {
+				assert.ErrorIs(t, err, c.err)
+			}
+			assert.False(t, syncer.isSyncing(), "invalid headers are not queued for download")
+		})
+	}
+}
+
+func TestSyncRejectsBlockNotMatchingHeader(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	b1 := newTestBlock(t, &genesis, wallet, "b1")
+	other := newTestBlock(t, &genesis, wallet, "other")
+
+	assert.NoError(t, syncer.processHeaders("peer", []BlockHeader{b1.Header()}))
+	forged := *other
+	forged.Hash = b1.Hash //哈希与区块头相同，内容不同
+	solicited, err := syncer.processBlock("peer", &forged)
+	assert.True(t, solicited)
+	assert.Error(t, err)
+	assert.Equal(t, genesis.Hash, bc.Tip)
+	assert.Equal(t, "peer", syncer.stalled[hex.EncodeToString(b1.Hash)], "block is requested again from another peer")
+}
+
+func TestReorganize(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	cb := genesis.Transactions[0]
+
+	//主链的区块a1包含一个普通交易，分支更长，不包含该交易
+	tx := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 9)
+	a1 := newTestBlock(t, &genesis, wallet, "a1", tx)
+	a2 := newTestBlock(t, a1, wallet, "a2")
+	assert.NoError(t, connectTestBlocks(a1, a2))
+	assert.False(t, mempool.Has(tx.ID))
+
+	b1 := newTestBlock(t, &genesis, wallet, "b1")
+	b2 := newTestBlock(t, b1, wallet, "b2")
+	b3 := newTestBlock(t, b2, wallet, "b3")
+	assert.NoError(t, connectTestBlocks(b1, b2))
+	assert.Equal(t, a2.Hash, bc.Tip, "branch is not longer yet")
+
+	assert.NoError(t, connectTestBlocks(b3))
+	assert.Equal(t, b3.Hash, bc.Tip)
+	assert.True(t, mempool.Has(tx.ID), "transaction of the disconnected block returns to the mempool")
+
+	//逐个断开和连接区块得到的UTXO集与重建的相同
+	utxo := utxoContents(t, bc)
+	UTXOSet{bc}.Reindex()
+	assert.Equal(t, utxo, utxoContents(t, bc))
+
+	//交易再次上链时从交易池中删除
+	c1 := newTestBlock(t, b3, wallet, "c1", tx)
+	assert.NoError(t, connectTestBlocks(c1))
+	assert.False(t, mempool.Has(tx.ID))
+}
+
+func TestReorganizeInvalidBranch(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	a1 := newTestBlock(t, &genesis, wallet, "a1")
+	a2 := newTestBlock(t, a1, wallet, "a2")
+	assert.NoError(t, connectTestBlocks(a1, a2))
+	utxo := utxoContents(t, bc)
+
+	//b2的coinbase交易领取的金额超过挖矿奖励
+	b1 := newTestBlock(t, &genesis, wallet, "b1")
+	overpaid := NewCoinbaseTX(string(wallet.GetAddress()), "b2", 5)
+	b2 := NewBlock([]*Transaction{overpaid}, b1.Hash, b1.Height+1)
+	b3 := newTestBlock(t, b2, wallet, "b3")
+	assert.NoError(t, connectTestBlocks(b1, b2))
+
+	assert.ErrorIs(t, connectTestBlocks(b3), ErrBlockInvalidTx)
+	assert.Equal(t, a2.Hash, bc.Tip, "original chain is restored")
+	assert.Equal(t, utxo, utxoContents(t, bc))
+	assert.True(t, bc.HasBlock(b1.Hash), "valid branch block is kept")
+	assert.False(t, bc.HasBlock(b2.Hash), "invalid block is deleted")
+	assert.False(t, bc.HasBlock(b3.Hash), "descendant of the invalid block is deleted")
+}
+
+// weightedEngine 按区块哈希指定权重的共识引擎，没有指定的区块权重为1
+type weightedEngine struct {
+	ConsensusEngine
+	weights map[string]int64
+}
+
+func (e weightedEngine) Weight(h *BlockHeader) *big.Int {
+	if w, ok := e.weights[string(h.Hash)]; ok {
+		return big.NewInt(w)
+	}
+
+	return big.NewInt(1)
+}
+
+func TestForkChoiceUsesCumulativeWeight(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	engine := weightedEngine{consensus, make(map[string]int64)}
+	consensus = engine
+
+	//主链a1的权重为3，更长的分支b1、b2累计权重只有2，不切换
+	a1 := newTestBlock(t, &genesis, wallet, "a1")
+	engine.weights[string(a1.Hash)] = 3
+	b1 := newTestBlock(t, &genesis, wallet, "b1")
+	b2 := newTestBlock(t, b1, wallet, "b2")
+	assert.NoError(t, connectTestBlocks(a1, b1, b2))
+	assert.Equal(t, a1.Hash, bc.Tip, "longer branch is lighter")
+
+	//累计权重相同时保留先收到的链
+	b3 := newTestBlock(t, b2, wallet, "b3")
+	assert.NoError(t, connectTestBlocks(b3))
+	assert.Equal(t, a1.Hash, bc.Tip, "equal work keeps the current tip")
+
+	b4 := newTestBlock(t, b3, wallet, "b4")
+	assert.NoError(t, connectTestBlocks(b4))
+	assert.Equal(t, b4.Hash, bc.Tip)
+
+	//更短但更重的分支
+	c1 := newTestBlock(t, &genesis, wallet, "c1")
+	engine.weights[string(c1.Hash)] = 5
+	assert.NoError(t, connectTestBlocks(c1))
+	assert.Equal(t, c1.Hash, bc.Tip, "shorter branch is heavier")
+	assert.Equal(t, 1, bc.GetBestHeight())
+}
+
+func TestReorganizeEvictsSpendersOfDisconnectedCoinbase(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	a1 := newTestBlock(t, &genesis, wallet, "a1")
+	assert.NoError(t, connectTestBlocks(a1))
+
+	//交易池中的tx花费a1的coinbase输出，child花费tx的输出，other花费创始区块的输出
+	cb := a1.Transactions[len(a1.Transactions)-1]
+	tx := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 9)
+	assert.NoError(t, mempool.Add(tx))
+	pending := map[string]*Transaction{hex.EncodeToString(tx.ID): tx}
+	child := newTestTx(bc, wallet, pending, []TxInput{{tx.ID, 0, nil, maxReplaceableSequence}}, 8)
+	assert.NoError(t, mempool.Add(child))
+	other := newTestTx(bc, wallet, nil, []TxInput{{genesis.Transactions[0].ID, 0, nil, maxReplaceableSequence}}, 9)
+	assert.NoError(t, mempool.Add(other))
+
+	//切换到更长的分支后a1的coinbase输出不存在了
+	b1 := newTestBlock(t, &genesis, wallet, "b1")
+	b2 := newTestBlock(t, b1, wallet, "b2")
+	assert.NoError(t, connectTestBlocks(b1, b2))
+	assert.Equal(t, b2.Hash, bc.Tip)
+	assert.False(t, mempool.Has(tx.ID))
+	assert.False(t, mempool.Has(child.ID), "descendants are evicted too")
+	assert.True(t, mempool.Has(other.ID))
+}
@@ -3,14 +3,11 @@ package blockchain7
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"log"
-	"math/big"
 	"strings"
 	"time"
 )
@@ -23,6 +20,7 @@ type Transaction struct {
 	Vin       []TxInput  //交易输入，由上次交易输入（可能多个）
 	Vout      []TxOutput //交易输出，由本次交易产生（可能多个）
 	Timestamp int64      //时间戳，确保每一笔交易的ID完全不同
+	LockTime  int64      //锁定时间：小于lockTimeThreshold时为区块高度，否则为Unix时间戳，供OP_CHECKLOCKTIMEVERIFY比较
 }
 
 //IsCoinbase 检查交易是否是创始区块交易
@@ -61,10 +59,14 @@ func DeserializeTransaction(data []byte) Transaction {
 }
 
 // Hash 返回交易的哈希，用作交易的ID
+//普通交易的ID在签名之前就已确定，解锁脚本不参与计算，签名后仍可用Hash检查ID；coinbase交易的输入数据参与计算
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
 	txCopy := *tx
+	if !tx.IsCoinbase() {
+		txCopy = tx.TrimmedCopy()
+	}
 	txCopy.ID = []byte{}
 
 	hash = sha256.Sum256(txCopy.Serialize())
@@ -84,37 +86,15 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 		}
 	}
 
-	//将会被签署的是修剪后的当前交易的交易副本，而不是一个完整交易
-	//txCopy拥有当前交易的全部输出数据和部分输入数据
-	txCopy := tx.TrimmedCopy()
-
-	//迭代副本中的每一个输入，分别进行签名
-	for inID, vin := range txCopy.Vin {
+	//每一个输入分开签名，签名的是修剪后的交易副本的哈希，副本中该输入的解锁脚本替换为所引用输出的锁定脚本，详见signatureHash
+	//比特币允许交易包含引用了不同地址的输入，所以每个输入的签名数据各不相同
+	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		//在每个输入中，`Signature`被设置为`nil`(Signature仅仅是一个双重检验，所以没有必要放进来)
-		//实际上，在构建交易时候，计算交易ID时，Signature也是nil（设置交易ID是在对交易进行签名前完成）
-		//这里也是为计算交易副本的ID，所以Signature也设置为nil
-		txCopy.Vin[inID].Signature = nil
-
-		//输入中的`pubKey`被设置为所引用输出的`PubKeyHash`（注意，不是原生态公钥）
-		//虽然在我们的例子中，每一个输入的prevTx.Vout[vin.Vout].PubKeyHash都会相同(自己挖矿，包含的交易都是自己发起的）
-		//，但是比特币允许交易包含引用了不同地址的输入（即来自不同地址发起的交易），所以这里仍然这么做（每一个输入分开签名）
-		//实际上，是将输入的PubKey从自己钱包的PubKey替换为该输入引用输出索引对应的交易的PubKeyHash
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-
-		///签名的是交易副本数据
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign)) //对副本进行签名
-		if err != nil {
-			log.Panic(err)
-		}
-		//一个 ECDSA 签名就是一对数字。连接切片，构建签名
-		signature := append(r.Bytes(), s.Bytes()...)
+		hash := tx.signatureHash(inID, prevTx.Vout[vin.Vout].ScriptPubKey)
 
-		//**副本中每一个输入是被分开签名的**
-		//尽管这对于我们的应用并不十分紧要，但是比特币允许交易包含引用了不同地址的输入
-		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil //重置pubkey为nil
+		//一个 ECDSA 签名就是一对数字，解锁脚本为<签名> <原生态公钥>
+		signature := signHash(privKey, hash)
+		tx.Vin[inID].ScriptSig = NewP2PKHScriptSig(signature, publicKey(privKey))
 	}
 }
 
@@ -129,36 +109,39 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		lines = append(lines, fmt.Sprintf("       ScriptSig: %s", DisasmScript(input.ScriptSig)))
+		lines = append(lines, fmt.Sprintf("       Sequence:  %d", input.Sequence))
 	}
 
 	for i, output := range tx.Vout {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       PubKeyHash: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", DisasmScript(output.ScriptPubKey)))
+	}
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     LockTime: %d", tx.LockTime))
 	}
 
 	return strings.Join(lines, "\n")
 }
 
 // TrimmedCopy 创建一个修剪后的交易副本（深度拷贝的副本），用于签名用
-//由于TrimmedCopy是在tx签名前执行，实际上修剪只是在tx基础上，将输入Vin中的每一个vin的PubKey置为nil
+//修剪是将输入Vin中的每一个vin的解锁脚本置为nil，签名和验证时得到相同的副本
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
 	for _, vin := range tx.Vin {
-		//包含了所有的输入和输出，但是`TXInput.Signature`和`TXIput.PubKey`被设置为`nil`
-		//在调用这个方法后，会用引用前一个交易的输出的PubKeyHash，取代这里的PubKey
-		inputs = append(inputs, TxInput{vin.Txid, vin.Vout, nil, nil})
+		//包含了所有的输入和输出，但是`TXInput.ScriptSig`被设置为`nil`
+		//在调用这个方法后，会用引用前一个交易的输出的锁定脚本，取代这里的解锁脚本
+		inputs = append(inputs, TxInput{vin.Txid, vin.Vout, nil, vin.Sequence})
 	}
 
 	for _, vout := range tx.Vout {
-		outputs = append(outputs, TxOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TxOutput{vout.Value, vout.ScriptPubKey})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs, time.Now().Unix()}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.Timestamp, tx.LockTime}
 
 	return txCopy
 }
@@ -176,80 +159,95 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 		}
 	}
 
-	txCopy := tx.TrimmedCopy() //同一笔交易的副本
-	curve := elliptic.P256()   //生成密钥对的椭圆曲线
-
-	//迭代每个输入
+	//迭代每个输入，执行解锁脚本和所引用输出的锁定脚本
 	for inID, vin := range tx.Vin {
-		//以下代码跟签名一样，因为在验证阶段，我们需要的是与签名相同的数据
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-
-		//解包存储在`TXInput.Signature`和`TXInput.PubKey`中的值
-
-		//一个签名就是一对长度相同的数字。
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		//从输入中直接取出公钥数组，解析为一对长度相同的坐标
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey) //vin.PubKey为原生态公钥数组
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
-
-		//从解析的坐标创建一个rawPubKey（原生态公钥）
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-
-		//使用公钥验证副本的签名，是否私钥签名档结果一致（&r和&s是私钥签名txCopy.ID的结果）
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
+		if verifyScript(vin.ScriptSig, prevTx.Vout[vin.Vout].ScriptPubKey, tx, inID) != nil {
 			return false
 		}
-		txCopy.Vin[inID].PubKey = nil
 	}
 
 	return true
 }
 
+// SignalsReplacement 交易是否选择接受手续费替换：有任一输入的序号不大于maxReplaceableSequence
+//交易池中接受替换的交易可以被花费相同输入、手续费更高的交易替换
+func (tx Transaction) SignalsReplacement() bool {
+	for _, vin := range tx.Vin {
+		if vin.Sequence <= maxReplaceableSequence {
+			return true
+		}
+	}
+
+	return false
+}
+
 //NewCoinbaseTX 创建一个区块链创始交易，不需要签名
-func NewCoinbaseTX(to, data string) *Transaction {
+//fees为区块中其他交易的手续费总额，与挖矿奖励一起发给矿工
+func NewCoinbaseTX(to, data string, fees int) *Transaction {
 	if data == "" {
 		data = fmt.Sprintf("奖励给%s", to) //fmt.Sprintf将数据格式化后赋值给变量data
 	}
 
 	//初始交易输入结构：引用输出的交易为空:引用交易的ID为空，交易引用的输出值为设为-1
-	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTxOutput(subsidy, to)                                              //本次交易的输出结构：奖励值为subsidy，奖励给地址to（当然也只有地址to可以解锁使用这笔钱）
-	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}, time.Now().Unix()} //交易ID设为nil
+	txin := TxInput{[]byte{}, -1, []byte(data), sequenceFinal}
+	txout := NewTxOutput(subsidy+fees, to)                                            //本次交易的输出结构：奖励值为subsidy，奖励给地址to（当然也只有地址to可以解锁使用这笔钱）
+	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}, time.Now().Unix(), 0} //交易ID设为nil
 	tx.ID = tx.Hash()
 
 	return &tx
 }
 
 //NewUTXOTransaction 创建一个资金转移交易并签名（对输入签名）
-//from、to均为Base58的地址字符串,UTXOSet为从数据库读取的未花费输出
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
+//from、to均为Base58的地址字符串,view为未花费输出的视图，叠加了交易池时可以花费尚未上链的输出（如上一笔转账的找零）
+//fee为支付给矿工的手续费，输入总额减去转账金额和手续费后的部分找零给sender
+func NewUTXOTransaction(wallet *Wallet, to string, amount, fee int, view *UTXOView) *Transaction {
+	return NewLockedUTXOTransaction(wallet, to, amount, fee, 0, 0, view)
+}
+
+//NewLockedUTXOTransaction 创建一个带锁定时间的资金转移交易并签名
+//lockTime为交易的LockTime（区块高度或Unix时间戳），到达之前交易不能上链；
+//relativeLock大于0时每个输入都相对锁定relativeLock个区块，引用的输出上链之后要再经过这么多个区块交易才能上链
+func NewLockedUTXOTransaction(wallet *Wallet, to string, amount, fee int, lockTime int64, relativeLock int, view *UTXOView) *Transaction {
+	//注意，to地址要反编码成实际地址
+	payments := []TxOutput{*NewTxOutput(amount, to)}
+
+	return NewPaymentTransaction(wallet, payments, fee, lockTime, relativeLock, view)
+}
+
+//NewPaymentTransaction 创建一个支付到payments中各个输出的交易并签名，输出可以使用任意锁定脚本
+//输入总额减去payments总额和手续费后的部分找零给sender，lockTime和relativeLock见NewLockedUTXOTransaction
+func NewPaymentTransaction(wallet *Wallet, payments []TxOutput, fee int, lockTime int64, relativeLock int, view *UTXOView) *Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
+	amount := 0
+	for _, out := range payments {
+		amount += out.Value
+	}
+
 	//计算出发送者公钥的哈希
 	//一般除了签名和校验签名的情形下要用到私钥，在其他情形下，都只会用到公钥或公钥的哈希
 	pubKeyHash := HashPubKey(wallet.PublicKey)
 
 	//validOutputs为sender为此交易提供的输出，不一定是sender的全部输出
 	//acc为sender发出的全部币数，不一定是sender的全部可用币
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	//交易至少要有一个输入：只有数据输出并且不付手续费时，也花费一个输出全部找零
+	needed := amount + fee
+	if needed == 0 {
+		needed = 1
+	}
+	acc, validOutputs := view.FindSpendableOutputs(pubKeyHash, needed)
 
-	if acc < amount {
+	if acc < needed {
 		log.Panic("ERROR:没有足够的钱。")
 	}
 
+	sequence := uint32(maxReplaceableSequence)
+	if relativeLock > 0 {
+		sequence = uint32(relativeLock) //不大于sequenceLockTimeMask，同时也表示接受手续费替换
+	}
+
 	//构建输入参数（列表）
 	for txid, outs := range validOutputs {
 		txID, err := hex.DecodeString(txid) //字符串反编码为二进制数组
@@ -258,22 +256,22 @@ func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet)
 		}
 
 		for _, out := range outs {
-			input := TxInput{txID, out, nil, wallet.PublicKey} //输入暂时还没有签名
+			input := TxInput{txID, out, nil, sequence} //输入暂时还没有签名，钱包创建的交易都接受手续费替换
 			inputs = append(inputs, input)
 		}
 
 	}
 
-	//构建输出参数（列表），注意，to地址要反编码成实际地址
+	//构建输出参数（列表）
 	from := fmt.Sprintf("%s", wallet.GetAddress())
-	outputs = append(outputs, *NewTxOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTxOutput(acc-amount, from)) //找零，退给sender
+	outputs = append(outputs, payments...)
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTxOutput(acc-amount-fee, from)) //找零，退给sender
 	}
 
-	tx := Transaction{nil, inputs, outputs, time.Now().Unix()} //初始交易ID设为nil
-	tx.ID = tx.Hash()                                          //紧接着设置交易的ID，计算交易ID时候，还没对交易进行签名（即签名字段Signature=nil)
-	UTXOSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey) //利用私钥对交易进行签名，实际上是对交易中的每一个输入进行签名
+	tx := Transaction{nil, inputs, outputs, time.Now().Unix(), lockTime} //初始交易ID设为nil
+	tx.ID = tx.Hash()                                                    //紧接着设置交易的ID，计算交易ID时候，还没对交易进行签名（即解锁脚本ScriptSig=nil)
+	view.SignTransaction(&tx, wallet.PrivateKey)                         //利用私钥对交易进行签名，实际上是对交易中的每一个输入进行签名
 
 	return &tx
 }
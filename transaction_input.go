@@ -2,26 +2,52 @@ package blockchain7
 
 import "bytes"
 
+const sequenceFinal = 0xffffffff          //输入的默认序号
+const maxReplaceableSequence = 0xfffffffd //交易中有输入的序号不大于该值时，表示该交易接受手续费替换（RBF）
+
+const sequenceLockTimeDisableFlag = 1 << 31 //序号的最高位为1时不启用相对锁定
+const sequenceLockTimeMask = 0x0000ffff     //启用相对锁定时，序号的低16位为相对锁定的区块数
+
 //TxInput 交易的输入
 //包含的是前一笔交易的一个输出
 type TxInput struct {
 	Txid []byte //前一笔交易的ID
 	Vout int    //前一笔交易在该笔交易所有输出中的索引（一笔交易可能有多个输出，需要有信息指明具体是哪一个）
 
-	Signature []byte //输入数据签名
-
-	//PubKey公钥，是发送者的钱包的公钥，用于解锁输出
-	//如果PubKey与所引用的锁定输出的PubKey相同，那么引用的输出就会被解锁，然后被解锁的值就可以被用于产生新的输出
+	//ScriptSig解锁脚本，只能压入数据，如P2PKH输出的解锁脚本为<签名> <发送者的原生态公钥>
+	//验证时先执行解锁脚本，再执行所引用输出的锁定脚本，执行成功时引用的输出被解锁，然后被解锁的值就可以被用于产生新的输出
 	//如果不正确，前一笔交易的输出就无法被引用在输入中，或者说，也就无法使用这个输出
 	//这种机制，保证了用户无法花费其他人的币
-	PubKey []byte
+	//coinbase交易的输入不引用输出，ScriptSig可以存放任意数据
+	ScriptSig []byte
+
+	Sequence uint32 //输入的序号，用于选择接受手续费替换和相对锁定，包含在签名的数据中
+}
+
+//RelativeLock 返回输入的相对锁定：引用的输出上链之后需要经过的区块数，没有启用相对锁定时返回0
+func (in *TxInput) RelativeLock() int {
+	if in.Sequence&sequenceLockTimeDisableFlag != 0 {
+		return 0
+	}
+
+	return int(in.Sequence & sequenceLockTimeMask)
+}
+
+//SignerPubKey 返回解锁脚本最后压入的数据，对P2PKH输出的解锁脚本来说是发送者的原生态公钥
+func (in *TxInput) SignerPubKey() []byte {
+	ops, err := parseScript(in.ScriptSig)
+	if err != nil || len(ops) == 0 {
+		return nil
+	}
+
+	return ops[len(ops)-1].Data
 }
 
 //UsesKey 检查是否可以解锁引用的输出
 func (in *TxInput) UsesKey(pubKeyHash []byte) bool {
 	//注意输入中的公钥是来自于钱包中的公钥，是原生的公钥
 	//而引用的输出中的公钥是哈希后的公钥
-	lockingHash := HashPubKey(in.PubKey)
+	lockingHash := HashPubKey(in.SignerPubKey())
 
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
@@ -10,27 +10,46 @@ import (
 type TxOutput struct {
 	Value int //输出里面存储的“币”
 
-	//锁定输出的公钥（比特币里面是一个脚本，这里是公钥）
-	PubKeyHash []byte
+	//锁定脚本，花费输出的输入的解锁脚本必须能让它执行成功，默认为P2PKH脚本
+	ScriptPubKey []byte
 }
 
-// Lock 对输出锁定，即反编码address后，获得实际的公钥哈希
+// Lock 对输出锁定，即反编码address后，按地址的版本生成锁定脚本
+//普通地址包含的是公钥哈希，生成P2PKH锁定脚本；P2SH地址包含的是赎回脚本的哈希，生成P2SH锁定脚本；
+//多重签名地址包含的就是锁定脚本
 func (out *TxOutput) Lock(address []byte) {
-	expubKeyHash := Base58Decode(address)
-	pubKeyHash := expubKeyHash[1 : len(expubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+	payload := decodeAddress(string(address))
+	data := payload[1 : len(payload)-addressChecksumLen]
+	switch payload[0] {
+	case scriptHashVersion:
+		out.ScriptPubKey = NewP2SHScript(data)
+	case multisigVersion:
+		out.ScriptPubKey = data
+	default:
+		out.ScriptPubKey = NewP2PKHScript(data)
+	}
+}
+
+// PubKeyHash 返回P2PKH锁定脚本中的公钥哈希，其他脚本返回nil
+func (out *TxOutput) PubKeyHash() []byte {
+	return extractP2PKH(out.ScriptPubKey)
+}
+
+// IsUnspendable 输出是否无法花费：锁定脚本以OP_RETURN开始，如数据输出，这样的输出不会加入UTXO集
+func (out *TxOutput) IsUnspendable() bool {
+	return len(out.ScriptPubKey) > 0 && out.ScriptPubKey[0] == opReturn
 }
 
 // IsLockedWithKey 检查输出是否能够被公钥pubKeyHash拥有者使用
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	return bytes.Compare(out.PubKeyHash(), pubKeyHash) == 0
 }
 
 // NewTxOutput 创建一个新的 TXOutput
 //注意，这里需要将address进行反编码成实际的地址
 func NewTxOutput(value int, address string) *TxOutput {
-	txo := &TxOutput{value, nil} //构建TxOutput，PubKeyHash暂设为nil
-	txo.Lock([]byte(address))    //接着设定TxOutput的PubKeyHash值进行锁定
+	txo := &TxOutput{value, nil} //构建TxOutput，锁定脚本暂设为nil
+	txo.Lock([]byte(address))    //接着设定TxOutput的锁定脚本进行锁定
 
 	return txo
 }
@@ -38,6 +57,51 @@ func NewTxOutput(value int, address string) *TxOutput {
 // TxOutputs TxOutput集合
 type TxOutputs struct {
 	Outputs []TxOutput
+
+	//Indexes 每个输出在原交易所有输出中的索引
+	//已花费的输出会从集合中删除，而输入引用的是原交易中的索引，所以需要单独保存
+	Indexes []int
+}
+
+// Index 返回第i个输出在原交易中的索引，兼容没有保存索引的旧数据
+func (outs TxOutputs) Index(i int) int {
+	if len(outs.Indexes) == len(outs.Outputs) {
+		return outs.Indexes[i]
+	}
+
+	return i
+}
+
+// Find 根据原交易中的索引查找输出
+func (outs TxOutputs) Find(vout int) (TxOutput, bool) {
+	for i, out := range outs.Outputs {
+		if outs.Index(i) == vout {
+			return out, true
+		}
+	}
+
+	return TxOutput{}, false
+}
+
+// insert 按在原交易中的索引顺序加入一个输出，返回新的集合，撤销区块时恢复被花费的输出
+func (outs TxOutputs) insert(vout int, out TxOutput) TxOutputs {
+	var result TxOutputs
+	inserted := false
+	for i, o := range outs.Outputs {
+		if !inserted && vout < outs.Index(i) {
+			result.Outputs = append(result.Outputs, out)
+			result.Indexes = append(result.Indexes, vout)
+			inserted = true
+		}
+		result.Outputs = append(result.Outputs, o)
+		result.Indexes = append(result.Indexes, outs.Index(i))
+	}
+	if !inserted {
+		result.Outputs = append(result.Outputs, out)
+		result.Indexes = append(result.Indexes, vout)
+	}
+
+	return result
 }
 
 // Serialize 序列化TxOutputs
@@ -1,6 +1,7 @@
 package blockchain7
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"log"
@@ -36,10 +37,10 @@ func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[s
 			txID := hex.EncodeToString(k)
 			outs := DeserializeOutputs(v)
 
-			for outIdx, out := range outs.Outputs { //得到足够的未花费输出（不少于需要转账的金额）
+			for i, out := range outs.Outputs { //得到足够的未花费输出（不少于需要转账的金额）
 				if out.IsLockedWithKey(pubkeyHash) && accumulated < amount {
 					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+					unspentOutputs[txID] = append(unspentOutputs[txID], outs.Index(i))
 				}
 				if accumulated >= amount {
 					break Work //退出两个循环
@@ -69,7 +70,7 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
 			outs := DeserializeOutputs(v)
 
 			for _, out := range outs.Outputs {
-				strpubKeyHash := hex.EncodeToString(out.PubKeyHash)
+				strpubKeyHash := hex.EncodeToString(out.PubKeyHash())
 				fmt.Println(strpubKeyHash)
 				if out.IsLockedWithKey(pubKeyHash) {
 					UTXOs = append(UTXOs, out)
@@ -86,6 +87,131 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
 	return UTXOs
 }
 
+// UnspentOutput 一个未花费输出及其位置
+type UnspentOutput struct {
+	TxID   []byte
+	Vout   int //输出在原交易中的索引
+	Output TxOutput
+}
+
+// FindUnspentOutputs 从数据库的UTXO表中查找一个公钥哈希的全部未花费输出，包括输出的位置
+func (u UTXOSet) FindUnspentOutputs(pubKeyHash []byte) []UnspentOutput {
+	return u.findUnspent(func(out TxOutput) bool { return out.IsLockedWithKey(pubKeyHash) })
+}
+
+// FindScriptOutputs 从数据库的UTXO表中查找锁定脚本为script的全部未花费输出，如多重签名地址的输出
+func (u UTXOSet) FindScriptOutputs(script []byte) []UnspentOutput {
+	return u.findUnspent(func(out TxOutput) bool { return bytes.Equal(out.ScriptPubKey, script) })
+}
+
+// findUnspent 从数据库的UTXO表中查找满足match的全部未花费输出
+func (u UTXOSet) findUnspent(match func(out TxOutput) bool) []UnspentOutput {
+	var unspent []UnspentOutput
+	db := u.Blockchain.Db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs := DeserializeOutputs(v)
+
+			for i, out := range outs.Outputs {
+				if match(out) {
+					txID := make([]byte, len(k)) //k只在事务内有效，需要复制
+					copy(txID, k)
+					unspent = append(unspent, UnspentOutput{txID, outs.Index(i), out})
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return unspent
+}
+
+// StakeDistribution 统计高度为tipHeight的区块链上每个公钥哈希持有的成熟UTXO金额
+// 所在区块至少有maturity个确认的UTXO才算成熟，创始区块的UTXO始终成熟，这样链创建后即可出块
+func (u UTXOSet) StakeDistribution(tipHeight, maturity int) map[string]int {
+	stakes := make(map[string]int)
+	heights := make(map[string]int) //区块哈希->高度，同一区块的交易只需读取一次区块
+	db := u.Blockchain.Db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		blockb := tx.Bucket([]byte(utxoBlockBucket))
+		blocks := tx.Bucket([]byte(blocksBucket))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			blockHash := blockb.Get(k)
+			if blockHash == nil {
+				continue
+			}
+			height, ok := heights[string(blockHash)]
+			if !ok {
+				height = DeserializeBlock(blocks.Get(blockHash)).Height
+				heights[string(blockHash)] = height
+			}
+			if height > 0 && tipHeight-height+1 < maturity {
+				continue
+			}
+
+			for _, out := range DeserializeOutputs(v).Outputs {
+				if pubKeyHash := out.PubKeyHash(); pubKeyHash != nil { //只有P2PKH输出计入权益
+					stakes[string(pubKeyHash)] += out.Value
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return stakes
+}
+
+// FindOutputs 在UTXO集中查找一个交易的所有未花费输出，交易的输出全部花费或交易不存在时返回false
+func (u UTXOSet) FindOutputs(txID []byte) (TxOutputs, bool) {
+	var outs TxOutputs
+	found := false
+	db := u.Blockchain.Db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		outsBytes := b.Get(txID)
+		if outsBytes == nil {
+			return nil
+		}
+
+		outs = DeserializeOutputs(outsBytes)
+		found = len(outs.Outputs) > 0
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return outs, found
+}
+
+// FindOutput 在UTXO集中查找一个未花费输出，vout是输出在原交易中的索引
+func (u UTXOSet) FindOutput(txID []byte, vout int) (TxOutput, bool) {
+	outs, ok := u.FindOutputs(txID)
+	if !ok {
+		return TxOutput{}, false
+	}
+
+	return outs.Find(vout)
+}
+
 // CountTransactions 从数据库的UTXO表中查找一个UTXO集合中交易的数量
 func (u UTXOSet) CountTransactions() int {
 	db := u.Blockchain.Db
@@ -194,9 +320,10 @@ func (u UTXOSet) Update(block *Block) {
 					if outsBytes != nil {
 						outs := DeserializeOutputs(outsBytes)
 
-						for outIdx, out := range outs.Outputs {
-							if outIdx != vin.Vout { //如果UTXO中的输出不包含在当前交易中，保留到更新的UTXO集中
+						for i, out := range outs.Outputs {
+							if outs.Index(i) != vin.Vout { //如果UTXO中的输出不包含在当前交易中，保留到更新的UTXO集中
 								updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+								updatedOuts.Indexes = append(updatedOuts.Indexes, outs.Index(i))
 							}
 						}
 
@@ -216,10 +343,17 @@ func (u UTXOSet) Update(block *Block) {
 				}
 			}
 
-			//将新交易的输出加入到UTXO中
+			//将新交易的输出加入到UTXO中，无法花费的数据输出不加入
 			newOutputs := TxOutputs{}
-			for _, out := range tx.Vout {
+			for outIdx, out := range tx.Vout {
+				if out.IsUnspendable() {
+					continue
+				}
 				newOutputs.Outputs = append(newOutputs.Outputs, out)
+				newOutputs.Indexes = append(newOutputs.Indexes, outIdx)
+			}
+			if len(newOutputs.Outputs) == 0 {
+				continue
 			}
 
 			err := b.Put(tx.ID, newOutputs.Serialize())
@@ -240,3 +374,92 @@ func (u UTXOSet) Update(block *Block) {
 		log.Panic(err)
 	}
 }
+
+// Disconnect 撤销区块对UTXO集的修改，与Update相反，区块必须是区块链的Tip区块，调用者随后把tip设为父区块
+// 删除区块中交易的输出，并恢复被区块中的交易花费的输出，被花费的输出从它所在的交易中取得
+func (u UTXOSet) Disconnect(block *Block) {
+	db := u.Blockchain.Db
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		blockb, err := tx.CreateBucketIfNotExists([]byte(utxoBlockBucket))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		for i := len(block.Transactions) - 1; i >= 0; i-- { //后面的交易可能花费前面的交易的输出，倒序撤销
+			t := block.Transactions[i]
+			if err := b.Delete(t.ID); err != nil {
+				log.Panic(err)
+			}
+			if err := blockb.Delete(t.ID); err != nil {
+				log.Panic(err)
+			}
+			if t.IsCoinbase() {
+				continue
+			}
+
+			for _, vin := range t.Vin {
+				prevTx, prevBlockHash := findTransactionInTx(tx, block.PrevBlockHash, vin.Txid)
+				if prevTx == nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+					log.Panicf("找不到交易 %x 花费的输出 %s", t.ID, outpoint(vin.Txid, vin.Vout))
+				}
+
+				outs := TxOutputs{}
+				if outsBytes := b.Get(vin.Txid); outsBytes != nil {
+					outs = DeserializeOutputs(outsBytes)
+				}
+				outs = outs.insert(vin.Vout, prevTx.Vout[vin.Vout])
+				if err := b.Put(vin.Txid, outs.Serialize()); err != nil {
+					log.Panic(err)
+				}
+				if err := blockb.Put(vin.Txid, prevBlockHash); err != nil {
+					log.Panic(err)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// findTransactionInTx 在数据库事务中查找交易及其所在区块的哈希
+// 先查UTXOBlock表，查不到时（如重建UTXO集时交易的输出已经全部花费）从区块from开始沿区块链向前查找
+func findTransactionInTx(tx *bolt.Tx, from, txID []byte) (*Transaction, []byte) {
+	blocks := tx.Bucket([]byte(blocksBucket))
+
+	find := func(blockHash []byte) (*Transaction, *Block) {
+		blockData := blocks.Get(blockHash)
+		if blockData == nil {
+			return nil, nil
+		}
+		block := DeserializeBlock(blockData)
+		for _, t := range block.Transactions {
+			if bytes.Equal(t.ID, txID) {
+				return t, block
+			}
+		}
+		return nil, block
+	}
+
+	if blockHash := tx.Bucket([]byte(utxoBlockBucket)).Get(txID); blockHash != nil {
+		if t, block := find(blockHash); t != nil {
+			return t, block.Hash
+		}
+	}
+	for hash := from; len(hash) > 0; {
+		t, block := find(hash)
+		if t != nil {
+			return t, block.Hash
+		}
+		if block == nil {
+			break
+		}
+		hash = block.PrevBlockHash
+	}
+
+	return nil, nil
+}
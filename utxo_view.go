@@ -0,0 +1,168 @@
+package blockchain7
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"sort"
+)
+
+// UTXOView 叠加了交易池的UTXO视图
+// 交易池中的交易的输出视为可以花费（未确认），被交易池中的交易花费的输出视为已经花费，
+// 这样钱包可以花费尚未上链的找零，连续创建首尾相连的多个交易（交易链）
+type UTXOView struct {
+	UTXOSet UTXOSet
+	pending map[string]*Transaction //交易池中的交易
+	order   []string                //交易池中的交易ID，父交易在子交易之前
+	spent   map[string]bool         //被交易池中的交易花费的输出（outpoint）
+	prefer  map[string]bool         //优先使用的输出（outpoint）
+
+	selector CoinSelector    //选币策略
+	inputs   map[string]bool //手动指定的输出（outpoint），不为nil时只花费并且全部花费这些输出
+}
+
+// NewUTXOView 创建只包含已确认输出的UTXO视图
+func NewUTXOView(UTXOSet UTXOSet) *UTXOView {
+	return &UTXOView{UTXOSet, make(map[string]*Transaction), nil, make(map[string]bool), make(map[string]bool), firstSelector{}, nil}
+}
+
+// View 返回叠加了交易池当前内容的UTXO视图，视图是交易池的快照，之后交易池的变化不影响视图
+func (mp *Mempool) View() *UTXOView {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	view := NewUTXOView(UTXOSet{mp.bc})
+	for _, entry := range mp.sortedEntries() {
+		view.add(entry.tx)
+	}
+
+	return view
+}
+
+// add 将一个未确认交易加入视图：花费其输入引用的输出，并加入新的输出
+func (v *UTXOView) add(tx *Transaction) {
+	txID := hex.EncodeToString(tx.ID)
+	v.pending[txID] = tx
+	v.order = append(v.order, txID)
+	for _, vin := range tx.Vin {
+		v.spent[outpoint(vin.Txid, vin.Vout)] = true
+	}
+}
+
+// FindOutput 查找一个未花费输出，可以是已确认的输出，也可以是交易池中交易的输出
+func (v *UTXOView) FindOutput(txID []byte, vout int) (TxOutput, bool) {
+	if v.spent[outpoint(txID, vout)] {
+		return TxOutput{}, false
+	}
+
+	if tx, ok := v.pending[hex.EncodeToString(txID)]; ok {
+		if vout < 0 || vout >= len(tx.Vout) || tx.Vout[vout].IsUnspendable() {
+			return TxOutput{}, false
+		}
+		return tx.Vout[vout], true
+	}
+
+	return v.UTXOSet.FindOutput(txID, vout)
+}
+
+// Prefer 优先使用某个输出，例如替换交易时必须花费原交易的输入
+func (v *UTXOView) Prefer(txID []byte, vout int) {
+	v.prefer[outpoint(txID, vout)] = true
+}
+
+// SetCoinSelector 设置FindSpendableOutputs使用的选币策略
+func (v *UTXOView) SetCoinSelector(selector CoinSelector) {
+	v.selector = selector
+}
+
+// UseInput 手动指定花费的输出：指定了输出之后，FindSpendableOutputs只花费并且全部花费指定的输出
+func (v *UTXOView) UseInput(txID []byte, vout int) {
+	if v.inputs == nil {
+		v.inputs = make(map[string]bool)
+	}
+	v.inputs[outpoint(txID, vout)] = true
+}
+
+// FindUnspentOutputs 查找一个公钥哈希的全部未花费输出：先是优先使用的输出，然后是其余已确认的输出，最后是交易池中交易的输出
+func (v *UTXOView) FindUnspentOutputs(pubKeyHash []byte) []UnspentOutput {
+	match := func(out TxOutput) bool { return out.IsLockedWithKey(pubKeyHash) }
+
+	return v.findUnspent(v.UTXOSet.FindUnspentOutputs(pubKeyHash), match)
+}
+
+// FindScriptOutputs 查找锁定脚本为script的全部未花费输出，顺序与FindUnspentOutputs相同
+func (v *UTXOView) FindScriptOutputs(script []byte) []UnspentOutput {
+	match := func(out TxOutput) bool { return bytes.Equal(out.ScriptPubKey, script) }
+
+	return v.findUnspent(v.UTXOSet.FindScriptOutputs(script), match)
+}
+
+// findUnspent 从已确认的输出confirmed中去掉被交易池花费的输出，加上交易池中满足match的输出，优先使用的输出排在前面
+func (v *UTXOView) findUnspent(confirmed []UnspentOutput, match func(out TxOutput) bool) []UnspentOutput {
+	var unspent []UnspentOutput
+
+	for _, u := range confirmed {
+		if !v.spent[outpoint(u.TxID, u.Vout)] {
+			unspent = append(unspent, u)
+		}
+	}
+
+	for _, txid := range v.order {
+		tx := v.pending[txid]
+		for i, out := range tx.Vout {
+			if match(out) && !v.spent[outpoint(tx.ID, i)] {
+				unspent = append(unspent, UnspentOutput{tx.ID, i, out})
+			}
+		}
+	}
+
+	sort.SliceStable(unspent, func(i, j int) bool {
+		return v.prefer[outpoint(unspent[i].TxID, unspent[i].Vout)] && !v.prefer[outpoint(unspent[j].TxID, unspent[j].Vout)]
+	})
+
+	return unspent
+}
+
+// FindSpendableOutputs 取出未花费输出，直至取出输出的币总数大于或等于amount为止
+// 手动指定了输出时取出全部指定的输出；否则先取优先使用的输出，不够时再由选币策略从其余输出中选择
+func (v *UTXOView) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+	var selected, candidates []UnspentOutput
+	accumulated := 0
+
+	for _, u := range v.FindUnspentOutputs(pubKeyHash) {
+		op := outpoint(u.TxID, u.Vout)
+		switch {
+		case v.inputs != nil:
+			if v.inputs[op] {
+				selected = append(selected, u)
+				accumulated += u.Output.Value
+			}
+		case v.prefer[op]:
+			if accumulated < amount {
+				selected = append(selected, u)
+				accumulated += u.Output.Value
+			}
+		default:
+			candidates = append(candidates, u)
+		}
+	}
+	if v.inputs == nil && accumulated < amount {
+		for _, u := range v.selector.Select(candidates, amount-accumulated) {
+			selected = append(selected, u)
+			accumulated += u.Output.Value
+		}
+	}
+
+	unspentOutputs := make(map[string][]int)
+	for _, u := range selected {
+		txID := hex.EncodeToString(u.TxID)
+		unspentOutputs[txID] = append(unspentOutputs[txID], u.Vout)
+	}
+
+	return accumulated, unspentOutputs
+}
+
+// SignTransaction 对交易签名，输入可以引用交易池中的交易
+func (v *UTXOView) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+	v.UTXOSet.Blockchain.signTransaction(tx, privKey, v.pending)
+}
@@ -0,0 +1,75 @@
+package blockchain7
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUTXOViewOverlaysMempool(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 6, 3)
+	assert.NoError(t, mempool.Add(a))
+
+	//创始区块的输出被交易池中的A花费，A的输出可以花费
+	view := mempool.View()
+	assert.Equal(t, []UnspentOutput{{a.ID, 0, a.Vout[0]}, {a.ID, 1, a.Vout[1]}}, view.FindUnspentOutputs(pubKeyHash))
+	out, ok := view.FindOutput(a.ID, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 3, out.Value)
+
+	//视图是快照，之后进入交易池的交易不影响它
+	b := newTestTx(bc, wallet, map[string]*Transaction{hex.EncodeToString(a.ID): a}, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 6)
+	assert.NoError(t, mempool.Add(b))
+	assert.Len(t, view.FindUnspentOutputs(pubKeyHash), 2)
+	assert.Len(t, mempool.View().FindUnspentOutputs(pubKeyHash), 2, "b spends a:0 and creates b:0")
+}
+
+func TestWalletBuildsTransactionChain(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	genesis := bc.GetLastBlock()
+	other := string(NewWallet().GetAddress())
+
+	//每一笔转账都花费上一笔的找零，不必等待确认
+	var chain []*Transaction
+	for i := 0; i < 3; i++ {
+		tx := NewUTXOTransaction(wallet, other, 2, 1, mempool.View())
+		assert.NoError(t, mempool.Add(tx), "payment %d", i)
+		chain = append(chain, tx)
+	}
+	for i := 1; i < len(chain); i++ {
+		assert.Equal(t, chain[i-1].ID, chain[i].Vin[0].Txid, "payment %d spends the change of the previous one", i)
+	}
+	assert.Len(t, UTXOSet{bc}.FindUnspentOutputs(HashPubKey(wallet.PublicKey)), 1, "nothing is confirmed yet")
+
+	//出块时父交易排在子交易之前，区块中的交易都有效
+	txs := mempool.Select(maxBlockSize)
+	assert.Equal(t, chain, txs)
+	b1 := newTestBlock(t, &genesis, wallet, "b1", txs...)
+	assert.NoError(t, connectTestBlocks(b1))
+	assert.Equal(t, b1.Hash, bc.Tip)
+	assert.Equal(t, 0, mempool.Count())
+}
+
+func TestMempoolSelectOrdersParentsFirst(t *testing.T) {
+	bc, wallet := newTestChain(t)
+	cb := bc.GetLastBlock().Transactions[0]
+
+	//父交易A不付手续费，子交易B的费率最高，B仍然排在A之后
+	a := newTestTx(bc, wallet, nil, []TxInput{{cb.ID, 0, nil, maxReplaceableSequence}}, 5, 5)
+	b := newTestTx(bc, wallet, map[string]*Transaction{hex.EncodeToString(a.ID): a}, []TxInput{{a.ID, 0, nil, maxReplaceableSequence}}, 1)
+	mp := NewMempool(bc, defaultMaxMempoolSize, 0, defaultMempoolExpiry)
+	assert.NoError(t, mp.Add(a))
+	assert.NoError(t, mp.Add(b))
+
+	assert.Equal(t, []*Transaction{a, b}, mp.Select(maxBlockSize))
+	assert.Empty(t, mp.Select(txSize(b)), "child is not selected without its parent")
+
+	block := &Block{Timestamp: time.Now().Unix(), Transactions: append(mp.Select(maxBlockSize), NewCoinbaseTX(string(wallet.GetAddress()), "", 4)), Height: 1}
+	assert.NoError(t, validateBlockTransactions(bc, block))
+}
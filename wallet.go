@@ -11,9 +11,12 @@ import (
 	"golang.org/x/crypto/ripemd160"
 )
 
-const version = byte(0x00) //钱包版本，一个字节
+const version = byte(0x00)           //钱包版本，一个字节
+const scriptHashVersion = byte(0x05) //P2SH地址的版本，地址中是赎回脚本的哈希
 
 const addressChecksumLen = 4
+const pubKeyLen = 64                           //原生态公钥的字节数：坐标X和Y各32字节
+const addressLen = 1 + 20 + addressChecksumLen //地址解码后的字节数：版本、20字节的哈希和校验码
 
 //Wallet 钱包保存公钥和私钥对
 type Wallet struct {
@@ -31,8 +34,11 @@ func NewWallet() *Wallet {
 
 // GetAddress 返回钱包地址（可为人识别的地址）
 func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+	return addressFromPubKeyHash(HashPubKey(w.PublicKey))
+}
 
+//addressFromPubKeyHash 根据公钥哈希生成地址
+func addressFromPubKeyHash(pubKeyHash []byte) []byte {
 	versionedPayload := append([]byte{version}, pubKeyHash...)
 	checksum := checksum(versionedPayload)
 
@@ -42,6 +48,38 @@ func (w Wallet) GetAddress() []byte {
 	return address
 }
 
+// ScriptHashAddress 根据赎回脚本生成P2SH地址，付款人不需要知道赎回脚本的内容
+func ScriptHashAddress(redeemScript []byte) []byte {
+	versionedPayload := append([]byte{scriptHashVersion}, HashPubKey(redeemScript)...)
+	fullPayload := append(versionedPayload, checksum(versionedPayload)...)
+
+	return Base58Encode(fullPayload)
+}
+
+//decodeAddress 解码地址，返回版本、哈希和校验码
+//旧版本的Base58Encode无论有几个前导的0字节都只编码一个'1'，哈希以0字节开头的地址（约1/256）的旧写法会少解码出0字节，
+//这里在前面补足，这样旧写法的地址仍然有效，与新写法表示同一个地址，见canonicalAddress
+func decodeAddress(address string) []byte {
+	payload := Base58Decode([]byte(address))
+	if len(payload) < addressLen {
+		payload = append(make([]byte, addressLen-len(payload)), payload...)
+	}
+
+	return payload
+}
+
+//canonicalAddress 地址的标准写法，旧写法的地址转换为新写法，钱包文件中的地址都使用标准写法
+func canonicalAddress(address string) string {
+	return string(Base58Encode(decodeAddress(address)))
+}
+
+//pubKeyHashFromAddress 从地址中取出公钥哈希，地址须先经过ValidateAddress检查
+func pubKeyHashFromAddress(address string) []byte {
+	pubKeyHash := decodeAddress(address)
+
+	return pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+}
+
 // HashPubKey 对公钥进行哈希
 func HashPubKey(pubKey []byte) []byte {
 	publicSHA256 := sha256.Sum256(pubKey)
@@ -57,14 +95,29 @@ func HashPubKey(pubKey []byte) []byte {
 }
 
 // ValidateAddress 检查地址是否合法
+//普通地址和P2SH地址包含20字节的哈希，多重签名地址包含多重签名锁定脚本
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
-	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
+	payload := decodeAddress(address)
+	if len(payload) <= 1+addressChecksumLen {
+		return false
+	}
+	actualChecksum := payload[len(payload)-addressChecksumLen:]
+	addressVersion := payload[0]
+	data := payload[1 : len(payload)-addressChecksumLen]
+	targetChecksum := checksum(append([]byte{addressVersion}, data...))
+	if bytes.Compare(actualChecksum, targetChecksum) != 0 {
+		return false
+	}
 
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+	switch addressVersion {
+	case version, scriptHashVersion:
+		return len(data) == 20
+	case multisigVersion:
+		m, _ := extractMultiSig(data)
+		return m > 0
+	default:
+		return false
+	}
 }
 
 // Checksum 根据公钥生成校验码
@@ -85,7 +138,17 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 
 	//从私钥生成一个公钥
 	//在基于椭圆曲线的算法中，公钥是曲线上的点，因此，公钥是 X，Y 坐标的组合
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	pubKey := publicKey(*private)
 
 	return *private, pubKey
 }
+
+// publicKey 从私钥得到原生态公钥：坐标X和Y各补足32字节后拼接
+//坐标以0字节开头时Bytes()会省略前导的0字节，不补足的话验证签名时无法正确拆分出X和Y
+func publicKey(privKey ecdsa.PrivateKey) []byte {
+	pubKey := make([]byte, pubKeyLen)
+	privKey.PublicKey.X.FillBytes(pubKey[:pubKeyLen/2])
+	privKey.PublicKey.Y.FillBytes(pubKey[pubKeyLen/2:])
+
+	return pubKey
+}
@@ -0,0 +1,114 @@
+package blockchain7
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// addressWithVersion 用任意版本和数据生成地址
+func addressWithVersion(addressVersion byte, data []byte) string {
+	payload := append([]byte{addressVersion}, data...)
+	return string(Base58Encode(append(payload, checksum(payload)...)))
+}
+
+func TestValidateAddress(t *testing.T) {
+	hash := bytes.Repeat([]byte{0x42}, 20)
+	address := string(addressFromPubKeyHash(hash))
+	tampered := []byte(address)
+	if tampered[5] == 'x' {
+		tampered[5] = 'y'
+	} else {
+		tampered[5] = 'x'
+	}
+
+	cases := []struct {
+		name    string
+		address string
+		ok      bool
+	}{
+		{"p2pkh", address, true},
+		{"p2sh", string(ScriptHashAddress([]byte{op1})), true},
+		{"multisig", string(MultiSigAddress((&ScriptBuilder{}).AddInt(1).AddData(hash).AddInt(1).AddOp(opCheckMultiSig).Script())), true},
+		{"legacy encoding", string(addressFromPubKeyHash(append([]byte{0}, hash[1:]...)))[1:], true},
+		{"bad checksum", string(tampered), false},
+		{"unknown version", addressWithVersion(0x01, hash), false},
+		{"short hash", addressWithVersion(version, hash[:19]), false},
+		{"long hash", addressWithVersion(version, append(hash, 0x42)), false},
+		{"empty", "", false},
+		{"invalid character", "0" + address[1:], false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.ok, ValidateAddress(c.address))
+		})
+	}
+}
+
+// 旧版本的Base58Encode无论有几个前导的0字节都只编码一个'1'，公钥哈希以0字节开头的地址有两种写法，必须表示同一个地址
+func TestLegacyAddress(t *testing.T) {
+	cases := []struct {
+		name string
+		hash []byte
+	}{
+		{"no leading zero", bytes.Repeat([]byte{0x42}, 20)},
+		{"one leading zero", append([]byte{0}, bytes.Repeat([]byte{0x42}, 19)...)},
+		{"two leading zeros", append([]byte{0, 0}, bytes.Repeat([]byte{0x42}, 18)...)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			address := string(addressFromPubKeyHash(c.hash))
+			leadingOnes := len(address) - len(strings.TrimLeft(address, "1"))
+			assert.Equal(t, 1+len(c.hash)-len(bytes.TrimLeft(c.hash, "\x00")), leadingOnes)
+
+			legacy := "1" + strings.TrimLeft(address, "1") //旧写法只有一个'1'
+			for _, a := range []string{address, legacy} {
+				assert.True(t, ValidateAddress(a), a)
+				assert.Equal(t, c.hash, pubKeyHashFromAddress(a), a)
+				assert.Equal(t, address, canonicalAddress(a), a)
+				assert.Equal(t, NewP2PKHScript(c.hash), NewTxOutput(1, a).ScriptPubKey, a)
+			}
+		})
+	}
+}
+
+// shortCoordinateKey 生成坐标X或Y以0字节开头的私钥（约1/128的私钥），这样的坐标直接调用Bytes()只有31个字节
+func shortCoordinateKey(t *testing.T) ecdsa.PrivateKey {
+	for {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.X.BitLen() <= 248 || key.Y.BitLen() <= 248 {
+			return *key
+		}
+	}
+}
+
+func TestPublicKeyShortCoordinate(t *testing.T) {
+	privKey := shortCoordinateKey(t)
+	wallet := &Wallet{privKey, publicKey(privKey)}
+	assert.Len(t, wallet.PublicKey, pubKeyLen)
+	assert.Equal(t, privKey.X.Bytes(), bytes.TrimLeft(wallet.PublicKey[:32], "\x00"))
+	assert.Equal(t, privKey.Y.Bytes(), bytes.TrimLeft(wallet.PublicKey[32:], "\x00"))
+
+	//钱包能够花费支付给自己地址的输出
+	scriptPubKey := NewTxOutput(5, string(wallet.GetAddress())).ScriptPubKey
+	tx := newSpendingTx(sequenceFinal, 0)
+	signature := signHash(privKey, tx.signatureHash(0, scriptPubKey))
+	assert.NoError(t, verifyScript(NewP2PKHScriptSig(signature, wallet.PublicKey), scriptPubKey, tx, 0))
+
+	//没有补足0字节的公钥被拒绝
+	unpadded := append(privKey.X.Bytes(), privKey.Y.Bytes()...)
+	assert.False(t, verifyHashSignature(unpadded, tx.signatureHash(0, scriptPubKey), signature))
+	unpaddedScript := NewP2PKHScript(HashPubKey(unpadded))
+	unpaddedSignature := signHash(privKey, tx.signatureHash(0, unpaddedScript))
+	assert.ErrorIs(t, verifyScript(NewP2PKHScriptSig(unpaddedSignature, unpadded), unpaddedScript, tx, 0), ErrScriptFailed)
+}
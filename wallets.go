@@ -48,9 +48,23 @@ func (ws *Wallets) GetAddresses() []string {
 	return addresses
 }
 
-// GetWallet 根据地址返回一个钱包
+// GetWallet 根据地址返回一个钱包，地址可以是旧写法
 func (ws Wallets) GetWallet(address string) Wallet {
-	return *ws.Wallets[address]
+	return *ws.Wallets[canonicalAddress(address)]
+}
+
+// loadWallet 从钱包文件中读取address的钱包，钱包文件中没有该地址时终止
+func loadWallet(address, nodeID string) *Wallet {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet, ok := wallets.Wallets[canonicalAddress(address)]
+	if !ok {
+		log.Panicf("钱包文件中没有地址 %s", address)
+	}
+
+	return wallet
 }
 
 // LoadFromFile 从文件读取wallets
@@ -74,7 +88,11 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 		log.Panic(err)
 	}
 
-	ws.Wallets = wallets.Wallets
+	//旧版本的钱包文件中，部分地址是旧写法（见decodeAddress），统一按标准写法重新索引
+	ws.Wallets = make(map[string]*Wallet)
+	for _, wallet := range wallets.Wallets {
+		ws.Wallets[string(wallet.GetAddress())] = wallet
+	}
 
 	return nil
 }